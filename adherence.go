@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// adherencePeriods — за сколько последних дней /adherence считает соблюдение режима
+var adherencePeriods = []int{7, 30}
+
+// adherenceBarWidth — число клеток в полосе соблюдения режима
+const adherenceBarWidth = 10
+
+// adherenceBar рисует долю принятых доз полосой из клеток: 🟩🟩🟩🟩🟩🟩🟩🟩⬜⬜
+func adherenceBar(percent int) string {
+	filled := (percent*adherenceBarWidth + 50) / 100
+	return strings.Repeat("🟩", filled) + strings.Repeat("⬜", adherenceBarWidth-filled)
+}
+
+// handleAdherence показывает по каждому лекарству, сколько доз принято из
+// отправленных за последние 7 и 30 дней (Storage.GetAdherence). Дозы, которые
+// ещё можно подтвердить, и пропущенные намеренно не учитываются — поэтому только
+// что добавленное напоминание не выглядит как 0%. Лекарства без доз за период
+// не показываются.
+func (b *Bot) handleAdherence(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	l := b.userLocale(chatID, defaultLocale)
+	now := b.now().In(l.Loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Loc)
+
+	// По каждому периоду — статистика лекарств; лекарства — из самого длинного периода, по алфавиту
+	periods := make([]map[string]Adherence, len(adherencePeriods))
+	for i, days := range adherencePeriods {
+		adherence, err := b.storage.GetAdherence(chatID, today.AddDate(0, 0, -(days-1)))
+		if err != nil {
+			log.Printf("Failed to get adherence: %v", err)
+			b.sendMessage(chatID, "Ошибка загрузки истории")
+			return
+		}
+		periods[i] = adherence
+	}
+	medicines := slices.Sorted(maps.Keys(periods[len(periods)-1]))
+
+	if len(medicines) == 0 {
+		b.sendMessage(chatID, fmt.Sprintf("За последние %d дней приёмов не записано. Статистика появится, "+
+			"когда пройдёт время подтверждения первой дозы", adherencePeriods[len(adherencePeriods)-1]))
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("📊 Соблюдение режима: принято доз из отправленных\n")
+	for _, medicine := range medicines {
+		text.WriteString(fmt.Sprintf("\n💊 %s\n", displayName(medicine)))
+		for i, days := range adherencePeriods {
+			a, ok := periods[i][medicine]
+			if !ok {
+				text.WriteString(fmt.Sprintf("    %d дн.: —\n", days))
+				continue
+			}
+			percent, p := adherencePercent(a.Taken, a.Scheduled-a.Taken)
+			text.WriteString(fmt.Sprintf("    %d дн.: %s %s (%d/%d)\n", days, adherenceBar(p), percent, a.Taken, a.Scheduled))
+		}
+	}
+	text.WriteString("\nДозы, которые ещё можно подтвердить, и пропущенные намеренно не учитываются")
+
+	b.sendLongMessage(chatID, text.String())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxDoseAmountLength — наибольшая длина дозировки в символах ("2 таблетки", "5 мл")
+const maxDoseAmountLength = 64
+
+// doseName — название лекарства с дозировкой для текста: "Аспирин, 2 таблетки".
+// Без дозировки — только название.
+func doseName(r Reminder) string {
+	if r.DoseAmount == "" {
+		return displayName(r.Medicine)
+	}
+	return displayName(r.Medicine) + ", " + isolateBidi(r.DoseAmount)
+}
+
+// askDoseAmount спрашивает в диалоге /add, сколько принимать за раз; шаг можно пропустить.
+// ID вопроса запоминается, чтобы убрать его кнопки, когда дозировку введут текстом.
+func (b *Bot) askDoseAmount(chatID int64, medicine string) {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏭ Пропустить", "amount_skip"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+		),
+	)
+
+	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("💊 %s\n\nСколько принимать за раз? Например: 2 таблетки или 5 мл", medicine))
+	reply.ReplyMarkup = keyboard
+	sent, err := b.api.Send(reply)
+	if err != nil {
+		log.Printf("Failed to send message: %v", err)
+		return
+	}
+
+	b.mu.Lock()
+	if p := b.pending[chatID]; p != nil && p.State == StateWaitingDoseAmount {
+		p.PromptMsgID = sent.MessageID
+	}
+	b.mu.Unlock()
+}
+
+// handleDoseAmountInput запоминает дозировку и переходит к выбору часа
+func (b *Bot) handleDoseAmountInput(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	amount := strings.TrimSpace(msg.Text)
+
+	if amount == "" {
+		b.sendMessage(chatID, "Напиши дозировку текстом или нажми \"Пропустить\":")
+		return
+	}
+	if utf8.RuneCountInString(amount) > maxDoseAmountLength {
+		b.sendMessage(chatID, fmt.Sprintf("Слишком длинно (макс %d символов). Попробуй короче:", maxDoseAmountLength))
+		return
+	}
+
+	// Дозировка принята — "Пропустить" и "Отмена" под вопросом больше не нужны
+	if prompt := b.setDoseAmount(chatID, amount); prompt != 0 {
+		b.removeButtons(chatID, prompt)
+	}
+}
+
+// handleDoseAmountSkip пропускает дозировку: напоминание будет только с названием
+func (b *Bot) handleDoseAmountSkip(chatID int64, messageID int) {
+	b.deleteMessage(chatID, messageID)
+	b.setDoseAmount(chatID, "")
+}
+
+// setDoseAmount сохраняет дозировку в диалоге /add и показывает выбор часа.
+// Возвращает ID вопроса о дозировке (0 — неизвестен).
+func (b *Bot) setDoseAmount(chatID int64, amount string) (promptMsgID int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.State != StateWaitingDoseAmount {
+		b.mu.Unlock()
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return 0
+	}
+	p.DoseAmount = amount
+	p.State = StateWaitingHour
+	medicine := p.Medicine
+	promptMsgID = p.PromptMsgID
+	p.PromptMsgID = 0
+	b.mu.Unlock()
+
+	b.showHourSelection(chatID, medicine)
+	return promptMsgID
+}
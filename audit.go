@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Действия администраторов в журнале admin_actions
+const (
+	auditUserLookup  = "user"
+	auditRefund      = "refund"
+	auditNotify      = "notify"
+	auditResend      = "resend"
+	auditMaintenance = "maintenance"
+	auditCheckSlot   = "checkslot"
+	auditIntegrity   = "integrity"
+)
+
+// Сколько записей показывает /audit
+const (
+	defaultAuditLimit = 20
+	maxAuditLimit     = 100
+)
+
+// audit записывает действие администратора. Ошибка записи не мешает самому действию.
+func (b *Bot) audit(adminID int64, action, details string) {
+	if err := b.storage.LogAdminAction(adminID, action, details); err != nil {
+		log.Printf("Failed to log admin action %s: %v", action, err)
+	}
+}
+
+// handleAudit показывает последние действия администраторов:
+// /audit [действие] [количество], например /audit refund 50
+func (b *Bot) handleAudit(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	limit := defaultAuditLimit
+	action := ""
+	for _, arg := range strings.Fields(msg.CommandArguments()) {
+		if n, err := strconv.Atoi(arg); err == nil {
+			if n < 1 {
+				b.sendMessage(chatID, "Количество записей должно быть положительным")
+				return
+			}
+			limit = min(n, maxAuditLimit)
+			continue
+		}
+		action = strings.ToLower(arg)
+	}
+
+	actions, err := b.storage.GetAdminActions(limit, action)
+	if err != nil {
+		log.Printf("Failed to get admin actions: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки журнала")
+		return
+	}
+	if len(actions) == 0 {
+		b.sendMessage(chatID, "Журнал действий пуст")
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("📜 Действия администраторов\n\n")
+	for _, a := range actions {
+		text.WriteString(fmt.Sprintf("%s — %d — %s", a.CreatedAt.In(b.loc).Format("02.01 15:04"), a.AdminID, a.Action))
+		if a.Details != "" {
+			text.WriteString(": " + a.Details)
+		}
+		text.WriteString("\n")
+	}
+	b.sendLongMessage(chatID, text.String())
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net/url"
+	"net"
+	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -19,25 +25,179 @@ type Reminder struct {
 	Medicine   string
 	Hour       int
 	Minute     int
-	CourseDays int // Количество дней курса (0 = бесконечно)
-	DosesTaken int // Количество отправленных напоминаний (счётчик)
+	CourseDays int    // Длина курса (0 = бесконечно): приёмов или дней, см. CourseType
+	CourseType string // CourseByDoses или CourseByDays
+	DosesTaken int    // Количество отправленных напоминаний (счётчик)
+
+	// Привязка ко времени пробуждения/сна. Для привязанных напоминаний
+	// Hour/Minute пересчитываются при изменении /wake или /sleep,
+	// поэтому планировщик работает с ними как с обычным фиксированным временем.
+	Anchor       string // "" — фиксированное время, AnchorWake или AnchorSleep
+	AnchorOffset int    // Смещение относительно якоря в минутах
+
+	LastTakenAt *time.Time // Последний подтверждённый приём (nil — ещё не принимал)
+	StartsAt    time.Time  // Первый запланированный приём — начало первого дня курса
+
+	SnoozeMinutes int  // Основная длительность "отложить" (0 — общая настройка)
+	Paused        bool // Напоминание приостановлено пользователем (остальные продолжают приходить)
+
+	FireDate *time.Time // Дата разового напоминания (nil — ежедневное)
+
+	GroupID *int // Группа лекарств, которые принимаются вместе (nil — без группы)
+
+	Timezone string // Свой часовой пояс напоминания ("" — пояс пользователя)
+
+	Source string // Откуда создано: ReminderSourceChat, ReminderSourceWebApp или ReminderSourceImport
+
+	Important      bool // Напоминать о неподтверждённой предыдущей дозе
+	RequireConfirm bool // Кнопка "Принял"; без неё доза засчитывается при отправке
+
+	IntervalMinutes int // Приём каждые N минут начиная с Hour:Minute (0 — раз в день), см. DailyTimes
+	WeekdayMask     int // Дни недели приёма: бит 0 — понедельник … бит 6 — воскресенье (allWeekdays — каждый день)
+
+	DoseAmount string // Сколько принимать за раз: "2 таблетки", "5 мл" ("" — не указано)
 }
 
+// Источники создания напоминаний
+const (
+	ReminderSourceChat   = "chat"   // диалог /add
+	ReminderSourceWebApp = "webapp" // данные Web App
+	ReminderSourceImport = "import" // назначение врача, вставленное текстом (/import)
+)
+
+// Как считается курс
+const (
+	CourseByDoses = "doses" // завершается, когда подтверждено CourseDays приёмов
+	CourseByDays  = "days"  // завершается через CourseDays календарных дней от первого приёма
+)
+
+// Якоря для напоминаний относительно распорядка дня
+const (
+	AnchorWake  = "wake"
+	AnchorSleep = "sleep"
+)
+
 func (r Reminder) TimeString() string {
-	return fmt.Sprintf("%02d:%02d", r.Hour, r.Minute)
+	return formatTime(Locale{Lang: defaultLocale}, r.Hour, r.Minute)
 }
 
-// CourseString возвращает строку прогресса курса
-func (r Reminder) CourseString() string {
-	if r.CourseDays == 0 {
+// TimeLabel возвращает время вместе с интервалом, днями недели, привязкой или датой
+// разового напоминания и своим часовым поясом: "08:00 (пробуждение +1 ч)",
+// "08:00, 20.10 (разово)", "08:00, каждые 8 ч", "08:00, пн, ср, пт", "09:00 🌍 Europe/Berlin"
+func (r Reminder) TimeLabel() string {
+	label := r.TimeString()
+	if r.IntervalMinutes > 0 {
+		label += ", " + formatInterval(r.IntervalMinutes)
+	}
+	if r.FireDate == nil && r.weekdays() != allWeekdays {
+		label += ", " + formatWeekdays(r.weekdays())
+	}
+	switch {
+	case r.FireDate != nil:
+		label = fmt.Sprintf("%s, %s (разово)", label, formatShortDate(Locale{Lang: defaultLocale}, *r.FireDate))
+	case r.Anchor != "":
+		label = fmt.Sprintf("%s (%s)", label, r.AnchorString())
+	}
+	if r.Timezone != "" {
+		label += " 🌍 " + r.Timezone
+	}
+	return label
+}
+
+// AnchorString возвращает описание привязки, например "пробуждение +1 ч"
+func (r Reminder) AnchorString() string {
+	switch r.Anchor {
+	case AnchorWake:
+		return "пробуждение " + formatOffset(r.AnchorOffset)
+	case AnchorSleep:
+		return "сон " + formatOffset(r.AnchorOffset)
+	}
+	return ""
+}
+
+// formatOffset форматирует смещение в минутах: "+1 ч 30 мин", "−30 мин", "±0"
+func formatOffset(minutes int) string {
+	if minutes == 0 {
+		return "±0"
+	}
+	sign := "+"
+	if minutes < 0 {
+		sign = "−"
+		minutes = -minutes
+	}
+	h, m := minutes/60, minutes%60
+	switch {
+	case h == 0:
+		return fmt.Sprintf("%s%d мин", sign, m)
+	case m == 0:
+		return fmt.Sprintf("%s%d ч", sign, h)
+	}
+	return fmt.Sprintf("%s%d ч %d мин", sign, h, m)
+}
+
+// CourseString возвращает строку прогресса курса на момент now:
+// принятые дозы ("3/10") или прошедшие дни для курса по дням ("3/10 дн.")
+func (r Reminder) CourseString(now time.Time) string {
+	switch {
+	case r.CourseDays == 0:
 		return fmt.Sprintf("%d/∞", r.DosesTaken)
+	case r.CourseType == CourseByDays:
+		return fmt.Sprintf("%d/%d дн.", min(r.CourseDay(now), r.CourseDays), r.CourseDays)
 	}
 	return fmt.Sprintf("%d/%d", r.DosesTaken, r.CourseDays)
 }
 
-// IsCompleted проверяет, завершён ли курс
-func (r Reminder) IsCompleted() bool {
-	return r.CourseDays > 0 && r.DosesTaken >= r.CourseDays
+// CourseDay возвращает номер дня курса на момент now (0 — курс ещё не начался).
+// Дни отсчитываются от первого запланированного приёма, а не от полуночи:
+// день N охватывает [StartsAt + (N-1)×24ч, StartsAt + N×24ч). Так курс,
+// добавленный в 23:00 с приёмом в 08:00, начинается утром, а не "сгорает" за час.
+func (r Reminder) CourseDay(now time.Time) int {
+	return courseDay(r.StartsAt, now)
+}
+
+func courseDay(start, now time.Time) int {
+	if now.Before(start) {
+		return 0
+	}
+	return int(now.Sub(start)/(24*time.Hour)) + 1
+}
+
+// firstOccurrence возвращает ближайший момент hour:minute строго после now
+// (в часовом поясе now) — первый приём нового напоминания
+func firstOccurrence(now time.Time, hour, minute int) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// courseLengthString описывает длину курса: "14 приёмов", "14 дней по календарю", "♾ Бесконечно"
+func courseLengthString(courseDays int, courseType string) string {
+	switch {
+	case courseDays == 0:
+		return "♾ Бесконечно"
+	case courseType == CourseByDays:
+		return fmt.Sprintf("%d дней по календарю", courseDays)
+	}
+	return fmt.Sprintf("%d приёмов", courseDays)
+}
+
+// IsCompleted проверяет, завершён ли курс на момент now
+func (r Reminder) IsCompleted(now time.Time) bool {
+	if r.CourseDays == 0 {
+		return false
+	}
+	if r.CourseType == CourseByDays {
+		return r.CourseDay(now) > r.CourseDays
+	}
+	return r.DosesTaken >= r.CourseDays
+}
+
+// IsRunnable проверяет, что напоминание должно приходить: не на паузе и курс не завершён.
+// Активность пользователя (/stop) проверяется отдельно.
+func (r Reminder) IsRunnable(now time.Time) bool {
+	return !r.Paused && !r.IsCompleted(now)
 }
 
 // UserState определяет текущее состояние диалога
@@ -50,14 +210,33 @@ const (
 	StateWaitingMinute
 	StateWaitingCourse       // Ожидание выбора длительности курса
 	StateWaitingCustomCourse // Ожидание ввода своего количества дней
+	StateWaitingCustomTime   // Ожидание ввода своего времени ЧЧ:ММ
+
+	StateWaitingReminderTimezone // Ожидание ввода часового пояса напоминания ReminderID
+	StateWaitingReminderCourse   // Ожидание ввода новой длины курса напоминания ReminderID
+	StateWaitingCourseType       // Ожидание выбора, как считать курс CourseDays
+	StateWaitingDonateAmount     // Ожидание ввода своей суммы доната в звёздах
+	StateWaitingImport           // Ожидание текста назначения врача (/import)
+	StateWaitingImportConfirm    // Ожидание подтверждения напоминаний из назначения Import
+	StateWaitingDoseAmount       // Ожидание дозировки в диалоге /add (можно пропустить)
 )
 
 // User хранит информацию о пользователе
 type User struct {
 	ChatID    int64
-	Active    bool
+	Active    bool // Напоминания не отключены через /stop (пауза отдельных напоминаний — Reminder.Paused)
 	Reminders []Reminder
 	NextID    int
+	WakeTime  *int // Время пробуждения в минутах от полуночи (nil — не задано)
+	SleepTime *int // Время отхода ко сну в минутах от полуночи (nil — не задано)
+
+	VacationFrom  *time.Time // Начало отпуска (nil — не в отпуске)
+	VacationUntil *time.Time // Последний день отпуска включительно
+
+	WebhookURL string // Webhook для уведомлений о пропущенных дозах ("" — не настроен)
+
+	Username  string // Последний известный username в Telegram (может быть пустым)
+	FirstName string // Последнее известное имя в Telegram
 
 	// Состояние для пошагового создания напоминания
 	State           UserState
@@ -69,23 +248,277 @@ type User struct {
 
 // PendingReminder хранит временное состояние создания напоминания
 type PendingReminder struct {
-	State    UserState
-	Medicine string
-	Hour     int
-	Minute   int
-	MsgID    int
+	State           UserState
+	Medicine        string
+	Hour            int
+	Minute          int
+	Anchor          string
+	AnchorOffset    int
+	MsgID           int
+	StartedAt       time.Time  // Когда начат диалог — для защиты от повторных нажатий и устаревания (pendingDialogTTL)
+	ReminderID      int        // Напоминание, настройку или время которого ждёт диалог (0 — диалог /add)
+	CourseDays      int        // Выбранная длина курса, пока спрашиваем, как его считать
+	CourseType      string     // Способ подсчёта курса копии (CopyCourse)
+	CopyCourse      bool       // Копия напоминания: курс взят у исходного, после времени сразу сохраняем
+	IntervalMinutes int        // Выбранный интервал приёма "каждые N часов" (0 — раз в день)
+	WeekdayMask     int        // Выбранные дни недели (0 — не выбирали, каждый день), см. weekdays
+	DoseAmount      string     // Дозировка ("" — пропущена)
+	Import          []Reminder // Разобранное назначение, ждущее подтверждения (не сохраняется при остановке)
+	PromptMsgID     int        // Приглашение ввести название или дозировку — удаляется, если диалог устарел (не сохраняется)
+}
+
+// pendingSnapshot возвращает копию состояния диалога пользователя
+func (b *Bot) pendingSnapshot(chatID int64) (PendingReminder, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	p := b.pending[chatID]
+	if p == nil {
+		return PendingReminder{}, false
+	}
+	return *p, true
 }
 
+// toReminder собирает напоминание из состояния диалога
+func (p *PendingReminder) toReminder(courseDays int) Reminder {
+	return Reminder{
+		Medicine:     p.Medicine,
+		Hour:         p.Hour,
+		Minute:       p.Minute,
+		CourseDays:   courseDays,
+		Anchor:       p.Anchor,
+		AnchorOffset: p.AnchorOffset,
+
+		IntervalMinutes: p.IntervalMinutes,
+		WeekdayMask:     p.weekdays(),
+
+		DoseAmount: p.DoseAmount,
+	}
+}
+
+// defaultTimezone — часовой пояс, в котором задаётся время напоминаний
+const defaultTimezone = "Asia/Yekaterinburg"
+
 type Bot struct {
 	api     *tgbotapi.BotAPI
 	storage *Storage
-	pending map[int64]*PendingReminder // временные состояния диалогов
+	// pending — временные состояния диалогов. Доступ только под mu:
+	// поля *PendingReminder меняются исключительно под mu.Lock(),
+	// а для чтения без изменения берётся копия через pendingSnapshot —
+	// указатель из карты не должен использоваться после снятия блокировки.
+	pending map[int64]*PendingReminder
 	mu      sync.RWMutex
-	adminID int64
+	// savedPending — диалоги в том виде, в каком они записаны в pending_dialogs (под mu)
+	savedPending map[int64]PendingReminder
+	// persistMu упорядочивает записи в pending_dialogs из обработки обновлений и очистки устаревших диалогов
+	persistMu sync.Mutex
+	adminID   int64
+	loc       *time.Location
+	clock     Clock // источник текущего времени; в тестах подменяется
+	dryRun    bool  // DRY_RUN: планировщик и /notify только пишут в лог, что отправили бы
+
+	// MAINTENANCE и MAINTENANCE_PAUSE_SCHEDULER, меняются командой /maintenance:
+	// изменения недоступны, чтение работает; планировщик по желанию останавливается
+	maintenance               atomic.Bool
+	maintenancePauseScheduler atomic.Bool
+
+	reactivateOnAdd reactivateMode // REACTIVATE_ON_ADD: что делать, если напоминание добавляет отключившийся пользователь
+
+	interactions []drugInteraction // INTERACTIONS_FILE: лекарства, которые не принимают одновременно
+
+	noConfirmStatus string // NO_CONFIRM_MODE: статус дозы напоминания без подтверждения
+
+	courseEndNoticeDays int // COURSE_END_NOTICE_DAYS: за сколько дней до конца курса предупредить (0 — не предупреждать)
+
+	hourRanges    []hourRange     // ряды кнопок выбора часа в /add
+	snoozeMinutes []int           // варианты "отложить" на кнопках напоминания
+	snoozes       map[int]*snooze // отложенные напоминания по ID напоминания
+	snoozeMu      sync.Mutex
+
+	sendConcurrency int          // SEND_CONCURRENCY: потоков рассылки слота
+	sendLimiter     *tokenBucket // SEND_RATE: общий лимит сообщений в секунду для рассылок
+
+	nudgeMinutes int // NUDGE_MINUTES: через сколько минут повторить неподтверждённое напоминание (0 — не повторять)
+}
+
+// snooze — отложенное напоминание, ожидающее повторной отправки
+type snooze struct {
+	timer  *time.Timer
+	chatID int64
+	lang   string
+	slot   time.Time
+	fireAt time.Time
+}
+
+// defaultSnoozeMinutes — варианты "отложить", если SNOOZE_MINUTES не задан
+var defaultSnoozeMinutes = []int{10, 60}
+
+// Допустимый диапазон длительности "отложить" в минутах
+const (
+	minSnoozeMinutes = 1
+	maxSnoozeMinutes = 12 * 60
+)
+
+// reminderSnoozeChoices — варианты основной длительности "отложить" в настройках напоминания
+var reminderSnoozeChoices = []int{5, 10, 15, 30, 60, 120, 180, 240}
+
+// snoozeOptions возвращает длительности для кнопок напоминания:
+// основная длительность напоминания первой, затем общие варианты без повтора
+func snoozeOptions(primary int, defaults []int) []int {
+	if primary == 0 {
+		return defaults
+	}
+
+	result := []int{primary}
+	for _, m := range defaults {
+		if m != primary {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// parseSnoozeMinutes разбирает SNOOZE_MINUTES вида "10,60".
+// Длительности настраиваются отдельно от подписей — подписи берутся из каталога сообщений.
+func parseSnoozeMinutes(value string) []int {
+	if value == "" {
+		return defaultSnoozeMinutes
+	}
+
+	var result []int
+	for _, part := range strings.Split(value, ",") {
+		minutes, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || minutes < minSnoozeMinutes || minutes > maxSnoozeMinutes {
+			log.Printf("Ignoring invalid snooze duration %q", part)
+			continue
+		}
+		result = append(result, minutes)
+	}
+	if len(result) == 0 {
+		return defaultSnoozeMinutes
+	}
+	return result
+}
+
+// defaultCourseEndNoticeDays — за сколько дней до конца курса предупреждать,
+// если COURSE_END_NOTICE_DAYS не задан
+const defaultCourseEndNoticeDays = 3
+
+// maxCourseEndNoticeDays — верхняя граница COURSE_END_NOTICE_DAYS
+const maxCourseEndNoticeDays = 30
+
+// parseCourseEndNoticeDays разбирает COURSE_END_NOTICE_DAYS; "0" отключает предупреждение
+func parseCourseEndNoticeDays(value string) int {
+	if value == "" {
+		return defaultCourseEndNoticeDays
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || days < 0 || days > maxCourseEndNoticeDays {
+		log.Printf("Ignoring invalid COURSE_END_NOTICE_DAYS %q", value)
+		return defaultCourseEndNoticeDays
+	}
+	return days
+}
+
+// maxCourseDaysLimit — верхняя граница MAX_COURSE_DAYS: десять лет ежедневного приёма
+const maxCourseDaysLimit = 3650
+
+// parseMaxCourseDays разбирает MAX_COURSE_DAYS — наибольшую длину курса в днях
+func parseMaxCourseDays(value string) int {
+	if value == "" {
+		return defaultMaxCourseDays
+	}
+
+	days, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || days < 1 || days > maxCourseDaysLimit {
+		log.Printf("Ignoring invalid MAX_COURSE_DAYS %q", value)
+		return defaultMaxCourseDays
+	}
+	return days
+}
+
+// hourRange — ряд кнопок выбора часа, границы включительно
+type hourRange struct {
+	From, To int
+}
+
+// defaultHourRanges — утро, день и вечер; ночные часы доступны через "Все часы"
+var defaultHourRanges = []hourRange{{6, 11}, {12, 17}, {18, 23}}
+
+// maxHourRowButtons — больше кнопок в ряду Telegram не показывает
+const maxHourRowButtons = 8
+
+// parseHourRanges разбирает HOUR_RANGES вида "6-11,12-17,18-23"
+func parseHourRanges(value string) []hourRange {
+	if value == "" {
+		return defaultHourRanges
+	}
+
+	var result []hourRange
+	for _, part := range strings.Split(value, ",") {
+		fromStr, toStr, ok := strings.Cut(strings.TrimSpace(part), "-")
+		from, errFrom := strconv.Atoi(strings.TrimSpace(fromStr))
+		to, errTo := strconv.Atoi(strings.TrimSpace(toStr))
+		if !ok || errFrom != nil || errTo != nil || from < 0 || to > 23 || from > to || to-from+1 > maxHourRowButtons {
+			log.Printf("Ignoring invalid hour range %q", part)
+			continue
+		}
+		result = append(result, hourRange{From: from, To: to})
+	}
+	if len(result) == 0 {
+		return defaultHourRanges
+	}
+	return result
+}
+
+// allHourRanges — все 24 часа рядами по 6
+var allHourRanges = []hourRange{{0, 5}, {6, 11}, {12, 17}, {18, 23}}
+
+// coversAllHours проверяет, что в рядах есть каждый час суток
+func coversAllHours(ranges []hourRange) bool {
+	var seen [24]bool
+	for _, r := range ranges {
+		for h := r.From; h <= r.To; h++ {
+			seen[h] = true
+		}
+	}
+	for _, ok := range seen {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// userLocale возвращает настройки отображения дат и времени
+// для языка и часового пояса пользователя
+func (b *Bot) userLocale(chatID int64, lang string) Locale {
+	return newLocale(lang, b.userLoc(chatID))
+}
+
+// botCommands — команды меню; описания берутся из каталога по ключу "command.<имя>"
+var botCommands = []string{
+	"start", "add", "import", "list", "clear", "wake", "sleep", "vacation",
+	"report", "mystats", "adherence", "history", "timezone", "shift", "prealert", "settings", "stop", "donate", "stats",
+}
+
+// localizedCommands возвращает команды меню с описаниями на языке lang
+func localizedCommands(lang string) []tgbotapi.BotCommand {
+	commands := make([]tgbotapi.BotCommand, len(botCommands))
+	for i, name := range botCommands {
+		commands[i] = tgbotapi.BotCommand{Command: name, Description: T(lang, "command."+name)}
+	}
+	return commands
 }
 
 func NewBot(token string, storage *Storage) (*Bot, error) {
-	api, err := tgbotapi.NewBotAPI(token)
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load timezone: %w", err)
+	}
+
+	api, err := newBotAPIWithRetry(token)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot: %w", err)
 	}
@@ -98,17 +531,16 @@ func NewBot(token string, storage *Storage) (*Bot, error) {
 		log.Printf("Failed to set bot description: %v", err)
 	}
 
-	commands := tgbotapi.NewSetMyCommands(
-		tgbotapi.BotCommand{Command: "start", Description: "Начать работу"},
-		tgbotapi.BotCommand{Command: "add", Description: "Добавить напоминание"},
-		tgbotapi.BotCommand{Command: "list", Description: "Мои напоминания"},
-		tgbotapi.BotCommand{Command: "stop", Description: "Отключить напоминания"},
-		tgbotapi.BotCommand{Command: "donate", Description: "Поддержать автора"},
-		tgbotapi.BotCommand{Command: "stats", Description: "Статистика бота"},
-	)
-	if _, err := api.Request(commands); err != nil {
+	// Список команд без языка — для клиентов на языках, которых нет в каталоге
+	if _, err := api.Request(tgbotapi.NewSetMyCommands(localizedCommands(defaultLocale)...)); err != nil {
 		log.Printf("Failed to set bot commands: %v", err)
 	}
+	for lang := range messages {
+		commands := tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeDefault(), lang, localizedCommands(lang)...)
+		if _, err := api.Request(commands); err != nil {
+			log.Printf("Failed to set bot commands for %s: %v", lang, err)
+		}
+	}
 
 	// Устанавливаем Menu Button
 	// Если есть WEBAPP_URL - показываем кнопку Web App, иначе - меню команд
@@ -130,205 +562,712 @@ func NewBot(token string, storage *Storage) (*Bot, error) {
 		log.Printf("Admin ID set to: %d", adminID)
 	}
 
-	return &Bot{
-		api:     api,
-		storage: storage,
-		pending: make(map[int64]*PendingReminder),
-		adminID: adminID,
-	}, nil
-}
+	dryRun, _ := strconv.ParseBool(os.Getenv("DRY_RUN"))
+	if dryRun {
+		log.Printf("[DRY RUN] Dry-run mode enabled: reminders and broadcasts are logged, not sent")
+	}
 
-func (b *Bot) HandleUpdates() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	maintenance, _ := strconv.ParseBool(os.Getenv("MAINTENANCE"))
+	pauseScheduler, _ := strconv.ParseBool(os.Getenv("MAINTENANCE_PAUSE_SCHEDULER"))
+	if maintenance {
+		log.Printf("[MAINTENANCE] Maintenance mode enabled: changes are rejected (scheduler paused: %v)", pauseScheduler)
+	}
 
-	updates := b.api.GetUpdatesChan(u)
+	bot := &Bot{
+		api:          api,
+		storage:      storage,
+		pending:      make(map[int64]*PendingReminder),
+		savedPending: make(map[int64]PendingReminder),
+		adminID:      adminID,
 
-	for update := range updates {
-		// Обработка pre-checkout запросов (для Telegram Stars)
-		if update.PreCheckoutQuery != nil {
-			b.handlePreCheckout(update.PreCheckoutQuery)
-			continue
-		}
+		loc:    loc,
+		clock:  realClock{},
+		dryRun: dryRun,
 
-		// Обработка callback-кнопок
-		if update.CallbackQuery != nil {
-			log.Printf("[CALLBACK] user=%s (id=%d) data=%s",
-				update.CallbackQuery.From.UserName,
-				update.CallbackQuery.From.ID,
-				update.CallbackQuery.Data)
-			b.handleCallback(update.CallbackQuery)
-			continue
-		}
+		reactivateOnAdd: parseReactivateMode(os.Getenv("REACTIVATE_ON_ADD")),
 
-		if update.Message == nil {
-			continue
-		}
+		interactions: loadInteractions(os.Getenv("INTERACTIONS_FILE")),
 
-		// Обработка успешного платежа
-		if update.Message.SuccessfulPayment != nil {
-			b.handleSuccessfulPayment(update.Message)
-			continue
-		}
+		noConfirmStatus: parseNoConfirmStatus(os.Getenv("NO_CONFIRM_MODE")),
 
-		chatID := update.Message.Chat.ID
-		userName := update.Message.From.UserName
-		if userName == "" {
-			userName = update.Message.From.FirstName
-		}
-		log.Printf("[MSG] user=%s (id=%d) text=%q", userName, chatID, update.Message.Text)
+		courseEndNoticeDays: parseCourseEndNoticeDays(os.Getenv("COURSE_END_NOTICE_DAYS")),
 
-		// Проверяем состояние пользователя (из pending map)
-		b.mu.RLock()
-		pending := b.pending[chatID]
-		state := StateNone
-		if pending != nil {
-			state = pending.State
-		}
-		b.mu.RUnlock()
+		hourRanges:    parseHourRanges(os.Getenv("HOUR_RANGES")),
+		snoozeMinutes: parseSnoozeMinutes(os.Getenv("SNOOZE_MINUTES")),
+		snoozes:       make(map[int]*snooze),
 
-		// Если ждём ввода названия лекарства
-		if state == StateWaitingMedicine && !update.Message.IsCommand() {
-			b.handleMedicineInput(update.Message)
-			continue
-		}
+		sendConcurrency: parseSendConcurrency(os.Getenv("SEND_CONCURRENCY")),
 
-		// Если ждём ввода своего количества дней курса
-		if state == StateWaitingCustomCourse && !update.Message.IsCommand() {
-			b.handleCustomCourseInput(update.Message)
-			continue
-		}
+		nudgeMinutes: parseNudgeMinutes(os.Getenv("NUDGE_MINUTES")),
+	}
+	sendRate := parseSendRate(os.Getenv("SEND_RATE"))
+	bot.sendLimiter = newTokenBucket(sendRate, sendRate)
+	bot.maintenance.Store(maintenance)
+	bot.maintenancePauseScheduler.Store(pauseScheduler)
+	return bot, nil
+}
 
-		if update.Message.IsCommand() {
-			// Сбрасываем состояние при любой команде
-			b.mu.Lock()
-			delete(b.pending, chatID)
-			b.mu.Unlock()
+// Повторы при подключении к Telegram API на старте
+const (
+	botInitAttempts   = 6
+	botInitBackoff    = 2 * time.Second
+	botInitMaxBackoff = 30 * time.Second
+)
 
-			switch update.Message.Command() {
-			case "start":
-				b.handleStart(update.Message)
-			case "add":
-				b.handleAdd(update.Message)
-			case "list":
-				b.handleList(update.Message)
-			case "stop":
-				b.handleStop(update.Message)
-			case "donate":
-				b.handleDonate(update.Message)
-			case "stats":
-				b.handleStats(update.Message)
-			case "notify":
-				b.handleNotify(update.Message)
-			}
-			continue
+// newBotAPIWithRetry подключается к Telegram API, повторяя попытки с
+// экспоненциальной задержкой при сетевых и серверных ошибках.
+// Ошибки авторизации (неверный токен) не повторяются.
+func newBotAPIWithRetry(token string) (*tgbotapi.BotAPI, error) {
+	backoff := botInitBackoff
+
+	for attempt := 1; ; attempt++ {
+		api, err := tgbotapi.NewBotAPIWithClient(token, tgbotapi.APIEndpoint, newTimeoutClient(apiRequestTimeout))
+		if err == nil {
+			return api, nil
 		}
 
-		// Обработка нажатий reply-кнопок
-		text := update.Message.Text
-		switch {
-		case strings.Contains(text, "Добавить"):
-			b.handleAdd(update.Message)
-		case strings.Contains(text, "напоминания"):
-			b.handleList(update.Message)
-		case strings.Contains(text, "Отключить"):
-			b.handleStop(update.Message)
-		case strings.Contains(text, "Включить"):
-			b.handleStart(update.Message)
-		case strings.Contains(text, "Статистика"):
-			b.handleStats(update.Message)
-		case strings.Contains(text, "Рассылка"):
-			b.handleNotifyPrompt(update.Message)
-		case strings.ToLower(text) == "привет":
-			b.sendMessage(chatID, "Привет! Я бот для напоминаний о лекарствах. Используй /start чтобы начать.")
+		if code := apiErrorCode(err); code == http.StatusUnauthorized || code == http.StatusNotFound {
+			return nil, fmt.Errorf("invalid bot token: %w", err)
+		}
+		if attempt == botInitAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("Failed to connect to Telegram API (attempt %d/%d): %v; retrying in %s",
+			attempt, botInitAttempts, err, backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > botInitMaxBackoff {
+			backoff = botInitMaxBackoff
 		}
 	}
 }
 
-func (b *Bot) handleCallback(callback *tgbotapi.CallbackQuery) {
-	chatID := callback.Message.Chat.ID
-	data := callback.Data
+// apiRequestTimeout — максимальное время одного запроса к Telegram API.
+// Long polling (getUpdates) ограничивается своим собственным таймаутом.
+const apiRequestTimeout = 10 * time.Second
 
-	// Подтверждаем получение callback
-	b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+// timeoutClient ограничивает время каждого запроса к Telegram API,
+// чтобы зависший запрос не блокировал рассылку остальным пользователям.
+type timeoutClient struct {
+	client  *http.Client
+	timeout time.Duration
+}
 
-	switch {
-	case strings.HasPrefix(data, "hour_"):
-		// Выбран час
-		hourStr := strings.TrimPrefix(data, "hour_")
-		hour, _ := strconv.Atoi(hourStr)
-		b.handleHourSelected(chatID, callback.Message.MessageID, hour)
+func newTimeoutClient(timeout time.Duration) *timeoutClient {
+	return &timeoutClient{client: &http.Client{}, timeout: timeout}
+}
 
-	case strings.HasPrefix(data, "time_"):
-		// Выбрано полное время (час:минута)
-		timeStr := strings.TrimPrefix(data, "time_")
-		parts := strings.Split(timeStr, ":")
-		if len(parts) == 2 {
-			hour, _ := strconv.Atoi(parts[0])
-			minute, _ := strconv.Atoi(parts[1])
-			b.handleTimeSelected(chatID, callback.Message.MessageID, hour, minute)
-		}
+func (c *timeoutClient) Do(req *http.Request) (*http.Response, error) {
+	// getUpdates держит соединение до u.Timeout секунд — его не ограничиваем
+	if strings.HasSuffix(req.URL.Path, "/getUpdates") {
+		return c.client.Do(req)
+	}
 
-	case strings.HasPrefix(data, "del_"):
-		// Удаление напоминания
-		idStr := strings.TrimPrefix(data, "del_")
-		id, _ := strconv.Atoi(idStr)
-		b.handleDeleteReminder(chatID, callback.Message.MessageID, id)
+	ctx, cancel := context.WithTimeout(req.Context(), c.timeout)
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Контекст отменяется после чтения ответа, иначе тело обрежется
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
 
-	case strings.HasPrefix(data, "course_"):
-		// Выбор длительности курса
-		courseStr := strings.TrimPrefix(data, "course_")
-		if courseStr == "custom" {
-			// Пользователь хочет ввести своё значение
-			b.mu.Lock()
-			if p := b.pending[chatID]; p != nil {
-				p.State = StateWaitingCustomCourse
-				p.MsgID = callback.Message.MessageID
-			}
-			b.mu.Unlock()
-			b.deleteMessage(chatID, callback.Message.MessageID)
-			b.sendMessage(chatID, "Введи количество дней курса (число от 1 до 365):")
-		} else {
-			courseDays, _ := strconv.Atoi(courseStr)
-			b.handleCourseSelected(chatID, callback.Message.MessageID, courseDays)
-		}
+// cancelOnClose отменяет контекст запроса при закрытии тела ответа
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
 
-	case strings.HasPrefix(data, "taken_"):
-		// Подтверждение приёма лекарства
-		idStr := strings.TrimPrefix(data, "taken_")
-		id, _ := strconv.Atoi(idStr)
-		b.handleTakenConfirm(chatID, callback.Message.MessageID, id)
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
 
-	case strings.HasPrefix(data, "stars_"):
-		// Выбор суммы доната
-		amountStr := strings.TrimPrefix(data, "stars_")
-		amount, _ := strconv.Atoi(amountStr)
-		b.deleteMessage(chatID, callback.Message.MessageID)
-		b.sendStarsInvoice(chatID, amount)
+// isTimeout проверяет, что запрос прерван по таймауту
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
 
-	case data == "cancel":
-		b.mu.Lock()
-		delete(b.pending, chatID)
-		b.mu.Unlock()
-		b.deleteMessage(chatID, callback.Message.MessageID)
-		b.sendMessage(chatID, "Отменено")
+// apiErrorCode возвращает код ошибки Telegram API (0 — ошибка не от API, например сетевая)
+func apiErrorCode(err error) int {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code
 	}
+	return 0
 }
 
-func (b *Bot) handleStart(msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
+// isBlocked проверяет, что Telegram отказал в отправке (403): пользователь
+// заблокировал бота или удалил аккаунт
+func isBlocked(err error) bool {
+	return apiErrorCode(err) == http.StatusForbidden
+}
 
-	if _, err := b.storage.GetOrCreateUser(chatID); err != nil {
-		log.Printf("Failed to create user %d: %v", chatID, err)
+// deactivateIfBlocked отключает напоминания пользователю, заблокировавшему бота:
+// иначе каждая отправка ему будет снова и снова получать 403. После разблокировки
+// /start предложит включить напоминания. true — ошибка означает блокировку.
+func (b *Bot) deactivateIfBlocked(chatID int64, err error) bool {
+	if !isBlocked(err) {
+		return false
 	}
-	if err := b.storage.SetUserActive(chatID, true); err != nil {
-		log.Printf("Failed to set user active %d: %v", chatID, err)
+	log.Printf("User %d blocked the bot, deactivating: %v", chatID, err)
+	if err := b.storage.SetUserActive(chatID, false); err != nil {
+		log.Printf("Failed to deactivate blocked user %d: %v", chatID, err)
 	}
+	return true
+}
 
-	text := "Привет! Я помогу тебе не забывать принимать лекарства.\n\n" +
-		"Используй кнопки ниже или команды:\n" +
-		"/add — добавить напоминание\n" +
-		"/list — список напоминаний"
+// HandleUpdates обрабатывает обновления, пока не будет отменён ctx
+func (b *Bot) HandleUpdates(ctx context.Context) {
+	updates := b.pollUpdates(ctx, 60)
+
+	go b.evictStalePending(ctx)
+
+	for in := range updates {
+		b.handleUpdate(in)
+		// Шаг диалога /add сохраняется сразу, чтобы диалог пережил перезапуск
+		b.persistPending(updateChatID(in.Update))
+	}
+}
+
+// handleUpdate обрабатывает одно обновление: платёж, кнопку, команду или шаг диалога
+func (b *Bot) handleUpdate(in incomingUpdate) {
+	update := in.Update
+
+	// Обработка pre-checkout запросов (для Telegram Stars)
+	if update.PreCheckoutQuery != nil {
+		if b.inMaintenance() {
+			b.rejectPreCheckout(update.PreCheckoutQuery, maintenanceText)
+			return
+		}
+		b.handlePreCheckout(update.PreCheckoutQuery)
+		return
+	}
+
+	// Обработка callback-кнопок
+	if update.CallbackQuery != nil {
+		from := update.CallbackQuery.From
+		log.Printf("[CALLBACK] user=%s (id=%d) data=%s",
+			userLabel(from.UserName, from.FirstName, from.ID),
+			from.ID,
+			update.CallbackQuery.Data)
+		if b.inMaintenance() && !maintenanceCallback(update.CallbackQuery.Data) {
+			b.api.Request(tgbotapi.NewCallbackWithAlert(update.CallbackQuery.ID, maintenanceText))
+			return
+		}
+		b.rememberUser(from)
+		b.handleCallback(update.CallbackQuery)
+		return
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	// Обработка успешного платежа
+	if update.Message.SuccessfulPayment != nil {
+		b.handleSuccessfulPayment(update.Message)
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	from := update.Message.From
+	log.Printf("[MSG] user=%s (id=%d) text=%q", userLabel(from.UserName, from.FirstName, from.ID), chatID, update.Message.Text)
+
+	// Режим обслуживания: пропускаем только чтение
+	if b.inMaintenance() && !maintenanceMessage(update.Message, in.WebAppData != nil) {
+		b.sendMessage(chatID, maintenanceText)
+		return
+	}
+	b.rememberUser(from)
+
+	// Данные из Web App — отдельный путь: не команда, не текст и не шаг диалога /add
+	if in.WebAppData != nil {
+		b.handleWebAppData(update.Message, in.WebAppData)
+		return
+	}
+
+	// Геопозиция — определяем часовой пояс
+	if update.Message.Location != nil {
+		b.handleLocation(update.Message)
+		return
+	}
+
+	// Проверяем состояние пользователя (из pending map)
+	pending, _ := b.pendingSnapshot(chatID)
+	state := pending.State
+
+	// Если ждём ввода названия лекарства. Повторное нажатие "➕ Добавить" —
+	// не название, а снова /add (см. addCooldown)
+	if state == StateWaitingMedicine && !update.Message.IsCommand() && update.Message.Text != addButtonText {
+		b.handleMedicineInput(update.Message)
+		return
+	}
+
+	// Если ждём дозировку. "➕ Добавить" — тоже не дозировка, а снова /add
+	if state == StateWaitingDoseAmount && !update.Message.IsCommand() && update.Message.Text != addButtonText {
+		b.handleDoseAmountInput(update.Message)
+		return
+	}
+
+	// Если ждём ввода своего количества дней курса
+	if state == StateWaitingCustomCourse && !update.Message.IsCommand() {
+		b.handleCustomCourseInput(update.Message)
+		return
+	}
+
+	// Если ждём ввода своего времени
+	if state == StateWaitingCustomTime && !update.Message.IsCommand() {
+		b.handleCustomTimeInput(update.Message)
+		return
+	}
+
+	// Если ждём часовой пояс напоминания
+	if state == StateWaitingReminderTimezone && !update.Message.IsCommand() {
+		b.handleReminderTimezoneInput(update.Message, pending.ReminderID)
+		return
+	}
+
+	// Если ждём новую длину курса напоминания
+	if state == StateWaitingReminderCourse && !update.Message.IsCommand() {
+		b.handleReminderCourseInput(update.Message, pending.ReminderID)
+		return
+	}
+
+	// Если ждём текст назначения
+	if state == StateWaitingImport && !update.Message.IsCommand() {
+		b.handleImportInput(update.Message)
+		return
+	}
+
+	// Если ждём свою сумму доната
+	if state == StateWaitingDonateAmount && !update.Message.IsCommand() {
+		b.handleDonateAmountInput(update.Message)
+		return
+	}
+
+	// Ответ на подтверждение приёма — заметка к дозе
+	if update.Message.ReplyToMessage != nil && !update.Message.IsCommand() && b.handleDoseNote(update.Message) {
+		return
+	}
+
+	if update.Message.IsCommand() {
+		// Сбрасываем состояние при любой команде. /add начинает диалог сам:
+		// повтор сразу после начала не должен его сбрасывать (addCooldown)
+		if update.Message.Command() != "add" {
+			b.mu.Lock()
+			delete(b.pending, chatID)
+			b.mu.Unlock()
+		}
+
+		switch update.Message.Command() {
+		case "start":
+			b.handleStart(update.Message)
+		case "add":
+			b.handleAdd(update.Message)
+		case "list":
+			b.handleList(update.Message)
+		case "clear":
+			b.handleClear(update.Message)
+		case "wake":
+			b.handleRoutine(update.Message, AnchorWake)
+		case "sleep":
+			b.handleRoutine(update.Message, AnchorSleep)
+		case "vacation":
+			b.handleVacation(update.Message)
+		case "webhook":
+			b.handleWebhook(update.Message)
+		case "report":
+			b.handleReport(update.Message)
+		case "mystats":
+			b.handleMyStats(update.Message)
+		case "adherence":
+			b.handleAdherence(update.Message)
+		case "history":
+			b.handleHistory(update.Message)
+		case "import":
+			b.handleImport(update.Message)
+		case "timezone":
+			b.handleTimezone(update.Message)
+		case "shift":
+			b.handleShift(update.Message)
+		case "prealert":
+			b.handlePreAlert(update.Message)
+		case "settings":
+			b.handleSettings(update.Message)
+		case "stop":
+			b.handleStop(update.Message)
+		case "donate":
+			b.handleDonate(update.Message)
+		case "stats":
+			b.handleStats(update.Message)
+		case "user":
+			b.handleUser(update.Message)
+		case "refund":
+			b.handleRefund(update.Message)
+		case "audit":
+			b.handleAudit(update.Message)
+		case "notify":
+			b.handleNotify(update.Message)
+		case "resend":
+			b.handleResend(update.Message)
+		case "maintenance":
+			b.handleMaintenance(update.Message)
+		case "checkslot":
+			b.handleCheckSlot(update.Message)
+		case "integrity":
+			b.handleIntegrity(update.Message)
+		}
+		return
+	}
+
+	// Обработка нажатий reply-кнопок
+	text := update.Message.Text
+	switch {
+	case strings.Contains(text, "Добавить"):
+		b.handleAdd(update.Message)
+	case strings.Contains(text, "напоминания"):
+		b.handleList(update.Message)
+	case strings.Contains(text, "Отключить"):
+		b.handleStop(update.Message)
+	case strings.Contains(text, "Включить"):
+		b.handleResume(update.Message)
+	case strings.Contains(text, "Статистика"):
+		b.handleStats(update.Message)
+	case strings.Contains(text, "Рассылка"):
+		b.handleNotifyPrompt(update.Message)
+	case text == skipLocationButton:
+		b.handleSkipLocation(update.Message)
+	case isTakenReply(text):
+		b.handleTakenReply(update.Message)
+	case strings.ToLower(text) == "привет":
+		b.sendMessage(chatID, "Привет! Я бот для напоминаний о лекарствах. Используй /start чтобы начать.")
+	}
+}
+
+func (b *Bot) handleCallback(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	data := callback.Data
+
+	// Подтверждаем получение callback. "Принял" отвечает сам: ответ может быть с текстом
+	if !strings.HasPrefix(data, "taken_") {
+		b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+	}
+
+	switch {
+	case strings.HasPrefix(data, "hour_"):
+		// Выбран час
+		hourStr := strings.TrimPrefix(data, "hour_")
+		hour, err := strconv.Atoi(hourStr)
+		if err == nil && hour >= 0 && hour <= 23 {
+			b.handleHourSelected(chatID, callback.Message.MessageID, hour)
+		}
+
+	case data == "hours_all" || data == "hours_main":
+		// Переключение между основными рядами часов и всеми часами суток
+		b.showAllHours(chatID, callback.Message.MessageID, data == "hours_all")
+
+	case strings.HasPrefix(data, "anchor_"):
+		// Выбрана привязка к пробуждению/сну
+		anchor := strings.TrimPrefix(data, "anchor_")
+		b.handleAnchorSelected(chatID, callback.Message.MessageID, anchor)
+
+	case strings.HasPrefix(data, "offset_"):
+		// Выбрано смещение относительно якоря: offset_<anchor>_<минуты>
+		parts := strings.Split(strings.TrimPrefix(data, "offset_"), "_")
+		if len(parts) == 2 {
+			offset, _ := strconv.Atoi(parts[1])
+			b.handleOffsetSelected(chatID, callback.Message.MessageID, parts[0], offset)
+		}
+
+	case data == "time_custom":
+		// Пользователь хочет ввести время текстом
+		b.mu.Lock()
+		if p := b.pending[chatID]; p != nil {
+			p.State = StateWaitingCustomTime
+			p.MsgID = callback.Message.MessageID
+		}
+		b.mu.Unlock()
+		b.deleteMessage(chatID, callback.Message.MessageID)
+		b.sendMessage(chatID, fmt.Sprintf("Введи время в формате ЧЧ:ММ, например 02:30 (минуты — %s):", minuteStepHint(b.minuteStep(chatID))))
+
+	case strings.HasPrefix(data, "time_"):
+		// Выбрано полное время (час:минута)
+		timeStr := strings.TrimPrefix(data, "time_")
+		parts := strings.Split(timeStr, ":")
+		if len(parts) == 2 {
+			hour, _ := strconv.Atoi(parts[0])
+			minute, _ := strconv.Atoi(parts[1])
+			b.handleTimeSelected(chatID, callback.Message.MessageID, hour, minute)
+		}
+
+	case strings.HasPrefix(data, "del_"):
+		// Удаление напоминания
+		idStr := strings.TrimPrefix(data, "del_")
+		id, _ := strconv.Atoi(idStr)
+		b.handleDeleteReminder(chatID, callback.Message.MessageID, id)
+
+	case data == "preview":
+		// Расписание на неделю из /list — отдельным сообщением
+		b.handlePreview(chatID, 0, 0)
+
+	case strings.HasPrefix(data, "preview_"):
+		// Листание расписания по дням: preview_<день>
+		page, err := strconv.Atoi(strings.TrimPrefix(data, "preview_"))
+		if err == nil {
+			b.handlePreview(chatID, callback.Message.MessageID, page)
+		}
+
+	case strings.HasPrefix(data, "rem_"):
+		// Настройки напоминания
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "rem_"))
+		b.handleReminderSettings(chatID, id)
+
+	case strings.HasPrefix(data, "dup_"):
+		// Копия напоминания с другим временем
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "dup_"))
+		b.handleDuplicate(chatID, id)
+
+	case strings.HasPrefix(data, "edittime_"):
+		// Другое время того же напоминания, без удаления
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "edittime_"))
+		b.handleEditTime(chatID, id)
+
+	case strings.HasPrefix(data, "remsnooze_"):
+		// Основная длительность "отложить": remsnooze_<id> — выбор, remsnooze_<id>_<минуты> — сохранение
+		idStr, minutesStr, chosen := strings.Cut(strings.TrimPrefix(data, "remsnooze_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		switch {
+		case !chosen:
+			b.showReminderSnoozeChoices(chatID, callback.Message.MessageID, id)
+		case minutesStr == "back":
+			b.editReminderSettings(chatID, callback.Message.MessageID, id)
+		default:
+			minutes, _ := strconv.Atoi(minutesStr)
+			b.handleReminderSnoozeSet(chatID, callback.Message.MessageID, id, minutes)
+		}
+
+	case strings.HasPrefix(data, "rempause_"):
+		// Пауза напоминания: rempause_<id>_<1|0>
+		idStr, flag, _ := strings.Cut(strings.TrimPrefix(data, "rempause_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		b.handleReminderPause(chatID, callback.Message.MessageID, id, flag == "1")
+
+	case strings.HasPrefix(data, "stopreason_"):
+		// Причина отключения напоминаний
+		b.handleStopReason(chatID, callback.Message.MessageID, strings.TrimPrefix(data, "stopreason_"))
+
+	case strings.HasPrefix(data, "remconfirm_"):
+		// Кнопка "Принял": remconfirm_<id>_<1|0>
+		idStr, flag, _ := strings.Cut(strings.TrimPrefix(data, "remconfirm_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		b.handleReminderRequireConfirm(chatID, callback.Message.MessageID, id, flag == "1")
+
+	case strings.HasPrefix(data, "remimportant_"):
+		// Напоминание о пропущенной дозе: remimportant_<id>_<1|0>
+		idStr, flag, _ := strings.Cut(strings.TrimPrefix(data, "remimportant_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		b.handleReminderImportant(chatID, callback.Message.MessageID, id, flag == "1")
+
+	case strings.HasPrefix(data, "remcourse_"):
+		// Изменить длину курса
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "remcourse_"))
+		b.askReminderCourse(chatID, callback.Message.MessageID, id)
+
+	case strings.HasPrefix(data, "celebrate_"):
+		// Как поздравлять с завершением курса: celebrate_<emoji|plain|off>
+		b.handleCelebrationSelected(chatID, callback.Message.MessageID, strings.TrimPrefix(data, "celebrate_"))
+
+	case strings.HasPrefix(data, "bundle_"):
+		// Как присылать напоминания: bundle_<dose|halves>
+		b.handleBundlingSelected(chatID, callback.Message.MessageID, strings.TrimPrefix(data, "bundle_"))
+
+	case strings.HasPrefix(data, "btaken_"):
+		// Приём из утренней или вечерней пачки: btaken_<id>_<unix времени приёма>_<строка>
+		b.handleBundleTaken(callback)
+
+	case strings.HasPrefix(data, "minstep_"):
+		// Шаг минут при выборе времени: minstep_<5|10|15|30>
+		step, _ := strconv.Atoi(strings.TrimPrefix(data, "minstep_"))
+		b.handleMinuteStepSelected(chatID, callback.Message.MessageID, step)
+
+	case strings.HasPrefix(data, "remtz_"):
+		// Свой часовой пояс напоминания
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "remtz_"))
+		b.askReminderTimezone(chatID, callback.Message.MessageID, id)
+
+	case strings.HasPrefix(data, "remlink_"):
+		// Связать напоминания: remlink_<id> — выбор, remlink_<id>_<другой id> — сохранение
+		idStr, otherStr, chosen := strings.Cut(strings.TrimPrefix(data, "remlink_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		switch {
+		case !chosen:
+			b.showLinkChoices(chatID, callback.Message.MessageID, id)
+		case otherStr == "back":
+			b.editReminderSettings(chatID, callback.Message.MessageID, id)
+		default:
+			otherID, _ := strconv.Atoi(otherStr)
+			b.handleLinkReminders(chatID, callback.Message.MessageID, id, otherID)
+		}
+
+	case strings.HasPrefix(data, "remunlink_"):
+		// Убрать напоминание из группы "принимать вместе"
+		id, _ := strconv.Atoi(strings.TrimPrefix(data, "remunlink_"))
+		b.handleUnlinkReminder(chatID, callback.Message.MessageID, id)
+
+	case strings.HasPrefix(data, "once_"):
+		// Выбрана дата разового напоминания: once_<ГГГГ-ММ-ДД>
+		b.handleOnceDateSelected(chatID, callback.Message.MessageID, strings.TrimPrefix(data, "once_"))
+
+	case strings.HasPrefix(data, "report_"):
+		// Отчёт для врача: report_<дней>
+		days, _ := strconv.Atoi(strings.TrimPrefix(data, "report_"))
+		b.sendReport(chatID, callback.Message.MessageID, days)
+
+	case strings.HasPrefix(data, "reactivate_"):
+		// Включить напоминания после добавления нового: reactivate_<1|0>
+		b.handleReactivate(chatID, callback.Message.MessageID, data == "reactivate_1")
+
+	case data == "clear_confirm":
+		// Подтверждено удаление всех напоминаний
+		b.handleClearConfirmed(chatID, callback.Message.MessageID)
+
+	case strings.HasPrefix(data, "course_"):
+		// Выбор длительности курса
+		courseStr := strings.TrimPrefix(data, "course_")
+		if courseStr == "once" {
+			// Разовое напоминание — выбираем дату
+			b.showOnceDateSelection(chatID, callback.Message.MessageID)
+		} else if courseStr == "custom" {
+			// Пользователь хочет ввести своё значение
+			b.mu.Lock()
+			if p := b.pending[chatID]; p != nil {
+				p.State = StateWaitingCustomCourse
+				p.MsgID = callback.Message.MessageID
+			}
+			b.mu.Unlock()
+			b.deleteMessage(chatID, callback.Message.MessageID)
+			b.sendMessage(chatID, fmt.Sprintf("Введи количество дней курса (число от 1 до %d):", b.storage.MaxCourseDays()))
+		} else {
+			courseDays, _ := strconv.Atoi(courseStr)
+			b.handleCourseSelected(chatID, callback.Message.MessageID, courseDays)
+		}
+
+	case data == "weekdays":
+		// Выбор дней недели в диалоге /add
+		b.handleWeekdays(chatID, callback.Message.MessageID, -1)
+
+	case data == "weekdays_done":
+		b.handleWeekdaysDone(chatID, callback.Message.MessageID)
+
+	case strings.HasPrefix(data, "weekday_"):
+		// Переключить день недели: weekday_<бит, 0 — понедельник>
+		bit, err := strconv.Atoi(strings.TrimPrefix(data, "weekday_"))
+		if err == nil {
+			b.handleWeekdays(chatID, callback.Message.MessageID, bit)
+		}
+
+	case data == "amount_skip":
+		// Дозировка не нужна
+		b.handleDoseAmountSkip(chatID, callback.Message.MessageID)
+
+	case strings.HasPrefix(data, "interval_"):
+		// Приём каждые N часов: interval_<минуты>, interval_0 — раз в день
+		minutes, _ := strconv.Atoi(strings.TrimPrefix(data, "interval_"))
+		b.handleIntervalSelected(chatID, callback.Message.MessageID, minutes)
+
+	case strings.HasPrefix(data, "coursetype_"):
+		// Как считать курс: coursetype_<doses|days>
+		b.handleCourseTypeSelected(chatID, callback.Message.MessageID, strings.TrimPrefix(data, "coursetype_"))
+
+	case strings.HasPrefix(data, "taken_"):
+		// Подтверждение приёма лекарства: taken_<id>_<unix времени слота>
+		parts := strings.Split(strings.TrimPrefix(data, "taken_"), "_")
+		id, _ := strconv.Atoi(parts[0])
+		var slot time.Time
+		if len(parts) > 1 {
+			if ts, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				slot = time.Unix(ts, 0)
+			}
+		}
+		b.handleTakenConfirm(callback.ID, chatID, callback.Message.MessageID, callback.Message.Text, id, slot)
+
+	case strings.HasPrefix(data, "snooze_"):
+		// Отложить напоминание: snooze_<id>_<unix времени слота>_<минуты>
+		parts := strings.Split(strings.TrimPrefix(data, "snooze_"), "_")
+		if len(parts) == 3 {
+			id, _ := strconv.Atoi(parts[0])
+			ts, _ := strconv.ParseInt(parts[1], 10, 64)
+			minutes, _ := strconv.Atoi(parts[2])
+			b.handleSnooze(chatID, callback.Message.MessageID, callback.Message.Text, callback.From.LanguageCode, id, time.Unix(ts, 0), minutes)
+		}
+
+	case strings.HasPrefix(data, "skip_"):
+		// Пропустить дозу намеренно: skip_<id>_<unix времени слота>
+		idStr, tsStr, _ := strings.Cut(strings.TrimPrefix(data, "skip_"), "_")
+		id, _ := strconv.Atoi(idStr)
+		ts, err := strconv.ParseInt(tsStr, 10, 64)
+		if err == nil {
+			b.handleSkipDose(chatID, callback.Message.MessageID, callback.Message.Text, callback.From.LanguageCode, id, time.Unix(ts, 0))
+		}
+
+	case data == "import_confirm":
+		// Создать напоминания из назначения
+		b.handleImportConfirm(chatID, callback.Message.MessageID)
+
+	case data == "stars_custom":
+		// Своя сумма доната — ждём число текстом
+		b.askDonateAmount(chatID, callback.Message.MessageID)
+
+	case strings.HasPrefix(data, "stars_"):
+		// Выбор суммы доната
+		amountStr := strings.TrimPrefix(data, "stars_")
+		amount, _ := strconv.Atoi(amountStr)
+		b.deleteMessage(chatID, callback.Message.MessageID)
+		b.sendStarsInvoice(chatID, amount)
+
+	case data == "cancel":
+		b.mu.Lock()
+		delete(b.pending, chatID)
+		b.mu.Unlock()
+		b.deleteMessage(chatID, callback.Message.MessageID)
+		b.sendMessage(chatID, "Отменено")
+	}
+}
+
+// handleStart приветствует пользователя. Новым — полное приветствие и выбор
+// часового пояса; вернувшимся — короткое сообщение без изменения настроек:
+// если напоминания отключены через /stop, они остаются отключёнными.
+func (b *Bot) handleStart(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	user, created, err := b.storage.GetOrCreateUser(chatID)
+	if err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+	if !created && user != nil {
+		text := "👋 С возвращением! Используй /add чтобы добавить напоминание или /list чтобы посмотреть свои"
+		if !user.Active {
+			text = "👋 С возвращением! Напоминания сейчас отключены — нажми «▶️ Включить», чтобы снова их получать"
+		}
+		reply := tgbotapi.NewMessage(chatID, text)
+		reply.ReplyMarkup = b.getMainKeyboard(chatID, user.Active)
+		if _, err := b.api.Send(reply); err != nil {
+			log.Printf("Failed to send message to %d: %v", chatID, err)
+		}
+		return
+	}
+
+	text := "Привет! Я помогу тебе не забывать принимать лекарства.\n\n" +
+		"Используй кнопки ниже или команды:\n" +
+		"/add — добавить напоминание\n" +
+		"/list — список напоминаний"
 
 	keyboard := b.getMainKeyboard(chatID, true)
 
@@ -337,19 +1276,33 @@ func (b *Bot) handleStart(msg *tgbotapi.Message) {
 	if _, err := b.api.Send(reply); err != nil {
 		log.Printf("Failed to send message to %d: %v", chatID, err)
 	}
+
+	b.askLocationOnboarding(chatID)
 }
 
+// addCooldown — повторный /add в течение этого времени не начинает диалог заново
+const addCooldown = 5 * time.Second
+
+// addButtonText — reply-кнопка главной клавиатуры, равносильная /add
+const addButtonText = "➕ Добавить"
+
 func (b *Bot) handleAdd(msg *tgbotapi.Message) {
 	chatID := msg.Chat.ID
 
-	if _, err := b.storage.GetOrCreateUser(chatID); err != nil {
-		log.Printf("Failed to create user %d: %v", chatID, err)
-	}
-
+	// Двойное нажатие или повтор клиента: диалог только что начат,
+	// приглашение уже отправлено — не сбрасываем состояние и не дублируем сообщение
 	b.mu.Lock()
-	b.pending[chatID] = &PendingReminder{State: StateWaitingMedicine}
+	if p := b.pending[chatID]; p != nil && p.State == StateWaitingMedicine && b.now().Sub(p.StartedAt) < addCooldown {
+		b.mu.Unlock()
+		return
+	}
+	b.pending[chatID] = &PendingReminder{State: StateWaitingMedicine, StartedAt: b.now()}
 	b.mu.Unlock()
 
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+
 	// Просим ввести название лекарства
 	cancelKeyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
@@ -359,425 +1312,2303 @@ func (b *Bot) handleAdd(msg *tgbotapi.Message) {
 
 	reply := tgbotapi.NewMessage(chatID, "Введи название лекарства:")
 	reply.ReplyMarkup = cancelKeyboard
-	if _, err := b.api.Send(reply); err != nil {
+	sent, err := b.api.Send(reply)
+	if err != nil {
 		log.Printf("Failed to send message: %v", err)
+		return
+	}
+
+	b.mu.Lock()
+	if p := b.pending[chatID]; p != nil && p.State == StateWaitingMedicine {
+		p.PromptMsgID = sent.MessageID
 	}
+	b.mu.Unlock()
 }
 
 func (b *Bot) handleMedicineInput(msg *tgbotapi.Message) {
 	chatID := msg.Chat.ID
 	medicine := strings.TrimSpace(msg.Text)
 
-	if medicine == "" {
-		b.sendMessage(chatID, "Название не может быть пустым. Попробуй ещё раз:")
-		return
+	if medicine == "" {
+		b.sendMessage(chatID, "Название не может быть пустым. Попробуй ещё раз:")
+		return
+	}
+	if checkMedicine(medicine) != nil {
+		b.sendMessage(chatID, fmt.Sprintf("Слишком длинное название (макс %d символов). Попробуй короче:", maxMedicineLength))
+		return
+	}
+
+	b.mu.Lock()
+	if p := b.pending[chatID]; p != nil {
+		p.Medicine = medicine
+		p.State = StateWaitingDoseAmount
+	}
+	b.mu.Unlock()
+
+	// Спрашиваем дозировку, потом — час
+	b.askDoseAmount(chatID, medicine)
+}
+
+func (b *Bot) showHourSelection(chatID int64, medicine string) {
+	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("💊 %s\n\nВыбери час (Часовой пояс: %s):", medicine, zoneLabel(b.userLoc(chatID), b.now())))
+	reply.ReplyMarkup = b.hourKeyboard(false)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// showAllHours переключает выбор часа между основными рядами и всеми 24 часами
+func (b *Bot) showAllHours(chatID int64, messageID int, all bool) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, b.hourKeyboard(all))
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// hourKeyboard собирает кнопки выбора часа: ряды из HOUR_RANGES
+// или, если all, все часы суток, включая ночные
+func (b *Bot) hourKeyboard(all bool) tgbotapi.InlineKeyboardMarkup {
+	ranges := b.hourRanges
+	if all {
+		ranges = allHourRanges
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, r := range ranges {
+		var row []tgbotapi.InlineKeyboardButton
+		for h := r.From; h <= r.To; h++ {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", h), fmt.Sprintf("hour_%d", h)))
+		}
+		rows = append(rows, row)
+	}
+
+	// Часы вне основных рядов (например, ночь для сменного графика)
+	switch {
+	case all:
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("↩️ Основные часы", "hours_main"),
+		})
+	case !coversAllHours(ranges):
+		rows = append(rows, []tgbotapi.InlineKeyboardButton{
+			tgbotapi.NewInlineKeyboardButtonData("🕐 Все часы", "hours_all"),
+		})
+	}
+
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("✏️ Другое время", "time_custom"),
+	})
+
+	// Привязка к распорядку дня
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("🌅 От пробуждения", "anchor_"+AnchorWake),
+		tgbotapi.NewInlineKeyboardButtonData("🌙 Перед сном", "anchor_"+AnchorSleep),
+	})
+
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+	})
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (b *Bot) handleHourSelected(chatID int64, messageID int, hour int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	medicine := p.Medicine
+	p.Hour = hour
+	p.State = StateWaitingMinute
+	b.mu.Unlock()
+
+	// Показываем выбор минут с шагом пользователя, по 4 в ряд
+	l := b.userLocale(chatID, defaultLocale)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, m := range stepMinutes(b.minuteStep(chatID)) {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			formatTime(l, hour, m),
+			fmt.Sprintf("time_%d:%d", hour, m),
+		))
+		if len(row) == 4 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf("💊 %s\n\nВыбери точное время (Часовой пояс: %s):", medicine, zoneLabel(b.userLoc(chatID), b.now())))
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// anchorOffsets — варианты смещения (в минутах) для каждого якоря
+var anchorOffsets = map[string][]int{
+	AnchorWake:  {0, 30, 60, 120},
+	AnchorSleep: {-120, -60, -30, 0},
+}
+
+func (b *Bot) handleAnchorSelected(chatID int64, messageID int, anchor string) {
+	offsets, ok := anchorOffsets[anchor]
+	if !ok {
+		return
+	}
+
+	p, _ := b.pendingSnapshot(chatID)
+	medicine := p.Medicine
+
+	if medicine == "" {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	user, err := b.storage.GetUser(chatID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", chatID, err)
+	}
+	if user == nil || user.routineTime(anchor) == nil {
+		command, label := "/wake", "пробуждения"
+		if anchor == AnchorSleep {
+			command, label = "/sleep", "отхода ко сну"
+		}
+		b.sendMessage(chatID, fmt.Sprintf("Сначала укажи время %s, например: %s 07:00\n\nИли выбери час кнопкой выше.", label, command))
+		return
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, offset := range offsets {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			formatOffset(offset),
+			fmt.Sprintf("offset_%s_%d", anchor, offset),
+		))
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		row,
+		{tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")},
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	base := *user.routineTime(anchor)
+	baseTime := formatTime(b.userLocale(chatID, defaultLocale), base/60, base%60)
+	text := fmt.Sprintf("💊 %s\n\nПробуждение в %s. Когда напомнить?", medicine, baseTime)
+	if anchor == AnchorSleep {
+		text = fmt.Sprintf("💊 %s\n\nОтход ко сну в %s. Когда напомнить?", medicine, baseTime)
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+func (b *Bot) handleOffsetSelected(chatID int64, messageID int, anchor string, offset int) {
+	user, err := b.storage.GetUser(chatID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", chatID, err)
+	}
+	if user == nil || user.routineTime(anchor) == nil {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	// Переводим якорь + смещение в абсолютное время суток
+	minutes := ((*user.routineTime(anchor)+offset)%1440 + 1440) % 1440
+	hour, minute := minutes/60, minutes%60
+
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	p.Hour = hour
+	p.Minute = minute
+	p.Anchor = anchor
+	p.AnchorOffset = offset
+	p.State = StateWaitingCourse
+	dialog := *p
+	b.mu.Unlock()
+
+	if b.applyEditedTime(chatID, messageID) || b.addCopiedReminder(chatID, messageID) {
+		return
+	}
+	b.showCourseSelection(chatID, messageID, dialog)
+}
+
+// routineTime возвращает время пробуждения или сна пользователя
+func (u *User) routineTime(anchor string) *int {
+	if anchor == AnchorSleep {
+		return u.SleepTime
+	}
+	return u.WakeTime
+}
+
+// handleRoutine задаёт время пробуждения (/wake) или сна (/sleep)
+func (b *Bot) handleRoutine(msg *tgbotapi.Message, anchor string) {
+	chatID := msg.Chat.ID
+
+	label := "пробуждения"
+	if anchor == AnchorSleep {
+		label = "отхода ко сну"
+	}
+
+	step := b.minuteStep(chatID)
+	hour, minute, ok := parseClockTime(msg.CommandArguments())
+	if !ok || minute%step != 0 {
+		b.sendMessage(chatID, fmt.Sprintf("Укажи время %s в формате ЧЧ:ММ (минуты — %s), например: /%s 07:00\n\n"+
+			"Напоминания, привязанные к этому времени, сдвинутся вместе с ним.", label, minuteStepHint(step), msg.Command()))
+		return
+	}
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+
+	updated, err := b.storage.SetRoutineTime(chatID, anchor, hour*60+minute)
+	if err != nil {
+		log.Printf("Failed to set routine time: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+
+	text := fmt.Sprintf("✅ Время %s: %s", label, formatTime(b.userLocale(chatID, defaultLocale), hour, minute))
+	if updated > 0 {
+		text += fmt.Sprintf("\n\nПересчитано привязанных напоминаний: %d", updated)
+	}
+	b.sendMessage(chatID, text)
+}
+
+// maxShiftMinutes — предел сдвига /shift; больший сдвиг по кругу эквивалентен меньшему
+const maxShiftMinutes = 12 * 60
+
+// handleShift сдвигает время всех напоминаний: /shift +1h, /shift -30m
+func (b *Bot) handleShift(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	step := b.minuteStep(chatID)
+	minutes, ok := parseShiftOffset(msg.CommandArguments(), step)
+	if !ok {
+		b.sendMessage(chatID, fmt.Sprintf("Укажи сдвиг, кратный %d минутам, например:\n/shift +1h — на час позже\n/shift -30m — на полчаса раньше\n\n"+
+			"Время переходит через полночь: 23:30 + 1 ч → 00:30. Напоминания, привязанные к пробуждению или сну, сдвигаются через /wake и /sleep.", step))
+		return
+	}
+
+	shifted, err := b.storage.ShiftReminders(chatID, minutes)
+	if err != nil {
+		log.Printf("Failed to shift reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+	if shifted == 0 {
+		b.sendMessage(chatID, "Нет напоминаний с фиксированным временем. Добавь их через /add")
+		return
+	}
+
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("✅ Сдвинуто напоминаний: %d (%s)\n\n", shifted, formatOffset(minutes)))
+	for _, r := range reminders {
+		if r.Anchor == "" {
+			text.WriteString(fmt.Sprintf("⏰ %s — 💊 %s\n", r.TimeLabel(), displayName(r.Medicine)))
+		}
+	}
+	b.sendMessage(chatID, text.String())
+}
+
+// parseShiftOffset разбирает сдвиг вида "+1h", "-30m", "+1h30m" или "-1ч".
+// Сдвиг должен быть ненулевым, кратным шагу минут step и не больше maxShiftMinutes.
+func parseShiftOffset(s string, step int) (int, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.NewReplacer("ч", "h", "мин", "m", "м", "m").Replace(s)
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d%(time.Duration(step)*time.Minute) != 0 {
+		return 0, false
+	}
+	minutes := int(d / time.Minute)
+	if minutes == 0 || minutes > maxShiftMinutes || minutes < -maxShiftMinutes {
+		return 0, false
+	}
+	return minutes, true
+}
+
+// parseClockTime разбирает время в формате ЧЧ:ММ
+func parseClockTime(s string) (hour, minute int, ok bool) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	minute, err = strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+func (b *Bot) handleTimeSelected(chatID int64, messageID int, hour, minute int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	// Сохраняем выбранное время и переходим к выбору курса
+	p.Hour = hour
+	p.Minute = minute
+	p.Anchor = ""
+	p.AnchorOffset = 0
+	p.State = StateWaitingCourse
+	dialog := *p
+	b.mu.Unlock()
+
+	if b.applyEditedTime(chatID, messageID) || b.addCopiedReminder(chatID, messageID) {
+		return
+	}
+	// Показываем выбор длительности курса
+	b.showCourseSelection(chatID, messageID, dialog)
+}
+
+func (b *Bot) showCourseSelection(chatID int64, messageID int, p PendingReminder) {
+	keyboard := courseKeyboard(p)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, b.courseSelectionText(chatID, p))
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// courseSelectionText — вопрос о длительности курса для выбранного в диалоге
+// времени, интервала приёма и дней недели
+func (b *Bot) courseSelectionText(chatID int64, p PendingReminder) string {
+	at := formatTime(b.userLocale(chatID, defaultLocale), p.Hour, p.Minute)
+	days := ""
+	if mask := p.weekdays(); mask != allWeekdays {
+		days = "\n📆 " + formatWeekdays(mask)
+	}
+	if p.IntervalMinutes > 0 {
+		return fmt.Sprintf("💊 %s\n⏰ с %s, %s%s\n\nВыбери длительность курса — в днях по календарю:",
+			p.Medicine, at, formatInterval(p.IntervalMinutes), days)
+	}
+	return fmt.Sprintf("💊 %s\n⏰ %s%s\n\nВыбери длительность курса или 🔁 — принимать каждые N часов, начиная с этого времени:",
+		p.Medicine, at, days)
+}
+
+// courseKeyboard — кнопки выбора длительности курса, интервала приёма и дней недели.
+// Разового приёма каждые N часов или по дням недели не бывает — тогда кнопки "Разово" нет.
+func courseKeyboard(p PendingReminder) tgbotapi.InlineKeyboardMarkup {
+	once := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("♾ Бесконечно", "course_0")}
+	if p.IntervalMinutes == 0 && p.weekdays() == allWeekdays {
+		once = append(once, tgbotapi.NewInlineKeyboardButtonData("📌 Разово", "course_once"))
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		intervalRow(p.IntervalMinutes),
+		{tgbotapi.NewInlineKeyboardButtonData("📆 Дни недели: "+formatWeekdays(p.weekdays()), "weekdays")},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("7 дней", "course_7"),
+			tgbotapi.NewInlineKeyboardButtonData("14 дней", "course_14"),
+			tgbotapi.NewInlineKeyboardButtonData("21 день", "course_21"),
+		},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("30 дней", "course_30"),
+			tgbotapi.NewInlineKeyboardButtonData("60 дней", "course_60"),
+			tgbotapi.NewInlineKeyboardButtonData("90 дней", "course_90"),
+		},
+		once,
+		{
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Ввести своё", "course_custom"),
+		},
+		{
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+		},
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleCustomTimeInput принимает время ЧЧ:ММ, введённое текстом, — так доступны
+// часы, которых нет на кнопках, — и переходит к выбору курса
+func (b *Bot) handleCustomTimeInput(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	step := b.minuteStep(chatID)
+	hour, minute, ok := parseClockTime(msg.Text)
+	if !ok || minute%step != 0 {
+		b.sendMessage(chatID, fmt.Sprintf("Не понял время. Введи его в формате ЧЧ:ММ, например 02:30 (минуты — %s):", minuteStepHint(step)))
+		return
+	}
+
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	p.Hour = hour
+	p.Minute = minute
+	p.Anchor = ""
+	p.AnchorOffset = 0
+	p.State = StateWaitingCourse
+	dialog := *p
+	b.mu.Unlock()
+
+	if b.applyEditedTime(chatID, 0) || b.addCopiedReminder(chatID, 0) {
+		return
+	}
+	reply := tgbotapi.NewMessage(chatID, b.courseSelectionText(chatID, dialog))
+	reply.ReplyMarkup = courseKeyboard(dialog)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+func (b *Bot) handleCourseSelected(chatID int64, messageID int, courseDays int) {
+	if courseDays == 0 {
+		b.addPendingReminder(chatID, messageID, 0, CourseByDoses)
+		return
+	}
+	b.askCourseType(chatID, messageID, courseDays)
+}
+
+// askCourseType спрашивает, как считать курс: по подтверждённым приёмам или по дням.
+// messageID == 0 — отправить вопрос новым сообщением (после ввода своего числа).
+// Курс приёма каждые N часов всегда считается по дням: число приёмов в нём не очевидно.
+func (b *Bot) askCourseType(chatID int64, messageID int, courseDays int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		if messageID != 0 {
+			b.deleteMessage(chatID, messageID)
+		}
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	if p.IntervalMinutes > 0 {
+		b.mu.Unlock()
+		b.addPendingReminder(chatID, messageID, courseDays, CourseByDays)
+		return
+	}
+	p.State = StateWaitingCourseType
+	p.CourseDays = courseDays
+	medicine := p.Medicine
+	b.mu.Unlock()
+
+	text := fmt.Sprintf("💊 %s\n📅 Курс: %d\n\nКак считать курс?\n\n"+
+		"💊 По приёмам — закончится после %d подтверждённых доз, пропуски его продлевают\n"+
+		"📅 По дням — закончится через %d дней по календарю, даже если были пропуски",
+		medicine, courseDays, courseDays, courseDays)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💊 По приёмам", "coursetype_"+CourseByDoses),
+			tgbotapi.NewInlineKeyboardButtonData("📅 По дням", "coursetype_"+CourseByDays),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyMarkup = keyboard
+		if _, err := b.api.Send(msg); err != nil {
+			log.Printf("Failed to send message: %v", err)
+		}
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleCourseTypeSelected создаёт напоминание с выбранным способом подсчёта курса
+func (b *Bot) handleCourseTypeSelected(chatID int64, messageID int, courseType string) {
+	if courseType != CourseByDoses && courseType != CourseByDays {
+		return
+	}
+	p, ok := b.pendingSnapshot(chatID)
+	if !ok || p.State != StateWaitingCourseType {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	b.addPendingReminder(chatID, messageID, p.CourseDays, courseType)
+}
+
+// addPendingReminder сохраняет напоминание из диалога /add и сообщает о нём.
+// messageID == 0 — сообщения с кнопками нет (время введено текстом).
+func (b *Bot) addPendingReminder(chatID int64, messageID int, courseDays int, courseType string) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		if messageID != 0 {
+			b.deleteMessage(chatID, messageID)
+		}
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	reminder := p.toReminder(courseDays)
+	reminder.CourseType = courseType
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+
+	reminder.StartsAt = nextOnWeekday(firstOccurrence(b.now().In(b.userLoc(chatID)), reminder.Hour, reminder.Minute), reminder)
+
+	// Сохраняем в БД
+	id, err := b.storage.AddReminder(chatID, reminder, ReminderSourceChat)
+	if err != nil {
+		log.Printf("Failed to add reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова: /add")
+		return
+	}
+	reminder.ID = id
+
+	if messageID != 0 {
+		b.deleteMessage(chatID, messageID)
+	}
+
+	text := fmt.Sprintf("✅ Напоминание добавлено!\n\n💊 %s\n⏰ %s\n📅 Курс: %s\n🚀 Первый приём: %s\n\nИспользуй /list чтобы увидеть все напоминания",
+		doseName(reminder), reminder.TimeLabel(), courseLengthString(courseDays, courseType), b.relativeDateTime(b.userLocale(chatID, defaultLocale), reminder.StartsAt))
+	b.sendReminderAdded(chatID, reminder.ID, text)
+	b.warnReminderConflicts(chatID, reminder)
+	b.reactivateAfterAdd(chatID)
+}
+
+// handleDuplicate начинает диалог /add с лекарством и курсом существующего
+// напоминания: остаётся выбрать время. Курс копии начинается заново —
+// принятые дозы исходного не переносятся. У разового напоминания курс
+// не копируется: после времени спрашиваем курс и дату как обычно.
+func (b *Bot) handleDuplicate(chatID int64, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминания")
+		return
+	}
+	if reminder == nil {
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{
+		State:      StateWaitingHour,
+		Medicine:   reminder.Medicine,
+		StartedAt:  b.now(),
+		CourseDays: reminder.CourseDays,
+		CourseType: reminder.CourseType,
+		CopyCourse: reminder.FireDate == nil,
+
+		IntervalMinutes: reminder.IntervalMinutes,
+		WeekdayMask:     reminder.WeekdayMask,
+		DoseAmount:      reminder.DoseAmount,
+	}
+	b.mu.Unlock()
+
+	b.showHourSelection(chatID, reminder.Medicine)
+}
+
+// addCopiedReminder сохраняет копию напоминания сразу после выбора времени,
+// если диалог начат кнопкой "Дублировать". false — это обычный /add.
+func (b *Bot) addCopiedReminder(chatID int64, messageID int) bool {
+	p, ok := b.pendingSnapshot(chatID)
+	if !ok || !p.CopyCourse {
+		return false
+	}
+	b.addPendingReminder(chatID, messageID, p.CourseDays, p.CourseType)
+	return true
+}
+
+// handleEditTime начинает выбор нового времени для напоминания: тот же выбор
+// часа и минут, что в /add, но после выбора напоминание обновляется на месте,
+// без удаления — принятые дозы и курс сохраняются
+func (b *Bot) handleEditTime(chatID int64, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминания")
+		return
+	}
+	if reminder == nil {
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{
+		State:      StateWaitingHour,
+		Medicine:   reminder.Medicine,
+		StartedAt:  b.now(),
+		ReminderID: reminder.ID,
+	}
+	b.mu.Unlock()
+
+	b.showHourSelection(chatID, reminder.Medicine)
+}
+
+// applyEditedTime сохраняет выбранное время в напоминание, если диалог начат
+// кнопкой "Изменить время". false — это обычный /add или копия.
+func (b *Bot) applyEditedTime(chatID int64, messageID int) bool {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.ReminderID == 0 {
+		b.mu.Unlock()
+		return false
+	}
+	edited := *p
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+
+	if messageID != 0 {
+		b.deleteMessage(chatID, messageID)
+	}
+
+	var err error
+	if edited.Anchor != "" {
+		err = b.storage.UpdateReminderAnchor(chatID, edited.ReminderID, edited.Anchor, edited.AnchorOffset, edited.Hour, edited.Minute)
+	} else {
+		err = b.storage.UpdateReminderTime(chatID, edited.ReminderID, edited.Hour, edited.Minute)
+	}
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		// Удалено, пока выбирали время
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return true
+	case err != nil:
+		log.Printf("Failed to update reminder time: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова: /list")
+		return true
+	}
+
+	reminder, err := b.storage.GetReminder(chatID, edited.ReminderID)
+	if err != nil || reminder == nil {
+		log.Printf("Failed to get reminder: %v", err)
+		b.sendMessage(chatID, "✅ Время напоминания изменено")
+		return true
+	}
+	b.sendMessage(chatID, fmt.Sprintf("✅ Время изменено!\n\n💊 %s\n⏰ %s\n📅 Курс: %s\n\nПринятые дозы и курс сохранены",
+		reminder.Medicine, reminder.TimeLabel(), reminder.CourseString(b.now())))
+	b.warnReminderConflicts(chatID, *reminder)
+	return true
+}
+
+// sendReminderAdded отправляет сообщение о добавленном напоминании с кнопками
+// его настройки: сразу можно отказаться от подтверждения приёма
+func (b *Bot) sendReminderAdded(chatID int64, reminderID int, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔕 Без подтверждения", fmt.Sprintf("remconfirm_%d_0", reminderID)),
+			tgbotapi.NewInlineKeyboardButtonData("⚙️ Настройки", fmt.Sprintf("rem_%d", reminderID)),
+		),
+	)
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// onceDateChoices — на сколько дней вперёд можно выбрать дату разового напоминания
+const onceDateChoices = 7
+
+// showOnceDateSelection показывает выбор даты разового напоминания
+func (b *Bot) showOnceDateSelection(chatID int64, messageID int) {
+	p, _ := b.pendingSnapshot(chatID)
+	medicine, hour, minute := p.Medicine, p.Hour, p.Minute
+
+	if medicine == "" {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	l := b.userLocale(chatID, defaultLocale)
+	now := b.now().In(l.Loc)
+	first := firstOccurrence(now, hour, minute)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i := 0; i < onceDateChoices; i++ {
+		day := first.AddDate(0, 0, i)
+
+		label := formatShortDate(l, day)
+		switch {
+		case sameDay(day, now):
+			label = "Сегодня"
+		case sameDay(day, now.AddDate(0, 0, 1)):
+			label = "Завтра"
+		}
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, "once_"+day.Format("2006-01-02")))
+		if len(row) == 4 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	text := fmt.Sprintf("💊 %s\n⏰ %s\n\nВ какой день напомнить один раз?", medicine, formatTime(l, hour, minute))
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleOnceDateSelected создаёт разовое напоминание на выбранную дату
+func (b *Bot) handleOnceDateSelected(chatID int64, messageID int, dateStr string) {
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		return
+	}
+
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+
+	reminder := p.toReminder(1)
+	reminder.IntervalMinutes = 0 // разовый приём — один, в свою дату
+	reminder.WeekdayMask = allWeekdays
+	startsAt := time.Date(date.Year(), date.Month(), date.Day(), reminder.Hour, reminder.Minute, 0, 0, b.userLoc(chatID))
+	if !startsAt.After(b.now()) {
+		b.mu.Unlock()
+		b.sendMessage(chatID, "Это время уже прошло — выбери другой день")
+		return
+	}
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+
+	reminder.FireDate = &date
+	reminder.StartsAt = startsAt
+
+	id, err := b.storage.AddReminder(chatID, reminder, ReminderSourceChat)
+	if err != nil {
+		log.Printf("Failed to add reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова: /add")
+		return
+	}
+	reminder.ID = id
+
+	b.deleteMessage(chatID, messageID)
+
+	text := fmt.Sprintf("✅ Разовое напоминание добавлено!\n\n💊 %s\n⏰ %s\n\nИспользуй /list чтобы увидеть все напоминания",
+		doseName(reminder), b.relativeDateTime(b.userLocale(chatID, defaultLocale), reminder.StartsAt))
+	b.sendReminderAdded(chatID, reminder.ID, text)
+	b.warnReminderConflicts(chatID, reminder)
+	b.reactivateAfterAdd(chatID)
+}
+
+func (b *Bot) handleCustomCourseInput(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	text := strings.TrimSpace(msg.Text)
+
+	courseDays, err := strconv.Atoi(text)
+	if err != nil || courseDays < 1 || courseDays > b.storage.MaxCourseDays() {
+		b.sendMessage(chatID, fmt.Sprintf("Пожалуйста, введи число от 1 до %d:", b.storage.MaxCourseDays()))
+		return
+	}
+
+	b.askCourseType(chatID, 0, courseDays)
+}
+
+func (b *Bot) handleList(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	// /list неделя — расписание на неделю вперёд по дням,
+	// /list ближайшие — по времени до следующего приёма, а не по часам
+	byNextDose := false
+	switch strings.ToLower(strings.TrimSpace(msg.CommandArguments())) {
+	case "неделя", "week":
+		b.handlePreview(chatID, 0, 0)
+		return
+	case "ближайшие", "по ближайшим", "next":
+		byNextDose = true
+	}
+
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+
+	if len(reminders) == 0 {
+		b.sendMessage(chatID, "У тебя пока нет напоминаний.\n\nИспользуй /add чтобы добавить")
+		return
+	}
+
+	l := b.userLocale(chatID, defaultLocale)
+
+	// По часам уже отсортированы в storage.GetReminders; связанные ставим рядом
+	all := reminders
+	if byNextDose {
+		reminders = slices.Clone(reminders)
+		sortByNextDose(reminders, b.now(), l.Loc)
+	}
+	reminders = groupLinked(reminders)
+	marks := b.todayMarks(chatID, all, l)
+
+	var text strings.Builder
+	zone := zoneLabel(l.Loc, b.now())
+	if byNextDose {
+		text.WriteString(fmt.Sprintf("📋 Твои напоминания по ближайшему приёму (часовой пояс %s):\n\n", zone))
+	} else {
+		text.WriteString(fmt.Sprintf("📋 Твои напоминания (часовой пояс %s):\n\n", zone))
+	}
+
+	for _, r := range reminders {
+		partners := linkedPartners(all, r)
+		if r.Paused {
+			text.WriteString(fmt.Sprintf("⏸ %s — 💊 %s — 📊 %s (на паузе)\n", r.TimeLabel(), doseName(r), r.CourseString(b.now())))
+			if len(partners) > 0 {
+				text.WriteString("    ↳ 🔗 вместе с: " + medicineNames(partners) + "\n")
+			}
+			continue
+		}
+		text.WriteString(fmt.Sprintf("⏰ %s — 💊 %s — 📊 %s", r.TimeLabel(), doseName(r), r.CourseString(b.now())))
+		if mark, ok := marks[r.ID]; ok {
+			text.WriteString(" · " + mark)
+		}
+		text.WriteString("\n")
+		if r.LastTakenAt == nil && r.CourseDay(b.now()) == 0 {
+			text.WriteString(fmt.Sprintf("    ↳ первый приём: %s\n", b.relativeDateTime(l, r.StartsAt)))
+		} else {
+			text.WriteString(fmt.Sprintf("    ↳ последний приём: %s\n", b.lastTakenString(l, r)))
+		}
+		if len(partners) > 0 {
+			text.WriteString("    ↳ 🔗 вместе с: " + medicineNames(partners) + "\n")
+		}
+	}
+
+	// Кнопки удаления и настроек
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, r := range reminders {
+		var row []tgbotapi.InlineKeyboardButton
+		row = appendDataButton(row,
+			fmt.Sprintf("🗑 %s %s [%s]", r.TimeString(), buttonName(r.Medicine), r.CourseString(b.now())),
+			fmt.Sprintf("del_%d", r.ID),
+		)
+		row = appendDataButton(row, "✏️", fmt.Sprintf("edittime_%d", r.ID))
+		row = appendDataButton(row, "📄", fmt.Sprintf("dup_%d", r.ID))
+		row = appendDataButton(row, "⚙️", fmt.Sprintf("rem_%d", r.ID))
+		rows = append(rows, row)
+	}
+	rows = append(rows, appendDataButton(nil, "🗓 На неделю вперёд", "preview"))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	// Длинный список — частями, кнопки у последней
+	parts := longMessageParts(text.String())
+	for _, part := range parts[:len(parts)-1] {
+		b.sendMessage(chatID, part)
+	}
+	reply := tgbotapi.NewMessage(chatID, parts[len(parts)-1])
+	reply.ReplyMarkup = keyboard
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// todayMarks отмечает напоминания, которые приходят сегодня (в поясе пользователя):
+// ✓ — все сегодняшние приёмы подтверждены, — — ещё нет. Если лекарство сегодня
+// принимается несколько раз (несколько напоминаний или приём каждые N часов),
+// к отметке добавляется счётчик: "✓ 1/2 сегодня".
+// Напоминания, которых сегодня нет (пауза, разовое на другую дату), не отмечаются.
+func (b *Bot) todayMarks(chatID int64, reminders []Reminder, l Locale) map[int]string {
+	now := b.now().In(l.Loc)
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Loc)
+	to := from.AddDate(0, 0, 1)
+
+	taken, err := b.storage.GetTakenDoseCounts(chatID, from, to)
+	if err != nil {
+		log.Printf("Failed to get today's doses for %d: %v", chatID, err)
+		return nil
+	}
+
+	// Сколько раз сегодня приходит и сколько подтверждено по каждому лекарству
+	due := make(map[int]int)
+	total := make(map[string]int)
+	for _, o := range reminderOccurrences(reminders, from, to, now, l.Loc, nil, nil) {
+		due[o.Reminder.ID]++
+		total[strings.ToLower(o.Reminder.Medicine)]++
+	}
+	done := make(map[string]int)
+	for _, r := range reminders {
+		done[strings.ToLower(r.Medicine)] += min(taken[r.ID], due[r.ID])
+	}
+
+	marks := make(map[int]string, len(due))
+	for _, r := range reminders {
+		if due[r.ID] == 0 {
+			continue
+		}
+		mark := "—"
+		if taken[r.ID] >= due[r.ID] {
+			mark = "✓"
+		}
+		if key := strings.ToLower(r.Medicine); total[key] > 1 {
+			mark += fmt.Sprintf(" %d/%d сегодня", done[key], total[key])
+		}
+		marks[r.ID] = mark
+	}
+	return marks
+}
+
+// lastTakenString описывает время последнего приёма: "сегодня 08:03"
+func (b *Bot) lastTakenString(l Locale, r Reminder) string {
+	if r.LastTakenAt == nil {
+		return "ещё не принимал"
+	}
+
+	return b.relativeDateTime(l, *r.LastTakenAt)
+}
+
+// relativeDateTime форматирует момент как "сегодня 08:03", "вчера 21:00",
+// "завтра 08:00" или "02.01 08:00"
+func (b *Bot) relativeDateTime(l Locale, t time.Time) string {
+	t = t.In(l.Loc)
+	now := b.now().In(l.Loc)
+	clock := formatClock(l, t)
+
+	switch {
+	case sameDay(t, now):
+		return "сегодня " + clock
+	case sameDay(t, now.AddDate(0, 0, -1)):
+		return "вчера " + clock
+	case sameDay(t, now.AddDate(0, 0, 1)):
+		return "завтра " + clock
+	}
+	return formatShortDate(l, t) + " " + clock
+}
+
+// sameDay проверяет, что моменты приходятся на один календарный день
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// handleReminderSettings отправляет меню настроек напоминания
+func (b *Bot) handleReminderSettings(chatID int64, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминания")
+		return
+	}
+	if reminder == nil {
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	partners := b.reminderPartners(chatID, *reminder)
+	msg := tgbotapi.NewMessage(chatID, b.reminderSettingsText(*reminder, partners))
+	msg.ReplyMarkup = reminderSettingsKeyboard(*reminder, len(partners) > 0)
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// reminderPartners возвращает напоминания, которые принимаются вместе с r
+func (b *Bot) reminderPartners(chatID int64, r Reminder) []Reminder {
+	if r.GroupID == nil {
+		return nil
+	}
+
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+		return nil
+	}
+	return linkedPartners(reminders, r)
+}
+
+// reminderSettingsText описывает напоминание и его текущие настройки
+func (b *Bot) reminderSettingsText(r Reminder, partners []Reminder) string {
+	snooze := fmt.Sprintf("как у всех (%s)", formatDuration(defaultLocale, b.snoozeMinutes[0]))
+	if r.SnoozeMinutes > 0 {
+		snooze = formatDuration(defaultLocale, r.SnoozeMinutes)
+	}
+
+	text := fmt.Sprintf("⚙️ Настройки напоминания\n\n⏰ %s — 💊 %s — 📊 %s\n\n⏰ Отложить по умолчанию: %s",
+		r.TimeLabel(), displayName(r.Medicine), r.CourseString(b.now()), snooze)
+	if len(partners) > 0 {
+		text += "\n🔗 Принимать вместе с: " + medicineNames(partners)
+	}
+	if r.Timezone != "" {
+		text += "\n🌍 Свой часовой пояс: " + r.Timezone
+	}
+	if !r.RequireConfirm {
+		text += "\n🔕 Без подтверждения — приходит без кнопок и засчитывается сразу"
+	}
+	if r.Important {
+		text += "\n❗ Важное — если доза не подтверждена, напомню о ней при следующем приёме"
+	}
+	if r.Paused {
+		text += "\n⏸ На паузе — напоминание не приходит"
+	}
+	return text
+}
+
+func reminderSettingsKeyboard(r Reminder, linked bool) tgbotapi.InlineKeyboardMarkup {
+	pauseButton := tgbotapi.NewInlineKeyboardButtonData("⏸ Приостановить", fmt.Sprintf("rempause_%d_1", r.ID))
+	if r.Paused {
+		pauseButton = tgbotapi.NewInlineKeyboardButtonData("▶️ Возобновить", fmt.Sprintf("rempause_%d_0", r.ID))
+	}
+
+	importantButton := tgbotapi.NewInlineKeyboardButtonData("❗ Важное", fmt.Sprintf("remimportant_%d_1", r.ID))
+	if r.Important {
+		importantButton = tgbotapi.NewInlineKeyboardButtonData("❕ Не важное", fmt.Sprintf("remimportant_%d_0", r.ID))
+	}
+
+	confirmButton := tgbotapi.NewInlineKeyboardButtonData("🔕 Без подтверждения", fmt.Sprintf("remconfirm_%d_0", r.ID))
+	if !r.RequireConfirm {
+		confirmButton = tgbotapi.NewInlineKeyboardButtonData("✅ С кнопкой «Принял»", fmt.Sprintf("remconfirm_%d_1", r.ID))
+	}
+
+	linkRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔗 Принимать вместе с…", fmt.Sprintf("remlink_%d", r.ID)),
+	)
+	if linked {
+		linkRow = append(linkRow, tgbotapi.NewInlineKeyboardButtonData("✂️ Отвязать", fmt.Sprintf("remunlink_%d", r.ID)))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏰ Отложить по умолчанию", fmt.Sprintf("remsnooze_%d", r.ID)),
+		),
+		linkRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📅 Длина курса", fmt.Sprintf("remcourse_%d", r.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("🌍 Часовой пояс", fmt.Sprintf("remtz_%d", r.ID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(confirmButton),
+		tgbotapi.NewInlineKeyboardRow(importantButton, pauseButton),
+	)
+}
+
+// handleReminderPause ставит напоминание на паузу или возобновляет его
+func (b *Bot) handleReminderPause(chatID int64, messageID int, reminderID int, paused bool) {
+	err := b.storage.SetReminderPaused(chatID, reminderID, paused)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to set reminder paused: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// handleReminderRequireConfirm включает или выключает кнопку "Принял".
+// Вызывается и из настроек, и из сообщения о добавлении напоминания.
+func (b *Bot) handleReminderRequireConfirm(chatID int64, messageID int, reminderID int, require bool) {
+	err := b.storage.SetReminderRequireConfirm(chatID, reminderID, require)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to set reminder require confirm: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// askReminderCourse просит ввести новую длину курса напоминания
+func (b *Bot) askReminderCourse(chatID int64, messageID int, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		return
+	}
+	if reminder == nil {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{State: StateWaitingReminderCourse, ReminderID: reminderID, MsgID: messageID, StartedAt: b.now()}
+	b.mu.Unlock()
+
+	text := fmt.Sprintf("📅 Длина курса 💊 %s\n\nСейчас: %s. Введи новое количество дней от %d до %d или 0 для бесконечного курса.",
+		displayName(reminder.Medicine), reminder.CourseString(b.now()), max(reminder.DosesTaken, 1), b.storage.MaxCourseDays())
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleReminderCourseInput сохраняет новую длину курса.
+// Курс короче уже принятых доз не принимается; если длина равна числу
+// принятых доз, курс завершается сразу — как после последнего приёма.
+func (b *Bot) handleReminderCourseInput(msg *tgbotapi.Message, reminderID int) {
+	chatID := msg.Chat.ID
+
+	courseDays, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+	if err != nil {
+		b.sendMessage(chatID, fmt.Sprintf("Пожалуйста, введи число от 1 до %d или 0 для бесконечного курса:", b.storage.MaxCourseDays()))
+		return
+	}
+
+	completed, err := b.storage.UpdateCourseDays(chatID, reminderID, courseDays)
+	var tooShort *CourseTooShortError
+	var outOfRange *CourseDaysRangeError
+	switch {
+	case errors.As(err, &outOfRange):
+		b.sendMessage(chatID, fmt.Sprintf("Пожалуйста, введи число от 1 до %d или 0 для бесконечного курса:", outOfRange.Max))
+		return
+	case errors.As(err, &tooShort):
+		// Состояние не сбрасываем — можно сразу ввести другое число
+		b.sendMessage(chatID, fmt.Sprintf("⚠️ Уже принято доз: %d — курс не может быть короче. "+
+			"Введи %d, чтобы завершить курс сейчас, или больше, чтобы продолжить:", tooShort.DosesTaken, tooShort.DosesTaken))
+		return
+	case errors.Is(err, ErrReminderNotFound):
+		b.clearPending(chatID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to update course days: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	messageID := b.clearPending(chatID)
+	if messageID != 0 {
+		b.deleteMessage(chatID, messageID)
+	}
+
+	if !completed {
+		b.handleReminderSettings(chatID, reminderID)
+		return
+	}
+
+	// Все дозы нового курса уже приняты — завершаем, как после последнего приёма
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil || reminder == nil {
+		log.Printf("Failed to get reminder: %v", err)
+		return
+	}
+	summary, err := b.storage.GetCourseSummary(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get course summary: %v", err)
+	}
+	if err := b.storage.CompleteReminder(chatID, reminderID); err != nil {
+		log.Printf("Failed to complete reminder: %v", err)
+	}
+	// Пользователь сам изменил курс, поэтому ответ нужен и при выключенном поздравлении
+	style := b.celebration(chatID)
+	if style == celebrationOff {
+		style = celebrationPlain
+	}
+	b.sendMessage(chatID, b.courseCompletedText(chatID, style, reminder.Medicine, summary))
+}
+
+// clearPending сбрасывает диалог пользователя и возвращает ID его сообщения (0 — нет)
+func (b *Bot) clearPending(chatID int64) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messageID := 0
+	if p := b.pending[chatID]; p != nil {
+		messageID = p.MsgID
+	}
+	delete(b.pending, chatID)
+	return messageID
+}
+
+// handleReminderImportant включает или выключает напоминание о пропущенной дозе
+func (b *Bot) handleReminderImportant(chatID int64, messageID int, reminderID int, important bool) {
+	err := b.storage.SetReminderImportant(chatID, reminderID, important)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to set reminder important: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// showReminderSnoozeChoices показывает варианты основной длительности "отложить"
+func (b *Bot) showReminderSnoozeChoices(chatID int64, messageID int, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		return
+	}
+	if reminder == nil {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, m := range reminderSnoozeChoices {
+		label := formatDuration(defaultLocale, m)
+		if m == reminder.SnoozeMinutes {
+			label = "✓ " + label
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("remsnooze_%d_%d", reminderID, m)))
+		if len(row) == 4 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	rows = append(rows,
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("↩️ Как у всех", fmt.Sprintf("remsnooze_%d_0", reminderID))},
+		[]tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", fmt.Sprintf("remsnooze_%d_back", reminderID))},
+	)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID,
+		fmt.Sprintf("⏰ На сколько откладывать 💊 %s по умолчанию?\n\nЭта длительность будет первой кнопкой \"Отложить\", остальные варианты останутся.", displayName(reminder.Medicine)))
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleReminderSnoozeSet сохраняет основную длительность "отложить" (0 — общая настройка)
+// и возвращается к меню настроек напоминания
+func (b *Bot) handleReminderSnoozeSet(chatID int64, messageID int, reminderID int, minutes int) {
+	if minutes != 0 && (minutes < minSnoozeMinutes || minutes > maxSnoozeMinutes) {
+		return
+	}
+
+	err := b.storage.SetReminderSnooze(chatID, reminderID, minutes)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to set reminder snooze: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// editReminderSettings показывает меню настроек напоминания в существующем сообщении
+func (b *Bot) editReminderSettings(chatID int64, messageID int, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil || reminder == nil {
+		if err != nil {
+			log.Printf("Failed to get reminder: %v", err)
+		}
+		b.deleteMessage(chatID, messageID)
+		return
+	}
+
+	partners := b.reminderPartners(chatID, *reminder)
+	keyboard := reminderSettingsKeyboard(*reminder, len(partners) > 0)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, b.reminderSettingsText(*reminder, partners))
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+func (b *Bot) handleDeleteReminder(chatID int64, messageID int, reminderID int) {
+	err := b.storage.DeleteReminder(chatID, reminderID)
+	b.deleteMessage(chatID, messageID)
+
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+	case err != nil:
+		log.Printf("Failed to delete reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка удаления. Попробуй снова: /list")
+	default:
+		b.cancelSnooze(reminderID)
+		b.sendMessage(chatID, "🗑 Напоминание удалено")
+	}
+}
+
+// handleClear просит подтвердить удаление всех напоминаний
+func (b *Bot) handleClear(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	count, err := b.storage.CountReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to count reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+
+	if count == 0 {
+		b.sendMessage(chatID, "У тебя пока нет напоминаний.\n\nИспользуй /add чтобы добавить")
+		return
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Да, удалить все", "clear_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+		),
+	)
+
+	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("Удалить все напоминания (%d)?\n\nЭто действие нельзя отменить.", count))
+	reply.ReplyMarkup = keyboard
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// handleClearConfirmed удаляет все напоминания пользователя после подтверждения
+func (b *Bot) handleClearConfirmed(chatID int64, messageID int) {
+	b.deleteMessage(chatID, messageID)
+
+	removed, err := b.storage.DeleteAllReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to delete all reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка удаления. Попробуй снова: /clear")
+		return
+	}
+
+	b.sendMessage(chatID, fmt.Sprintf("🗑 Удалено напоминаний: %d\n\nИспользуй /add чтобы добавить новое", removed))
+}
+
+func (b *Bot) handleStats(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	// Проверка прав администратора
+	if b.adminID != 0 && chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	st, err := b.storage.GetStats()
+	if err != nil {
+		log.Printf("Failed to get stats: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки статистики")
+		return
+	}
+
+	text := fmt.Sprintf("📊 Статистика бота:\n\n"+
+		"👥 Всего пользователей: %d\n"+
+		"✅ С включёнными напоминаниями: %d\n\n"+
+		"💊 Всего напоминаний: %d\n"+
+		"   ▶️ Приходят сейчас: %d\n"+
+		"   ⏸ На паузе: %d\n"+
+		"   📅 Курсов с датой окончания: %d\n"+
+		"   ♾ Бесконечных курсов: %d\n"+
+		"🏁 Завершённых курсов в истории: %d\n\n"+
+		"📈 Принято доз: %d\n"+
+		"📋 Запланировано доз: %d\n"+
+		"⏭ Пропущено намеренно: %d",
+		st.TotalUsers, st.ActiveUsers, st.TotalReminders, st.RunnableReminders, st.PausedReminders,
+		st.FiniteCourses, st.InfiniteCourses, st.CompletedCourses, st.TotalDosesTaken, st.TotalDosesPlanned, st.TotalDosesSkipped)
+	text += b.stopReasonStats()
+
+	b.sendLongMessage(chatID, text)
+}
+
+// handleUser показывает администратору пользователя и его напоминания: /user <chat_id>
+func (b *Bot) handleUser(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	targetID, err := strconv.ParseInt(strings.TrimSpace(msg.CommandArguments()), 10, 64)
+	if err != nil {
+		b.sendMessage(chatID, "Укажи ID пользователя: /user 123456789")
+		return
+	}
+
+	user, err := b.storage.GetUser(targetID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", targetID, err)
+		b.sendMessage(chatID, "Ошибка загрузки пользователя")
+		return
+	}
+	b.audit(chatID, auditUserLookup, strconv.FormatInt(targetID, 10))
+	if user == nil {
+		b.sendMessage(chatID, "Пользователь не найден")
+		return
+	}
+
+	status := "✅ напоминания включены"
+	if !user.Active {
+		status = "⏸ напоминания отключены"
+	}
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("👤 %s\n%s\n🌍 %s\n\n", userLabel(user.Username, user.FirstName, targetID), status, b.userLoc(targetID)))
+
+	if len(user.Reminders) == 0 {
+		text.WriteString("Напоминаний нет")
+	}
+	for _, r := range user.Reminders {
+		text.WriteString(fmt.Sprintf("#%d ⏰ %s — 💊 %s — 📊 %s — 📥 %s\n", r.ID, r.TimeLabel(), displayName(r.Medicine), r.CourseString(b.now()), r.Source))
+	}
+
+	b.sendLongMessage(chatID, text.String())
+}
+
+// handleResume снова включает напоминания после /stop
+func (b *Bot) handleResume(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+	if err := b.storage.SetUserActive(chatID, true); err != nil {
+		log.Printf("Failed to set user active %d: %v", chatID, err)
+	}
+
+	reply := tgbotapi.NewMessage(chatID, "▶️ Напоминания снова включены")
+	reply.ReplyMarkup = b.getMainKeyboard(chatID, true)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message to %d: %v", chatID, err)
+	}
+}
+
+func (b *Bot) handleStop(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if err := b.storage.SetUserActive(chatID, false); err != nil {
+		log.Printf("Failed to deactivate user %d: %v", chatID, err)
+	}
+
+	keyboard := b.getMainKeyboard(chatID, false)
+
+	reply := tgbotapi.NewMessage(chatID, "⏸ Напоминания отключены.\n\nТвои настройки сохранены.")
+	reply.ReplyMarkup = keyboard
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message to %d: %v", chatID, err)
+		return
+	}
+
+	b.askStopReason(chatID)
+}
+
+// reactivateMode — что делать, когда напоминание добавляет пользователь,
+// отключивший напоминания через /stop
+type reactivateMode string
+
+const (
+	reactivateAuto reactivateMode = "auto" // включить все напоминания и сообщить об этом
+	reactivateAsk  reactivateMode = "ask"  // спросить, включать ли все напоминания
+	reactivateOff  reactivateMode = "off"  // не включать, только предупредить
+)
+
+// reactivatedText — сообщение о том, что напоминания снова включены
+const reactivatedText = "▶️ Напоминания снова включены — все, кроме поставленных на паузу, будут приходить по расписанию.\n\nОтключить: /stop"
+
+// parseReactivateMode разбирает REACTIVATE_ON_ADD (по умолчанию — auto)
+func parseReactivateMode(s string) reactivateMode {
+	switch mode := reactivateMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case reactivateAsk, reactivateOff:
+		return mode
+	case "", reactivateAuto:
+		return reactivateAuto
+	default:
+		log.Printf("Unknown REACTIVATE_ON_ADD=%q, using %q", s, reactivateAuto)
+		return reactivateAuto
+	}
+}
+
+// reactivateAfterAdd сообщает отключившемуся пользователю, что будет с новым
+// напоминанием, и в зависимости от настройки включает напоминания или спрашивает
+func (b *Bot) reactivateAfterAdd(chatID int64) {
+	user, err := b.storage.GetUser(chatID)
+	if err != nil {
+		log.Printf("Failed to get user %d: %v", chatID, err)
+		return
+	}
+	if user == nil || user.Active {
+		return
+	}
+
+	switch b.reactivateOnAdd {
+	case reactivateAsk:
+		reply := tgbotapi.NewMessage(chatID, "⏸ Напоминания сейчас отключены (/stop), поэтому новое тоже не придёт.\n\nВключить все напоминания снова?")
+		reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("▶️ Включить все", "reactivate_1"),
+				tgbotapi.NewInlineKeyboardButtonData("Оставить выключенными", "reactivate_0"),
+			),
+		)
+		if _, err := b.api.Send(reply); err != nil {
+			log.Printf("Failed to send message: %v", err)
+		}
+	case reactivateOff:
+		b.sendMessage(chatID, "⏸ Напоминания сейчас отключены (/stop), поэтому новое тоже не придёт. Включить их снова: /start")
+	default:
+		if err := b.storage.SetUserActive(chatID, true); err != nil {
+			log.Printf("Failed to set user active %d: %v", chatID, err)
+			return
+		}
+		b.replyWithMainKeyboard(chatID, reactivatedText)
+	}
+}
+
+// handleReactivate обрабатывает ответ на вопрос о включении напоминаний
+func (b *Bot) handleReactivate(chatID int64, messageID int, activate bool) {
+	b.deleteMessage(chatID, messageID)
+
+	if !activate {
+		b.sendMessage(chatID, "Хорошо, напоминания остаются отключёнными. Включить их: /start")
+		return
+	}
+
+	if err := b.storage.SetUserActive(chatID, true); err != nil {
+		log.Printf("Failed to set user active %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+	b.replyWithMainKeyboard(chatID, reactivatedText)
+}
+
+// maxVacationDays — максимальная длина отпуска
+const maxVacationDays = 365
+
+// handleVacation ставит напоминания на паузу на период отпуска:
+// /vacation 10.07 20.07, /vacation off — отменить
+func (b *Bot) handleVacation(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	args := strings.Fields(msg.CommandArguments())
+
+	user, _, err := b.storage.GetOrCreateUser(chatID)
+	if err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка загрузки настроек")
+		return
+	}
+
+	if len(args) == 1 && (args[0] == "off" || args[0] == "стоп") {
+		if err := b.storage.SetVacation(chatID, nil, nil); err != nil {
+			log.Printf("Failed to clear vacation: %v", err)
+			b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+			return
+		}
+		b.sendMessage(chatID, "✅ Отпуск отменён, напоминания снова приходят по расписанию")
+		return
+	}
+
+	if len(args) != 2 {
+		text := "🏖 Режим отпуска\n\nУкажи даты начала и конца (включительно):\n/vacation 10.07 20.07\n\nОтменить: /vacation off"
+		if user.VacationFrom != nil && user.VacationUntil != nil {
+			l := b.userLocale(chatID, defaultLocale)
+			text = fmt.Sprintf("🏖 Отпуск: %s — %s\n\n", formatCalendarDate(l, *user.VacationFrom), formatCalendarDate(l, *user.VacationUntil)) + text
+		}
+		b.sendMessage(chatID, text)
+		return
+	}
+
+	today := b.now().In(b.userLoc(chatID))
+	from, until, err := parseVacationRange(args[0], args[1], today)
+	if err != nil {
+		b.sendMessage(chatID, "⚠️ "+err.Error()+"\n\nПример: /vacation 10.07 20.07")
+		return
+	}
+
+	if err := b.storage.SetVacation(chatID, &from, &until); err != nil {
+		log.Printf("Failed to set vacation: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+
+	b.sendMessage(chatID, fmt.Sprintf("🏖 Отпуск: %s — %s\n\nВ эти дни напоминания приходить не будут, после — возобновятся автоматически.\nОтменить: /vacation off",
+		formatCalendarDate(b.userLocale(chatID, defaultLocale), from), formatCalendarDate(b.userLocale(chatID, defaultLocale), until)))
+}
+
+// handleWebhook настраивает webhook для уведомлений о пропущенных дозах:
+// /webhook https://example.com/hook, /webhook off — отключить
+func (b *Bot) handleWebhook(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	arg := strings.TrimSpace(msg.CommandArguments())
+
+	user, _, err := b.storage.GetOrCreateUser(chatID)
+	if err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка загрузки настроек")
+		return
+	}
+
+	switch arg {
+	case "":
+		text := "🔗 Webhook для пропущенных доз\n\nЕсли приём не подтверждён, бот отправит POST-запрос с JSON (chat_id, medicine, scheduled_time, status) — например, в систему опекуна.\n\nНастроить: /webhook https://example.com/hook\nОтключить: /webhook off"
+		if user.WebhookURL != "" {
+			text = fmt.Sprintf("🔗 Webhook: %s\n\n", user.WebhookURL) + text
+		}
+		b.sendMessage(chatID, text)
+		return
+	case "off", "стоп":
+		if err := b.storage.SetWebhookURL(chatID, ""); err != nil {
+			log.Printf("Failed to clear webhook: %v", err)
+			b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+			return
+		}
+		b.sendMessage(chatID, "✅ Webhook отключён")
+		return
+	}
+
+	if err := validateWebhookURL(arg); err != nil {
+		b.sendMessage(chatID, "⚠️ Webhook не сохранён: "+err.Error()+"\n\nПример: /webhook https://example.com/hook")
+		return
+	}
+
+	if err := b.storage.SetWebhookURL(chatID, arg); err != nil {
+		log.Printf("Failed to set webhook: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+	b.sendMessage(chatID, "✅ Webhook сохранён. Пропущенные приёмы будут отправляться на этот адрес")
+}
+
+// parseVacationRange разбирает даты отпуска в формате ДД.ММ или ДД.ММ.ГГГГ.
+// Если год не указан, конец отпуска — ближайшая будущая дата,
+// а начало берётся в том же году (или в предыдущем, если иначе начало позже конца).
+func parseVacationRange(fromStr, untilStr string, today time.Time) (from, until time.Time, err error) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	until, untilHasYear, err := parseDayMonth(untilStr, today.Year())
+	if err != nil {
+		return from, until, err
+	}
+	if !untilHasYear && until.Before(today) {
+		until = until.AddDate(1, 0, 0)
+	}
+
+	from, fromHasYear, err := parseDayMonth(fromStr, until.Year())
+	if err != nil {
+		return from, until, err
+	}
+	if !fromHasYear && from.After(until) {
+		from = from.AddDate(-1, 0, 0)
+	}
+
+	switch {
+	case from.After(until):
+		return from, until, fmt.Errorf("дата начала позже даты окончания")
+	case until.Before(today):
+		return from, until, fmt.Errorf("этот период уже прошёл")
+	case until.Sub(from) > maxVacationDays*24*time.Hour:
+		return from, until, fmt.Errorf("отпуск не может быть длиннее %d дней", maxVacationDays)
+	}
+	return from, until, nil
+}
+
+// parseDayMonth разбирает дату ДД.ММ или ДД.ММ.ГГГГ
+func parseDayMonth(s string, defaultYear int) (t time.Time, hasYear bool, err error) {
+	if t, err := time.Parse("2.1.2006", s); err == nil {
+		return t, true, nil
+	}
+	t, err = time.Parse("2.1", s)
+	if err != nil {
+		return t, false, fmt.Errorf("не удалось разобрать дату %q", s)
+	}
+	return time.Date(defaultYear, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), false, nil
+}
+
+func (b *Bot) getMainKeyboard(chatID int64, active bool) tgbotapi.ReplyKeyboardMarkup {
+	var rows [][]tgbotapi.KeyboardButton
+
+	if active {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(addButtonText),
+			tgbotapi.NewKeyboardButton("📋 Мои напоминания"),
+		))
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("⏸ Отключить"),
+		))
+	} else {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("▶️ Включить"),
+		))
+	}
+
+	// Кнопки админа
+	if b.adminID != 0 && chatID == b.adminID {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("📊 Статистика"),
+			tgbotapi.NewKeyboardButton("📣 Рассылка"),
+		))
 	}
 
-	b.mu.Lock()
-	if p := b.pending[chatID]; p != nil {
-		p.Medicine = medicine
-		p.State = StateWaitingHour
+	keyboard := tgbotapi.ReplyKeyboardMarkup{
+		Keyboard:       rows,
+		ResizeKeyboard: true,
 	}
-	b.mu.Unlock()
+	return keyboard
+}
 
-	// Показываем выбор часа
-	b.showHourSelection(chatID, medicine)
+func (b *Bot) sendMessage(chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if _, err := b.api.Send(msg); err != nil && !b.deactivateIfBlocked(chatID, err) {
+		log.Printf("Failed to send message to %d: %v", chatID, err)
+	}
 }
 
-func (b *Bot) showHourSelection(chatID int64, medicine string) {
-	var rows [][]tgbotapi.InlineKeyboardButton
+// maxMessageParts — на сколько сообщений можно разбить длинный вывод;
+// остальное обрезается с пометкой, чтобы не заспамить чат
+const maxMessageParts = 5
 
-	// Утро: 6-11
-	row1 := []tgbotapi.InlineKeyboardButton{}
-	for h := 6; h <= 11; h++ {
-		row1 = append(row1, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", h), fmt.Sprintf("hour_%d", h)))
+// messagePartReserve — место в каждой части под номер части и пометку об обрезке
+const messagePartReserve = 64
+
+// longMessageParts делит текст, который может не поместиться в одно сообщение
+// (админские отчёты, списки), по строкам на пронумерованные части,
+// а больше maxMessageParts частей обрезает
+func longMessageParts(text string) []string {
+	parts := splitMessage(text, maxMessageLength-messagePartReserve)
+	if len(parts) == 0 {
+		return []string{text}
+	}
+	total := len(parts)
+	if total > maxMessageParts {
+		parts = parts[:maxMessageParts]
+		parts[len(parts)-1] += fmt.Sprintf("\n\n✂️ Вывод обрезан: показано %d частей из %d", maxMessageParts, total)
+	}
+	if len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("📄 %d/%d\n", i+1, len(parts)) + parts[i]
+		}
 	}
-	rows = append(rows, row1)
+	return parts
+}
 
-	// День: 12-17
-	row2 := []tgbotapi.InlineKeyboardButton{}
-	for h := 12; h <= 17; h++ {
-		row2 = append(row2, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", h), fmt.Sprintf("hour_%d", h)))
+// sendLongMessage отправляет текст частями — см. longMessageParts
+func (b *Bot) sendLongMessage(chatID int64, text string) {
+	for _, part := range longMessageParts(text) {
+		b.sendMessage(chatID, part)
 	}
-	rows = append(rows, row2)
+}
 
-	// Вечер: 18-23
-	row3 := []tgbotapi.InlineKeyboardButton{}
-	for h := 18; h <= 23; h++ {
-		row3 = append(row3, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%02d", h), fmt.Sprintf("hour_%d", h)))
+func (b *Bot) deleteMessage(chatID int64, messageID int) {
+	del := tgbotapi.NewDeleteMessage(chatID, messageID)
+	if _, err := b.api.Request(del); err != nil {
+		log.Printf("Failed to delete message: %v", err)
 	}
-	rows = append(rows, row3)
+}
 
-	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
-	})
+// takenConfirmWindow — сколько времени после слота можно нажать "Принял".
+// Более старые подтверждения отклоняются, чтобы не засчитать чужой день.
+const takenConfirmWindow = 12 * time.Hour
+
+// sendReminderWithButton отправляет напоминание с кнопками "Принял" и "Отложить".
+// В callback кодируется время слота, чтобы отклонять устаревшие подтверждения.
+// Напоминание без подтверждения отправляется без кнопок.
+func (b *Bot) sendReminderWithButton(chatID int64, lang string, r Reminder, slot time.Time) error {
+	text := T(lang, "reminder.text", doseName(r), r.CourseString(b.now()))
+	if !r.RequireConfirm {
+		_, err := b.api.Send(tgbotapi.NewMessage(chatID, text))
+		b.deactivateIfBlocked(chatID, err)
+		return err
+	}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = b.reminderKeyboard(lang, r, slot)
+	_, err := b.api.Send(msg)
+	b.deactivateIfBlocked(chatID, err)
+	return err
+}
 
-	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("💊 %s\n\nВыбери час (Часовой пояс: Екатеринбург):", medicine))
-	reply.ReplyMarkup = keyboard
-	if _, err := b.api.Send(reply); err != nil {
-		log.Printf("Failed to send message: %v", err)
+// reminderKeyboard — кнопки напоминания о дозе slot: "Принял", "Отложить" и "Пропустить сегодня"
+func (b *Bot) reminderKeyboard(lang string, r Reminder, slot time.Time) tgbotapi.InlineKeyboardMarkup {
+	var snoozeRow []tgbotapi.InlineKeyboardButton
+	for _, minutes := range snoozeOptions(r.SnoozeMinutes, b.snoozeMinutes) {
+		snoozeRow = appendDataButton(snoozeRow,
+			T(lang, "button.snooze", formatDuration(lang, minutes)),
+			fmt.Sprintf("snooze_%d_%d_%d", r.ID, slot.Unix(), minutes),
+		)
 	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		appendDataButton(nil, T(lang, "button.taken"), fmt.Sprintf("taken_%d_%d", r.ID, slot.Unix())),
+		snoozeRow,
+		appendDataButton(nil, T(lang, "button.skip"), fmt.Sprintf("skip_%d_%d", r.ID, slot.Unix())),
+	)
 }
 
-func (b *Bot) handleHourSelected(chatID int64, messageID int, hour int) {
-	b.mu.Lock()
-	p := b.pending[chatID]
-	if p == nil || p.Medicine == "" {
-		b.mu.Unlock()
-		b.deleteMessage(chatID, messageID)
-		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+// missedDoseLookback — насколько далеко назад ищется предыдущая доза важного напоминания.
+// Более старые пропуски (например, до отпуска) уже неактуальны.
+const missedDoseLookback = 48 * time.Hour
+
+// notifyMissedPreviousDose перед отправкой важного напоминания сообщает,
+// если предыдущая доза того же лекарства так и не была подтверждена
+func (b *Bot) notifyMissedPreviousDose(chatID int64, lang string, r Reminder, slot time.Time) {
+	prev, err := b.storage.GetUnconfirmedPreviousDose(chatID, r.Medicine, slot.Add(-missedDoseLookback), slot)
+	if err != nil {
+		log.Printf("Failed to get previous dose: %v", err)
 		return
 	}
-	medicine := p.Medicine
-	p.Hour = hour
-	p.State = StateWaitingMinute
-	b.mu.Unlock()
-
-	// Показываем выбор минут
-	minutes := []int{0, 15, 30, 45}
-	var row []tgbotapi.InlineKeyboardButton
-	for _, m := range minutes {
-		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
-			fmt.Sprintf("%02d:%02d", hour, m),
-			fmt.Sprintf("time_%d:%d", hour, m),
-		))
+	if prev == nil {
+		return
 	}
 
-	rows := [][]tgbotapi.InlineKeyboardButton{
-		row,
-		{tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")},
-	}
+	l := b.userLocale(chatID, lang)
+	when := formatShortDate(l, *prev) + " " + formatClock(l, *prev)
+	// Отдельное от напоминания сообщение той же рассылки — ему нужен свой токен лимита
+	b.sendLimiter.Wait()
+	b.sendMessage(chatID, T(lang, "reminder.missed", displayName(r.Medicine), when))
+}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+// Что делать с дозой напоминания без подтверждения (NO_CONFIRM_MODE)
+const (
+	noConfirmTaken   = "taken"   // засчитать как принятую
+	noConfirmExclude = "exclude" // засчитать в курс, но не учитывать в соблюдении режима
+)
 
-	edit := tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf("💊 %s\n\nВыбери точное время (Часовой пояс: Екатеринбург):", medicine))
-	edit.ReplyMarkup = &keyboard
-	if _, err := b.api.Send(edit); err != nil {
-		log.Printf("Failed to edit message: %v", err)
+// parseNoConfirmStatus разбирает NO_CONFIRM_MODE в статус записи журнала
+func parseNoConfirmStatus(value string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", noConfirmTaken:
+		return DoseTaken
+	case noConfirmExclude:
+		return DoseNotified
 	}
+	log.Printf("Unknown NO_CONFIRM_MODE %q, using %q", value, noConfirmTaken)
+	return DoseTaken
 }
 
-func (b *Bot) handleTimeSelected(chatID int64, messageID int, hour, minute int) {
-	b.mu.Lock()
-	p := b.pending[chatID]
-	if p == nil || p.Medicine == "" {
-		b.mu.Unlock()
-		b.deleteMessage(chatID, messageID)
-		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+// autoCountDose засчитывает отправленное напоминание без подтверждения.
+// Курс продвигается как при нажатии "Принял"; о завершении курса сообщаем как обычно.
+func (b *Bot) autoCountDose(chatID int64, r Reminder, slot time.Time) {
+	_, completionText, err := b.countDose(chatID, r.ID, slot, b.noConfirmStatus)
+	if err != nil {
+		if !errors.Is(err, ErrDoseAlreadyTaken) {
+			log.Printf("Failed to auto-count dose of reminder %d: %v", r.ID, err)
+		}
 		return
 	}
+	if completionText != "" {
+		b.sendMessage(chatID, completionText)
+	}
+}
 
-	// Сохраняем выбранное время и переходим к выбору курса
-	p.Hour = hour
-	p.Minute = minute
-	p.State = StateWaitingCourse
-	medicine := p.Medicine
-	b.mu.Unlock()
+// rememberUser сохраняет username, имя и язык интерфейса пользователя —
+// для рассылок и админских команд, даже если пользователь давно не писал
+func (b *Bot) rememberUser(from *tgbotapi.User) {
+	// В режиме обслуживания профиль не обновляем: это тоже запись
+	if from == nil || b.inMaintenance() {
+		return
+	}
 
-	// Показываем выбор длительности курса
-	b.showCourseSelection(chatID, messageID, medicine, hour, minute)
+	language := ""
+	if from.LanguageCode != "" {
+		language = normalizeLocale(from.LanguageCode)
+	}
+	if err := b.storage.UpdateUserProfile(from.ID, from.UserName, from.FirstName, language); err != nil {
+		log.Printf("Failed to save profile for %d: %v", from.ID, err)
+	}
 }
 
-func (b *Bot) showCourseSelection(chatID int64, messageID int, medicine string, hour, minute int) {
-	rows := [][]tgbotapi.InlineKeyboardButton{
-		{
-			tgbotapi.NewInlineKeyboardButtonData("7 дней", "course_7"),
-			tgbotapi.NewInlineKeyboardButtonData("14 дней", "course_14"),
-			tgbotapi.NewInlineKeyboardButtonData("21 день", "course_21"),
-		},
-		{
-			tgbotapi.NewInlineKeyboardButtonData("30 дней", "course_30"),
-			tgbotapi.NewInlineKeyboardButtonData("60 дней", "course_60"),
-			tgbotapi.NewInlineKeyboardButtonData("90 дней", "course_90"),
-		},
-		{
-			tgbotapi.NewInlineKeyboardButtonData("♾ Бесконечно", "course_0"),
-		},
-		{
-			tgbotapi.NewInlineKeyboardButtonData("✏️ Ввести своё", "course_custom"),
-		},
-		{
-			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
-		},
+// removeButtons убирает инлайн-кнопки сообщения, не меняя текст
+func (b *Bot) removeButtons(chatID int64, messageID int) {
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID,
+		tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
 	}
+}
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
-
-	text := fmt.Sprintf("💊 %s\n⏰ %02d:%02d\n\nВыбери длительность курса:", medicine, hour, minute)
+// markReminderStale убирает кнопки у устаревшего напоминания
+func (b *Bot) markReminderStale(chatID int64, messageID int, messageText string) {
+	text := messageText + "\n\n⌛ Это напоминание устарело, отметить приём уже нельзя."
 	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
-	edit.ReplyMarkup = &keyboard
 	if _, err := b.api.Send(edit); err != nil {
 		log.Printf("Failed to edit message: %v", err)
 	}
 }
 
-func (b *Bot) handleCourseSelected(chatID int64, messageID int, courseDays int) {
-	b.mu.Lock()
-	p := b.pending[chatID]
-	if p == nil || p.Medicine == "" {
-		b.mu.Unlock()
-		b.deleteMessage(chatID, messageID)
-		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+// handleSnooze откладывает напоминание на minutes минут без изменения счётчика
+func (b *Bot) handleSnooze(chatID int64, messageID int, messageText, lang string, reminderID int, slot time.Time, minutes int) {
+	if b.now().Sub(slot) > takenConfirmWindow {
+		b.markReminderStale(chatID, messageID, messageText)
+		return
+	}
+	if minutes < minSnoozeMinutes || minutes > maxSnoozeMinutes {
 		return
 	}
 
-	medicine := p.Medicine
-	hour := p.Hour
-	minute := p.Minute
-	delete(b.pending, chatID)
-	b.mu.Unlock()
-
-	// Сохраняем в БД
-	_, err := b.storage.AddReminder(chatID, medicine, hour, minute, courseDays)
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
 	if err != nil {
-		log.Printf("Failed to add reminder: %v", err)
-		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова: /add")
+		log.Printf("Failed to get reminder: %v", err)
+		return
+	}
+	if reminder == nil {
+		// Напоминание удалено
+		b.deleteMessage(chatID, messageID)
 		return
 	}
 
-	b.storage.SetUserActive(chatID, true)
-	b.deleteMessage(chatID, messageID)
-
-	courseStr := "♾ Бесконечно"
-	if courseDays > 0 {
-		courseStr = fmt.Sprintf("%d дней", courseDays)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID,
+		T(lang, "snooze.scheduled", formatDuration(lang, minutes), displayName(reminder.Medicine)))
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
 	}
 
-	text := fmt.Sprintf("✅ Напоминание добавлено!\n\n💊 %s\n⏰ %02d:%02d\n📅 Курс: %s\n\nИспользуй /list чтобы увидеть все напоминания",
-		medicine, hour, minute, courseStr)
-	b.sendMessage(chatID, text)
+	b.scheduleSnooze(chatID, lang, reminderID, slot, time.Duration(minutes)*time.Minute)
+	// Отложенное напоминание придёт само — повторять его ещё и через NUDGE_MINUTES не нужно
+	if err := b.storage.StopNudges(chatID, reminderID, slot); err != nil {
+		log.Printf("Failed to stop nudges: %v", err)
+	}
+	b.offerLinkedSnooze(chatID, lang, reminderID, slot, minutes)
 }
 
-func (b *Bot) handleCustomCourseInput(msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	text := strings.TrimSpace(msg.Text)
-
-	courseDays, err := strconv.Atoi(text)
-	if err != nil || courseDays < 1 || courseDays > 365 {
-		b.sendMessage(chatID, "Пожалуйста, введи число от 1 до 365:")
+// handleSkipDose отмечает дозу как намеренно пропущенную: это не пропуск
+// в статистике, и счётчик курса не увеличивается
+func (b *Bot) handleSkipDose(chatID int64, messageID int, messageText, lang string, reminderID int, slot time.Time) {
+	if b.now().Sub(slot) > takenConfirmWindow {
+		b.markReminderStale(chatID, messageID, messageText)
 		return
 	}
 
-	b.mu.Lock()
-	p := b.pending[chatID]
-	if p == nil || p.Medicine == "" {
-		b.mu.Unlock()
-		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+	medicine, err := b.storage.SkipDose(chatID, reminderID, slot)
+	switch {
+	case errors.Is(err, ErrDoseAlreadyTaken):
+		// Повторное нажатие или доза уже подтверждена
+		return
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		return
+	case err != nil:
+		log.Printf("Failed to skip dose: %v", err)
 		return
 	}
 
-	medicine := p.Medicine
-	hour := p.Hour
-	minute := p.Minute
-	delete(b.pending, chatID)
-	b.mu.Unlock()
+	// Отложенное напоминание за этот слот больше не нужно
+	b.snoozeMu.Lock()
+	if sn := b.snoozes[reminderID]; sn != nil && sn.slot.Equal(slot) {
+		sn.timer.Stop()
+		delete(b.snoozes, reminderID)
+	}
+	b.snoozeMu.Unlock()
 
-	// Сохраняем в БД
-	_, err = b.storage.AddReminder(chatID, medicine, hour, minute, courseDays)
-	if err != nil {
-		log.Printf("Failed to add reminder: %v", err)
-		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова: /add")
-		return
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, T(lang, "skip.done", displayName(medicine)))
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
 	}
+}
 
-	b.storage.SetUserActive(chatID, true)
+// scheduleSnooze заводит таймер повторной отправки. Повторное "отложить"
+// для того же напоминания заменяет предыдущий таймер.
+func (b *Bot) scheduleSnooze(chatID int64, lang string, reminderID int, slot time.Time, delay time.Duration) {
+	b.snoozeMu.Lock()
+	defer b.snoozeMu.Unlock()
 
-	resultText := fmt.Sprintf("✅ Напоминание добавлено!\n\n💊 %s\n⏰ %02d:%02d\n📅 Курс: %d дней\n\nИспользуй /list чтобы увидеть все напоминания",
-		medicine, hour, minute, courseDays)
-	b.sendMessage(chatID, resultText)
+	if sn := b.snoozes[reminderID]; sn != nil {
+		sn.timer.Stop()
+	}
+	b.snoozes[reminderID] = &snooze{
+		chatID: chatID,
+		lang:   lang,
+		slot:   slot,
+		fireAt: b.now().Add(delay),
+		timer: time.AfterFunc(delay, func() {
+			b.snoozeMu.Lock()
+			delete(b.snoozes, reminderID)
+			b.snoozeMu.Unlock()
+
+			b.fireSnooze(chatID, lang, reminderID, slot)
+		}),
+	}
 }
 
-func (b *Bot) handleList(msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
+// cancelSnooze отменяет отложенное напоминание, например после удаления напоминания.
+// Сработавший таймер всё равно перепроверяет напоминание (fireSnooze), но
+// отменённый не держит его в памяти и в сохраняемых при остановке snoozes.
+func (b *Bot) cancelSnooze(reminderID int) {
+	b.snoozeMu.Lock()
+	defer b.snoozeMu.Unlock()
 
-	reminders, err := b.storage.GetReminders(chatID)
-	if err != nil {
-		log.Printf("Failed to get reminders: %v", err)
-		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
-		return
+	if sn := b.snoozes[reminderID]; sn != nil {
+		sn.timer.Stop()
+		delete(b.snoozes, reminderID)
 	}
+}
 
-	if len(reminders) == 0 {
-		b.sendMessage(chatID, "У тебя пока нет напоминаний.\n\nИспользуй /add чтобы добавить")
+// fireSnooze повторно отправляет отложенное напоминание, если оно ещё актуально
+func (b *Bot) fireSnooze(chatID int64, lang string, reminderID int, slot time.Time) {
+	// Планировщик остановлен на время работ — отложенные напоминания тоже не приходят
+	if b.schedulerPaused() {
 		return
 	}
 
-	// Уже отсортированы в storage.GetReminders
-
-	var text strings.Builder
-	text.WriteString("📋 Твои напоминания (часовой пояс Екатеринбург):\n\n")
-
-	for _, r := range reminders {
-		text.WriteString(fmt.Sprintf("⏰ %s — 💊 %s — 📊 %s\n", r.TimeString(), r.Medicine, r.CourseString()))
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get snoozed reminder: %v", err)
+		return
 	}
-
-	// Кнопки удаления
-	var rows [][]tgbotapi.InlineKeyboardButton
-	for _, r := range reminders {
-		rows = append(rows, []tgbotapi.InlineKeyboardButton{
-			tgbotapi.NewInlineKeyboardButtonData(
-				fmt.Sprintf("🗑 %s %s [%s]", r.TimeString(), r.Medicine, r.CourseString()),
-				fmt.Sprintf("del_%d", r.ID),
-			),
-		})
+	if reminder == nil || !reminder.IsRunnable(b.now()) {
+		// Напоминание удалили, поставили на паузу или курс завершился, пока таймер ждал
+		return
 	}
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
-
-	reply := tgbotapi.NewMessage(chatID, text.String())
-	reply.ReplyMarkup = keyboard
-	if _, err := b.api.Send(reply); err != nil {
-		log.Printf("Failed to send message: %v", err)
+	if err := b.sendReminderWithButton(chatID, lang, *reminder, slot); err != nil {
+		log.Printf("Failed to send reminder to %d: %v", chatID, err)
 	}
 }
 
-func (b *Bot) handleDeleteReminder(chatID int64, messageID int, reminderID int) {
-	if err := b.storage.DeleteReminder(chatID, reminderID); err != nil {
-		log.Printf("Failed to delete reminder: %v", err)
+// handleTakenConfirm обрабатывает подтверждение приёма лекарства.
+// slot — время отправки напоминания (нулевое для кнопок старого формата).
+func (b *Bot) handleTakenConfirm(callbackID string, chatID int64, messageID int, messageText string, reminderID int, slot time.Time) {
+	if !slot.IsZero() && b.now().Sub(slot) > takenConfirmWindow {
+		// Напоминание устарело — убираем кнопку, счётчик не трогаем
+		b.api.Request(tgbotapi.NewCallback(callbackID, ""))
+		b.markReminderStale(chatID, messageID, messageText)
+		return
 	}
 
-	b.deleteMessage(chatID, messageID)
-	b.sendMessage(chatID, "🗑 Напоминание удалено")
-}
-
-func (b *Bot) handleStats(msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-
-	// Проверка прав администратора
-	if b.adminID != 0 && chatID != b.adminID {
-		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+	text, completionText, err := b.confirmDose(chatID, reminderID, slot)
+	if errors.Is(err, ErrCourseCompleted) {
+		// Курс уже завершён последней дозой — сообщение оставляем, убираем только кнопки
+		b.api.Request(tgbotapi.NewCallbackWithAlert(callbackID, "🏁 Курс уже завершён — напоминание больше не нужно отмечать"))
+		b.removeButtons(chatID, messageID)
 		return
 	}
+	b.api.Request(tgbotapi.NewCallback(callbackID, ""))
 
-	totalUsers, activeUsers, totalReminders, finiteCourses, infiniteCourses, totalDosesTaken, totalDosesPlanned, err := b.storage.GetStats()
-	if err != nil {
-		log.Printf("Failed to get stats: %v", err)
-		b.sendMessage(chatID, "Ошибка загрузки статистики")
+	switch {
+	case errors.Is(err, ErrDoseAlreadyTaken):
+		// Повторное нажатие на кэшированную кнопку — приём уже засчитан
+		return
+	case err != nil:
+		// Напоминание не найдено (возможно уже удалено)
+		b.deleteMessage(chatID, messageID)
 		return
 	}
 
-	text := fmt.Sprintf("📊 Статистика бота:\n\n"+
-		"👥 Всего пользователей: %d\n"+
-		"✅ Активных: %d\n\n"+
-		"💊 Всего напоминаний: %d\n"+
-		"   📅 Курсов с датой окончания: %d\n"+
-		"   ♾ Бесконечных курсов: %d\n\n"+
-		"📈 Принято доз: %d\n"+
-		"📋 Запланировано доз: %d",
-		totalUsers, activeUsers, totalReminders, finiteCourses, infiniteCourses, totalDosesTaken, totalDosesPlanned)
+	// Обновляем сообщение — убираем кнопку, показываем подтверждение
+	if !slot.IsZero() {
+		text += doseNoteHint
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	} else {
+		b.rememberConfirmMessage(chatID, reminderID, slot, messageID)
+	}
 
-	b.sendMessage(chatID, text)
+	if completionText != "" {
+		b.sendMessage(chatID, completionText)
+	}
+
+	b.offerLinkedTaken(chatID, reminderID, slot)
 }
 
-func (b *Bot) handleStop(msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
+// confirmDose засчитывает приём и возвращает текст подтверждения и,
+// если курс завершён, текст поздравления.
+// Ошибки — ErrReminderNotFound или ErrDoseAlreadyTaken.
+func (b *Bot) confirmDose(chatID int64, reminderID int, slot time.Time) (text, completionText string, err error) {
+	return b.countDose(chatID, reminderID, slot, DoseTaken)
+}
 
-	if err := b.storage.SetUserActive(chatID, false); err != nil {
-		log.Printf("Failed to deactivate user %d: %v", chatID, err)
+// countDose засчитывает дозу со статусом status — см. confirmDose
+func (b *Bot) countDose(chatID int64, reminderID int, slot time.Time, status string) (text, completionText string, err error) {
+	// Инкрементируем счётчик
+	medicineName, newCount, total, completed, summary, err := b.IncrementDoseTaken(chatID, reminderID, slot, status)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Формируем строку прогресса
+	var progressStr string
+	if total == 0 {
+		progressStr = fmt.Sprintf("%d/∞", newCount)
+	} else {
+		progressStr = fmt.Sprintf("%d/%d", newCount, total)
 	}
 
-	keyboard := b.getMainKeyboard(chatID, false)
+	text = fmt.Sprintf("✅ Принято: 💊 %s\n📊 Приём: %s", medicineName, progressStr)
+	text += b.courseEndNotice(chatID, reminderID, newCount, total)
 
-	reply := tgbotapi.NewMessage(chatID, "⏸ Напоминания отключены.\n\nТвои настройки сохранены.")
-	reply.ReplyMarkup = keyboard
-	if _, err := b.api.Send(reply); err != nil {
-		log.Printf("Failed to send message to %d: %v", chatID, err)
+	// Если курс завершён, готовим поздравление
+	if completed {
+		completionText = b.courseCompletedText(chatID, b.celebration(chatID), medicineName, summary)
+
+		// Если это был последний курс — подсказываем, как добавить новый
+		if count, err := b.storage.CountReminders(chatID); err != nil {
+			log.Printf("Failed to count reminders: %v", err)
+		} else if count == 0 {
+			completionText = strings.TrimSpace(completionText + "\n\nБольше активных напоминаний нет. Используй /add чтобы добавить новое")
+		}
 	}
-}
 
-func (b *Bot) getMainKeyboard(chatID int64, active bool) tgbotapi.ReplyKeyboardMarkup {
-	var rows [][]tgbotapi.KeyboardButton
+	return text, completionText, nil
+}
 
-	if active {
-		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("➕ Добавить"),
-			tgbotapi.NewKeyboardButton("📋 Мои напоминания"),
-		))
-		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("⏸ Отключить"),
-		))
-	} else {
-		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("▶️ Включить"),
-		))
+// courseEndNotice возвращает предупреждение о скором конце курса — один раз за курс,
+// когда осталось не больше courseEndNoticeDays приёмов. Короткие курсы
+// (не длиннее самого окна предупреждения) не предупреждаются.
+func (b *Bot) courseEndNotice(chatID int64, reminderID int, newCount, total int) string {
+	left := total - newCount
+	if b.courseEndNoticeDays == 0 || total <= b.courseEndNoticeDays || left <= 0 || left > b.courseEndNoticeDays {
+		return ""
 	}
 
-	// Кнопки админа
-	if b.adminID != 0 && chatID == b.adminID {
-		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
-			tgbotapi.NewKeyboardButton("📊 Статистика"),
-			tgbotapi.NewKeyboardButton("📣 Рассылка"),
-		))
+	fresh, err := b.storage.MarkCourseEndNoticeSent(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to mark course end notice: %v", err)
+		return ""
 	}
-
-	keyboard := tgbotapi.ReplyKeyboardMarkup{
-		Keyboard:       rows,
-		ResizeKeyboard: true,
+	if !fresh {
+		return ""
 	}
-	return keyboard
+	return fmt.Sprintf("\n\n⏳ До конца курса осталось приёмов: %d. Если нужно продолжать лечение — обсуди это с врачом заранее", left)
 }
 
-func (b *Bot) sendMessage(chatID int64, text string) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Failed to send message to %d: %v", chatID, err)
-	}
+// takenReplies — текстовые ответы, которые считаются подтверждением приёма
+var takenReplies = map[string]bool{
+	"принял":  true,
+	"приняла": true,
+	"выпил":   true,
+	"выпила":  true,
+	"готово":  true,
 }
 
-func (b *Bot) deleteMessage(chatID int64, messageID int) {
-	del := tgbotapi.NewDeleteMessage(chatID, messageID)
-	if _, err := b.api.Request(del); err != nil {
-		log.Printf("Failed to delete message: %v", err)
-	}
+// isTakenReply проверяет, что сообщение — текстовое подтверждение приёма ("Принял!")
+func isTakenReply(text string) bool {
+	text = strings.ToLower(strings.TrimRight(strings.TrimSpace(text), "!.✅👍 "))
+	return takenReplies[text]
 }
 
-// sendReminderWithButton отправляет напоминание с кнопкой "Принял"
-func (b *Bot) sendReminderWithButton(chatID int64, text string, reminderID int) {
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Принял", fmt.Sprintf("taken_%d", reminderID)),
-		),
-	)
+// handleTakenReply подтверждает последнюю неподтверждённую дозу по тексту "принял".
+// Если таких доз несколько — спрашивает, какую именно.
+func (b *Bot) handleTakenReply(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
 
-	msg := tgbotapi.NewMessage(chatID, text)
-	msg.ReplyMarkup = keyboard
-	if _, err := b.api.Send(msg); err != nil {
-		log.Printf("Failed to send reminder to %d: %v", chatID, err)
+	doses, err := b.storage.GetPendingDoses(chatID, b.now().Add(-takenConfirmWindow))
+	if err != nil {
+		log.Printf("Failed to get pending doses: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
 	}
-}
 
-// handleTakenConfirm обрабатывает подтверждение приёма лекарства
-func (b *Bot) handleTakenConfirm(chatID int64, messageID int, reminderID int) {
-	// Инкрементируем счётчик
-	medicineName, newCount, total, completed := b.IncrementDoseTaken(chatID, reminderID)
-
-	if medicineName == "" {
-		// Напоминание не найдено (возможно уже удалено)
-		b.deleteMessage(chatID, messageID)
+	switch len(doses) {
+	case 0:
+		b.sendMessage(chatID, "Нет неподтверждённых напоминаний 👌")
+		return
+	case 1:
+		text, completionText, err := b.confirmDose(chatID, doses[0].ReminderID, doses[0].ScheduledAt)
+		switch {
+		case errors.Is(err, ErrDoseAlreadyTaken):
+			b.sendMessage(chatID, "Этот приём уже отмечен 👌")
+			return
+		case errors.Is(err, ErrCourseCompleted):
+			b.sendMessage(chatID, "🏁 Курс уже завершён — этот приём отмечать не нужно")
+			return
+		case err != nil:
+			b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+			return
+		}
+		sent, err := b.api.Send(tgbotapi.NewMessage(chatID, text+doseNoteHint))
+		if err != nil {
+			log.Printf("Failed to send message to %d: %v", chatID, err)
+		} else {
+			b.rememberConfirmMessage(chatID, doses[0].ReminderID, doses[0].ScheduledAt, sent.MessageID)
+		}
+		if completionText != "" {
+			b.sendMessage(chatID, completionText)
+		}
+		b.offerLinkedTaken(chatID, doses[0].ReminderID, doses[0].ScheduledAt)
 		return
 	}
 
-	// Формируем строку прогресса
-	var progressStr string
-	if total == 0 {
-		progressStr = fmt.Sprintf("%d/∞", newCount)
-	} else {
-		progressStr = fmt.Sprintf("%d/%d", newCount, total)
+	// Несколько неподтверждённых доз — уточняем кнопками
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, d := range doses {
+		rows = append(rows, appendDataButton(nil,
+			fmt.Sprintf("✅ %s %s", formatClock(b.userLocale(chatID, defaultLocale), d.ScheduledAt), buttonName(d.Medicine)),
+			fmt.Sprintf("taken_%d_%d", d.ReminderID, d.ScheduledAt.Unix()),
+		))
 	}
 
-	// Обновляем сообщение — убираем кнопку, показываем подтверждение
-	text := fmt.Sprintf("✅ Принято: 💊 %s\n📊 Приём: %s", medicineName, progressStr)
-	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
-	if _, err := b.api.Send(edit); err != nil {
-		log.Printf("Failed to edit message: %v", err)
+	reply := tgbotapi.NewMessage(chatID, "Какое лекарство ты принял?")
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
 	}
+}
 
-	// Если курс завершён, отправляем поздравление
-	if completed {
-		b.sendMessage(chatID, fmt.Sprintf("🎉 Курс \"%s\" завершён! Ты молодец!", medicineName))
+// courseSummaryText форматирует итоги курса для поздравления
+func (b *Bot) courseSummaryText(l Locale, sum *CourseSummary) string {
+	if sum == nil {
+		return ""
+	}
+
+	days := courseDay(sum.StartedAt, b.now())
+
+	text := fmt.Sprintf("\n\n📋 Итоги курса:\n💊 Принято доз: %d\n📅 Длительность: %d дн. (с %s)",
+		sum.DosesTaken, days, formatDate(l, sum.StartedAt))
+
+	// Процент соблюдения считаем только по журналу напоминаний
+	if sum.Scheduled > 0 {
+		percent := sum.Taken * 100 / sum.Scheduled
+		if percent > 100 {
+			percent = 100
+		}
+		text += fmt.Sprintf("\n📈 Соблюдение режима: %d%%", percent)
 	}
+	return text
 }
 
 // ReminderJSON структура для JSON ответа
@@ -786,7 +3617,9 @@ type ReminderJSON struct {
 	Medicine   string `json:"medicine"`
 	Time       string `json:"time"`
 	CourseDays int    `json:"course_days"`
+	CourseType string `json:"course_type"`
 	DosesTaken int    `json:"doses_taken"`
+	Paused     bool   `json:"paused"`
 }
 
 // GetUserReminders возвращает напоминания пользователя для API
@@ -804,80 +3637,99 @@ func (b *Bot) GetUserReminders(chatID int64) []ReminderJSON {
 			Medicine:   r.Medicine,
 			Time:       r.TimeString(),
 			CourseDays: r.CourseDays,
+			CourseType: r.CourseType,
 			DosesTaken: r.DosesTaken,
+			Paused:     r.Paused,
 		}
 	}
 	return result
 }
 
-// parseUserFromInitData извлекает user_id из Telegram initData
-func (b *Bot) parseUserFromInitData(initData string) int64 {
-	// Упрощённый парсинг - в продакшене нужна полная валидация HMAC!
-	// initData формат: query_id=...&user={"id":123,...}&auth_date=...&hash=...
-
-	// Декодируем URL-encoded строку
-	decoded, err := url.QueryUnescape(initData)
+// GetRemindersForTime возвращает список напоминаний для указанного локального времени
+func (b *Bot) GetRemindersForTime(now time.Time) map[int64][]Reminder {
+	result, err := b.storage.GetRemindersForTime(now)
 	if err != nil {
-		return 0
+		log.Printf("Failed to get reminders for time: %v", err)
+		return make(map[int64][]Reminder)
 	}
+	return result
+}
 
-	// Ищем user= параметр
-	params, err := url.ParseQuery(decoded)
-	if err != nil {
-		return 0
+// IncrementDoseTaken засчитывает приём со статусом status и переносит завершённые курсы
+// в историю. Для завершённого курса возвращает его итоги.
+// Возвращает ErrReminderNotFound или ErrDoseAlreadyTaken, если засчитывать нечего.
+func (b *Bot) IncrementDoseTaken(chatID int64, reminderID int, slot time.Time, status string) (medicineName string, newCount int, total int, completed bool, summary *CourseSummary, err error) {
+	if slot.IsZero() {
+		// Кнопка старого формата без времени слота
+		slot = b.now()
 	}
-
-	userJSON := params.Get("user")
-	if userJSON == "" {
-		return 0
+	medicineName, newCount, total, completed, err = b.storage.IncrementDoseTaken(chatID, reminderID, slot, status)
+	if err != nil {
+		if !errors.Is(err, ErrReminderNotFound) && !errors.Is(err, ErrDoseAlreadyTaken) && !errors.Is(err, ErrCourseCompleted) {
+			log.Printf("Failed to increment dose: %v", err)
+			err = ErrReminderNotFound
+		}
+		return "", 0, 0, false, nil, err
 	}
 
-	var userData struct {
-		ID int64 `json:"id"`
-	}
-	if err := json.Unmarshal([]byte(userJSON), &userData); err != nil {
-		return 0
+	if completed {
+		if summary, err = b.storage.GetCourseSummary(chatID, reminderID); err != nil {
+			log.Printf("Failed to get course summary: %v", err)
+		}
+		if err := b.storage.CompleteReminder(chatID, reminderID); err != nil {
+			log.Printf("Failed to complete reminder: %v", err)
+		}
 	}
-
-	return userData.ID
+	return medicineName, newCount, total, completed, summary, nil
 }
 
-// GetRemindersForTime возвращает список напоминаний для указанного времени
-func (b *Bot) GetRemindersForTime(hour, minute int) map[int64][]Reminder {
-	result, err := b.storage.GetRemindersForTime(hour, minute)
+// completeFinishedDayCourses завершает курсы по дням, закончившиеся раньше before:
+// их последняя доза не была подтверждена, поэтому итоги приходят отдельным сообщением
+func (b *Bot) completeFinishedDayCourses(before time.Time) {
+	finished, err := b.storage.GetFinishedDayCourses(before)
 	if err != nil {
-		log.Printf("Failed to get reminders for time: %v", err)
-		return make(map[int64][]Reminder)
+		log.Printf("Failed to get finished day courses: %v", err)
+		return
 	}
-	return result
-}
 
-// IncrementDoseTaken увеличивает счётчик принятых доз и удаляет завершённые курсы
-func (b *Bot) IncrementDoseTaken(chatID int64, reminderID int) (medicineName string, newCount int, total int, completed bool) {
-	medicineName, newCount, total, completed, err := b.storage.IncrementDoseTaken(chatID, reminderID)
-	if err != nil {
-		log.Printf("Failed to increment dose: %v", err)
-		return "", 0, 0, false
+	for chatID, reminders := range finished {
+		for _, r := range reminders {
+			summary, err := b.storage.GetCourseSummary(chatID, r.ID)
+			if err != nil {
+				log.Printf("Failed to get course summary: %v", err)
+			}
+			if err := b.storage.CompleteReminder(chatID, r.ID); err != nil {
+				log.Printf("Failed to complete reminder: %v", err)
+				continue
+			}
+			if text := b.courseCompletedText(chatID, b.celebration(chatID), r.Medicine, summary); text != "" {
+				b.sendMessage(chatID, text)
+			}
+		}
 	}
-	return medicineName, newCount, total, completed
 }
 
+// donateAmounts — суммы доната на кнопках /donate в порядке показа
+var donateAmounts = []int{1, 5, 10, 50, 100}
+
+// maxDonateStars — наибольшая сумма одного инвойса в Telegram Stars
+const maxDonateStars = 10000
+
 // handleDonate отправляет меню выбора суммы доната
 func (b *Bot) handleDonate(message *tgbotapi.Message) {
 	chatID := message.Chat.ID
 
-	// Показываем выбор суммы доната
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("⭐ 1", "stars_1"),
-			tgbotapi.NewInlineKeyboardButtonData("⭐ 5", "stars_5"),
-			tgbotapi.NewInlineKeyboardButtonData("⭐ 10", "stars_10"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("⭐ 50", "stars_50"),
-			tgbotapi.NewInlineKeyboardButtonData("⭐ 100", "stars_100"),
-		),
-	)
+	// Показываем выбор суммы доната: по три кнопки в ряд и своя сумма
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, amount := range donateAmounts {
+		if i%3 == 0 {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1],
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⭐ %d", amount), fmt.Sprintf("stars_%d", amount)))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✏️ Своя сумма", "stars_custom")))
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
 
 	msg := tgbotapi.NewMessage(chatID, "Выбери сумму доната:\n\nТвоя поддержка помогает развивать бота! 💊")
 	msg.ReplyMarkup = keyboard
@@ -886,6 +3738,40 @@ func (b *Bot) handleDonate(message *tgbotapi.Message) {
 	}
 }
 
+// askDonateAmount просит ввести свою сумму доната вместо кнопок с суммами
+func (b *Bot) askDonateAmount(chatID int64, messageID int) {
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{State: StateWaitingDonateAmount, MsgID: messageID, StartedAt: b.now()}
+	b.mu.Unlock()
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID,
+		fmt.Sprintf("Введи сумму доната в звёздах — целое число от 1 до %d:", maxDonateStars))
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleDonateAmountInput принимает свою сумму доната и отправляет инвойс
+func (b *Bot) handleDonateAmountInput(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	amount, err := strconv.Atoi(strings.TrimSpace(msg.Text))
+	if err != nil || amount < 1 || amount > maxDonateStars {
+		b.sendMessage(chatID, fmt.Sprintf("Пожалуйста, введи целое число от 1 до %d:", maxDonateStars))
+		return
+	}
+
+	// Убираем вопрос с кнопкой "Отмена": отменять уже нечего
+	if messageID := b.clearPending(chatID); messageID != 0 {
+		b.deleteMessage(chatID, messageID)
+	}
+	b.sendStarsInvoice(chatID, amount)
+}
+
 // sendStarsInvoice отправляет инвойс для Telegram Stars
 func (b *Bot) sendStarsInvoice(chatID int64, amount int) {
 	invoice := tgbotapi.InvoiceConfig{
@@ -901,16 +3787,73 @@ func (b *Bot) sendStarsInvoice(chatID int64, amount int) {
 		SuggestedTipAmounts: []int{}, // Явно пустой массив
 	}
 
-	if _, err := b.api.Send(invoice); err != nil {
-		log.Printf("Failed to send invoice: %v", err)
-		b.sendMessage(chatID, "Не удалось создать платёж. Попробуй позже.")
+	// Сетевые и серверные ошибки повторяем один раз, остальные объясняем сразу
+	for attempt := 1; ; attempt++ {
+		_, err := b.api.Send(invoice)
+		if err == nil {
+			return
+		}
+
+		text, retry, wait := invoiceFailure(err)
+		if retry && attempt == 1 {
+			log.Printf("Failed to send invoice to %d: %v; retrying in %s", chatID, err, wait)
+			time.Sleep(wait)
+			continue
+		}
+
+		log.Printf("Failed to send invoice to %d: %v", chatID, err)
+		if text != "" {
+			b.sendMessage(chatID, text)
+		}
+		return
+	}
+}
+
+// Повтор инвойса: пауза после сетевой или серверной ошибки и наибольшее
+// ожидание при flood control — дольше пользователь ждать ответа не станет
+const (
+	invoiceRetryDelay    = time.Second
+	invoiceMaxRetryAfter = 5 * time.Second
+)
+
+// invoiceFailure разбирает ошибку отправки инвойса: что ответить пользователю
+// ("" — ответить нельзя, бот заблокирован), стоит ли повторить и через сколько.
+// Неизвестные ошибки получают общий ответ.
+func invoiceFailure(err error) (text string, retry bool, wait time.Duration) {
+	const (
+		unavailableText = "Telegram сейчас не отвечает. Попробуй ещё раз через пару минут: /donate"
+		genericText     = "Не удалось создать платёж. Попробуй позже."
+	)
+
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		// Сеть или таймаут — ответа от Telegram не было
+		return unavailableText, true, invoiceRetryDelay
+	}
+
+	message := strings.ToUpper(apiErr.Message)
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		wait = time.Duration(apiErr.RetryAfter) * time.Second
+		return "Слишком много запросов к Telegram. Попробуй через минуту: /donate", wait <= invoiceMaxRetryAfter, wait
+	case apiErr.Code >= http.StatusInternalServerError:
+		return unavailableText, true, invoiceRetryDelay
+	case apiErr.Code == http.StatusForbidden:
+		return "", false, 0
+	case strings.Contains(message, "CURRENCY_TOTAL_AMOUNT_INVALID"):
+		return fmt.Sprintf("Telegram не принял эту сумму. Выбери другую — от 1 до %d ⭐: /donate", maxDonateStars), false, 0
+	case strings.Contains(message, "STARS"), strings.Contains(message, "CURRENCY"), strings.Contains(message, "PAYMENT_PROVIDER"):
+		// Звёзды недоступны: регион, старая версия приложения или ограничения аккаунта
+		return "Оплата звёздами сейчас недоступна — возможно, в твоём регионе или версии Telegram. " +
+			"Обнови приложение или попробуй позже.", false, 0
 	}
+	return genericText, false, 0
 }
 
 // handlePreCheckout подтверждает pre-checkout запрос
 func (b *Bot) handlePreCheckout(query *tgbotapi.PreCheckoutQuery) {
 	log.Printf("[PRECHECKOUT] user=%s amount=%d %s",
-		query.From.UserName, query.TotalAmount, query.Currency)
+		userLabel(query.From.UserName, query.From.FirstName, query.From.ID), query.TotalAmount, query.Currency)
 
 	// Подтверждаем платёж
 	callback := tgbotapi.PreCheckoutConfig{
@@ -923,11 +3866,35 @@ func (b *Bot) handlePreCheckout(query *tgbotapi.PreCheckoutQuery) {
 	}
 }
 
+// rejectPreCheckout отклоняет платёж до списания звёзд с объяснением для пользователя
+func (b *Bot) rejectPreCheckout(query *tgbotapi.PreCheckoutQuery, reason string) {
+	callback := tgbotapi.PreCheckoutConfig{
+		PreCheckoutQueryID: query.ID,
+		OK:                 false,
+		ErrorMessage:       reason,
+	}
+
+	if _, err := b.api.Request(callback); err != nil {
+		log.Printf("Failed to reject pre-checkout: %v", err)
+	}
+}
+
 // handleSuccessfulPayment обрабатывает успешный платёж
 func (b *Bot) handleSuccessfulPayment(msg *tgbotapi.Message) {
 	payment := msg.SuccessfulPayment
-	log.Printf("[PAYMENT] user=%d amount=%d %s",
-		msg.Chat.ID, payment.TotalAmount, payment.Currency)
+	log.Printf("[PAYMENT] user=%d amount=%d %s charge=%s",
+		msg.Chat.ID, payment.TotalAmount, payment.Currency, payment.TelegramPaymentChargeID)
+
+	// Повторная доставка того же платежа — уже поблагодарили и уведомили админа.
+	// Если запись не удалась, благодарим всё равно: платёж прошёл.
+	fresh, err := b.storage.RecordDonation(msg.Chat.ID, payment.TotalAmount, payment.Currency,
+		payment.InvoicePayload, payment.TelegramPaymentChargeID)
+	if err != nil {
+		log.Printf("Failed to record donation: %v", err)
+	} else if !fresh {
+		log.Printf("[PAYMENT] duplicate charge %s from %d ignored", payment.TelegramPaymentChargeID, msg.Chat.ID)
+		return
+	}
 
 	text := fmt.Sprintf("🎉 Спасибо за поддержку!\n\n"+
 		"Получено: %d ⭐\n\n"+
@@ -938,12 +3905,62 @@ func (b *Bot) handleSuccessfulPayment(msg *tgbotapi.Message) {
 
 	// Уведомляем админа о донате
 	if b.adminID != 0 && msg.Chat.ID != b.adminID {
-		adminText := fmt.Sprintf("💰 Новый донат!\n\nОт: @%s (ID: %d)\nСумма: %d ⭐",
-			msg.From.UserName, msg.Chat.ID, payment.TotalAmount)
+		adminText := fmt.Sprintf("💰 Новый донат!\n\nОт: %s (ID: %d)\nСумма: %d ⭐",
+			userLabel(msg.From.UserName, msg.From.FirstName, msg.Chat.ID), msg.Chat.ID, payment.TotalAmount)
 		b.sendMessage(b.adminID, adminText)
 	}
 }
 
+// handleRefund возвращает донат в Stars по telegram_payment_charge_id (только для админа)
+func (b *Bot) handleRefund(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	chargeID := strings.TrimSpace(msg.CommandArguments())
+	if chargeID == "" {
+		b.sendMessage(chatID, "Укажи ID платежа: /refund <charge_id>\n\nID есть в логах [PAYMENT]")
+		return
+	}
+
+	donation, err := b.storage.GetDonation(chargeID)
+	if err != nil {
+		log.Printf("Failed to get donation %s: %v", chargeID, err)
+		b.sendMessage(chatID, "Ошибка загрузки платежа")
+		return
+	}
+	if donation == nil {
+		b.sendMessage(chatID, "Платёж с таким ID не найден")
+		return
+	}
+	if donation.RefundedAt != nil {
+		b.sendMessage(chatID, fmt.Sprintf("Платёж уже возвращён %s", donation.RefundedAt.In(b.loc).Format("02.01.2006 15:04")))
+		return
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("user_id", donation.ChatID)
+	params.AddNonEmpty("telegram_payment_charge_id", chargeID)
+	if _, err := b.api.MakeRequest("refundStarPayment", params); err != nil {
+		log.Printf("Failed to refund payment %s: %v", chargeID, err)
+		b.sendMessage(chatID, fmt.Sprintf("❌ Не удалось вернуть платёж: %v", err))
+		return
+	}
+
+	if err := b.storage.MarkDonationRefunded(chargeID); err != nil {
+		// Деньги уже вернулись — сообщаем об успехе, но запись осталась старой
+		log.Printf("Failed to mark donation %s refunded: %v", chargeID, err)
+	}
+	log.Printf("[PAYMENT] refunded charge=%s user=%d amount=%d", chargeID, donation.ChatID, donation.Amount)
+	b.audit(chatID, auditRefund, fmt.Sprintf("charge=%s user=%d amount=%d", chargeID, donation.ChatID, donation.Amount))
+
+	b.sendMessage(chatID, fmt.Sprintf("✅ Возвращено %d ⭐ пользователю %d", donation.Amount, donation.ChatID))
+	b.sendMessage(donation.ChatID, fmt.Sprintf("💫 Тебе возвращено %d ⭐ за донат", donation.Amount))
+}
+
 // handleNotify отправляет уведомление всем пользователям (только для админа)
 func (b *Bot) handleNotify(msg *tgbotapi.Message) {
 	chatID := msg.Chat.ID
@@ -954,27 +3971,144 @@ func (b *Bot) handleNotify(msg *tgbotapi.Message) {
 		return
 	}
 
-	// Получаем текст после команды
-	text := strings.TrimSpace(strings.TrimPrefix(msg.Text, "/notify"))
+	// Получаем фильтры и текст после команды
+	filter, text, err := parseNotifyArgs(strings.TrimSpace(strings.TrimPrefix(msg.Text, "/notify")), b.now())
+	if err != nil {
+		b.sendMessage(chatID, err.Error())
+		return
+	}
 	if text == "" {
 		text = "Важное уведомление от бота!"
 	}
 
-	chatIDs, err := b.storage.GetAllUsers()
+	users, err := b.storage.GetUsersFiltered(filter)
 	if err != nil {
 		log.Printf("Failed to get users for notify: %v", err)
 		b.sendMessage(chatID, "Ошибка получения списка пользователей")
 		return
 	}
 
-	sentCount := 0
+	if b.dryRun {
+		for _, u := range users {
+			log.Printf("[DRY RUN] Would send broadcast to %d: %q", u.ChatID, text)
+		}
+		b.sendMessage(chatID, fmt.Sprintf("🧪 DRY RUN: уведомление было бы отправлено %d пользователям", len(users)))
+		return
+	}
+
+	broadcastID, err := b.storage.CreateBroadcast(text)
+	if err != nil {
+		log.Printf("Failed to create broadcast: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения рассылки")
+		return
+	}
+
+	chatIDs := make([]int64, len(users))
+	for i, u := range users {
+		chatIDs[i] = u.ChatID
+	}
+	sentCount, blocked := b.deliverBroadcast(broadcastID, text, chatIDs)
+	b.audit(chatID, auditNotify, fmt.Sprintf("broadcast=%d sent=%d/%d blocked=%d text=%q", broadcastID, sentCount, len(users), blocked, text))
+
+	reply := fmt.Sprintf("Уведомление отправлено %d из %d пользователей", sentCount, len(users))
+	if blocked > 0 {
+		reply += fmt.Sprintf("\nЗаблокировали бота: %d — напоминания им отключены", blocked)
+	}
+	if sentCount+blocked < len(users) {
+		reply += "\n\nПовторить для тех, кому не доставлено: /resend"
+	}
+	b.sendMessage(chatID, reply)
+}
+
+// deliverBroadcast отправляет рассылку пользователям, записывая результат
+// доставки каждому, и возвращает число успешных отправок и число пользователей,
+// заблокировавших бота (им отключены напоминания — deactivateIfBlocked)
+func (b *Bot) deliverBroadcast(broadcastID int, text string, chatIDs []int64) (sent, blocked int) {
 	for _, id := range chatIDs {
-		if err := b.sendMessageWithError(id, text); err == nil {
-			sentCount++
+		b.sendLimiter.Wait()
+		err := b.sendMessageWithError(id, text)
+		switch {
+		case err == nil:
+			sent++
+		case isBlocked(err):
+			blocked++
 		}
+		if err := b.storage.RecordDelivery(broadcastID, id, err); err != nil {
+			log.Printf("Failed to record delivery: %v", err)
+		}
+	}
+	return sent, blocked
+}
+
+// handleResend повторяет последнюю рассылку пользователям, которым она не была доставлена
+func (b *Bot) handleResend(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "Эта команда доступна только администратору")
+		return
+	}
+
+	broadcastID, text, failed, err := b.storage.GetLastBroadcastFailures()
+	if err != nil {
+		log.Printf("Failed to get broadcast failures: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки результатов рассылки")
+		return
+	}
+	if broadcastID == 0 {
+		b.sendMessage(chatID, "Рассылок ещё не было")
+		return
+	}
+	if len(failed) == 0 {
+		b.sendMessage(chatID, "Последняя рассылка доставлена всем 👌")
+		return
+	}
+
+	sentCount, blocked := b.deliverBroadcast(broadcastID, text, failed)
+	b.audit(chatID, auditResend, fmt.Sprintf("broadcast=%d sent=%d/%d blocked=%d", broadcastID, sentCount, len(failed), blocked))
+
+	reply := fmt.Sprintf("Повторная отправка: доставлено %d из %d", sentCount, len(failed))
+	if blocked > 0 {
+		reply += fmt.Sprintf("\nЗаблокировали бота: %d — напоминания им отключены", blocked)
+	}
+	if remaining := len(failed) - sentCount - blocked; remaining > 0 {
+		reply += fmt.Sprintf("\nНе доставлено: %d — можно повторить /resend позже", remaining)
+	}
+	b.sendMessage(chatID, reply)
+}
+
+// parseNotifyArgs отделяет фильтры рассылки в начале текста от самого сообщения:
+// +active — только активные, +reminders — только с напоминаниями,
+// +new=N — зарегистрированные за последние N дней.
+func parseNotifyArgs(args string, now time.Time) (UserFilter, string, error) {
+	var filter UserFilter
+
+	for {
+		word, rest, _ := strings.Cut(args, " ")
+		if !strings.HasPrefix(word, "+") {
+			break
+		}
+
+		switch {
+		case word == "+active":
+			filter.ActiveOnly = true
+		case word == "+reminders":
+			filter.HasReminders = true
+		case strings.HasPrefix(word, "+new="):
+			days, err := strconv.Atoi(strings.TrimPrefix(word, "+new="))
+			if err != nil || days <= 0 {
+				return filter, "", fmt.Errorf("Неверный фильтр %s — укажи число дней, например +new=7", word)
+			}
+			since := now.AddDate(0, 0, -days)
+			filter.CreatedAfter = &since
+		default:
+			return filter, "", fmt.Errorf("Неизвестный фильтр %s. Доступны: +active, +reminders, +new=N", word)
+		}
+
+		args = strings.TrimSpace(rest)
 	}
 
-	b.sendMessage(chatID, fmt.Sprintf("Уведомление отправлено %d из %d пользователей", sentCount, len(chatIDs)))
+	return filter, args, nil
 }
 
 // handleNotifyPrompt показывает подсказку для рассылки
@@ -986,14 +4120,14 @@ func (b *Bot) handleNotifyPrompt(msg *tgbotapi.Message) {
 		return
 	}
 
-	b.sendMessage(chatID, "📣 Рассылка сообщений\n\nОтправь команду:\n/notify Текст сообщения\n\nПример:\n/notify Обновление бота! Добавлены новые функции.")
+	b.sendMessage(chatID, "📣 Рассылка сообщений\n\nОтправь команду:\n/notify Текст сообщения\n\nФильтры перед текстом:\n+active — только активные\n+reminders — только с напоминаниями\n+new=N — пришедшие за N дней\n\nПример:\n/notify +active +new=7 Обновление бота! Добавлены новые функции.\n\nПовторить последнюю рассылку для тех, кому она не дошла: /resend")
 }
 
 // sendMessageWithError отправляет сообщение и возвращает ошибку
 func (b *Bot) sendMessageWithError(chatID int64, text string) error {
 	msg := tgbotapi.NewMessage(chatID, text)
 	_, err := b.api.Send(msg)
-	if err != nil {
+	if err != nil && !b.deactivateIfBlocked(chatID, err) {
 		log.Printf("Failed to send message to %d: %v", chatID, err)
 	}
 	return err
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fakeClock — часы с ручным управлением
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// fakeCall — вызов метода Bot API
+type fakeCall struct {
+	Method    string
+	Text      string
+	MessageID int // message_id запроса: какое сообщение правят или удаляют
+	ResultID  int // message_id ответа: ID отправленного сообщения
+}
+
+// fakeTelegram — Bot API, который на любой метод отвечает успехом и запоминает вызовы.
+// failures — ответы с ошибкой на ближайшие вызовы метода, по очереди.
+type fakeTelegram struct {
+	mu       sync.Mutex
+	calls    []fakeCall
+	nextID   int
+	failures map[string][]string
+}
+
+// failNext отвечает на следующий вызов method ошибкой Bot API
+func (f *fakeTelegram) failNext(method string, code int, description string, retryAfter int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures == nil {
+		f.failures = make(map[string][]string)
+	}
+	f.failures[method] = append(f.failures[method], fmt.Sprintf(
+		`{"ok":false,"error_code":%d,"description":%q,"parameters":{"retry_after":%d}}`, code, description, retryAfter))
+}
+
+// count возвращает число вызовов method
+func (f *fakeTelegram) count(method string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, c := range f.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeTelegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	f.mu.Lock()
+	f.nextID++
+	id := f.nextID
+	messageID, _ := strconv.Atoi(r.FormValue("message_id"))
+	f.calls = append(f.calls, fakeCall{Method: method, Text: r.FormValue("text"), MessageID: messageID, ResultID: id})
+	var failure string
+	if queue := f.failures[method]; len(queue) > 0 {
+		failure, f.failures[method] = queue[0], queue[1:]
+	}
+	f.mu.Unlock()
+
+	if failure != "" {
+		fmt.Fprint(w, failure)
+		return
+	}
+
+	// Сообщение подходит как результат любого метода: лишние поля игнорируются
+	fmt.Fprintf(w, `{"ok":true,"result":{"id":1,"is_bot":true,"username":"test_bot","message_id":%d,"date":0,"chat":{"id":1}}}`, id)
+}
+
+// sent возвращает тексты сообщений, отправленных методом sendMessage
+func (f *fakeTelegram) sent() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var texts []string
+	for _, c := range f.calls {
+		if c.Method == "sendMessage" {
+			texts = append(texts, c.Text)
+		}
+	}
+	return texts
+}
+
+// newTestBot создаёт бота с поддельным Bot API, замороженными часами и базой,
+// к которой нельзя подключиться: запросы к хранилищу сразу возвращают ошибку,
+// и обработчики идут по своим путям "не удалось загрузить".
+func newTestBot(t *testing.T) (*Bot, *fakeTelegram, *fakeClock) {
+	t.Helper()
+
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	tg := &fakeTelegram{}
+	server := httptest.NewServer(tg)
+	t.Cleanup(server.Close)
+
+	api, err := tgbotapi.NewBotAPIWithClient("test", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatalf("NewBotAPIWithClient: %v", err)
+	}
+
+	pool, err := pgxpool.New(context.Background(), "postgres://test@127.0.0.1:1/test?connect_timeout=1")
+	if err != nil {
+		t.Fatalf("pgxpool.New: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	clock := &fakeClock{t: time.Date(2026, 3, 2, 8, 0, 0, 0, loc)}
+
+	b := &Bot{
+		api:          api,
+		storage:      &Storage{pool: pool, maxCourseDays: defaultMaxCourseDays},
+		pending:      make(map[int64]*PendingReminder),
+		savedPending: make(map[int64]PendingReminder),
+		loc:          loc,
+		clock:        clock,
+
+		hourRanges:    parseHourRanges(""),
+		snoozeMinutes: defaultSnoozeMinutes,
+		snoozes:       make(map[int]*snooze),
+	}
+	return b, tg, clock
+}
+
+// textUpdate — сообщение пользователя chatID; текст с "/" — команда
+func textUpdate(chatID int64, text string) incomingUpdate {
+	msg := &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: chatID, FirstName: "Тест"},
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Text:      text,
+	}
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}}
+	}
+	return incomingUpdate{Update: tgbotapi.Update{Message: msg}}
+}
+
+// callbackUpdate — нажатие inline-кнопки с данными data в чате chatID
+func callbackUpdate(chatID int64, messageID int, data string) incomingUpdate {
+	return incomingUpdate{Update: tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    &tgbotapi.User{ID: chatID},
+		Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: chatID}},
+		Data:    data,
+	}}}
+}
+
+// TestAddDebounce проверяет, что повтор /add или "➕ Добавить" сразу после
+// начала диалога не отправляет второе приглашение и не становится названием
+// лекарства, а по истечении addCooldown начинает диалог заново.
+func TestAddDebounce(t *testing.T) {
+	b, tg, clock := newTestBot(t)
+	const chatID = 1
+	const prompt = "Введи название лекарства:"
+
+	b.handleUpdate(textUpdate(chatID, "/add"))
+	clock.Advance(time.Second)
+	b.handleUpdate(textUpdate(chatID, "/add"))
+	clock.Advance(time.Second)
+	b.handleUpdate(textUpdate(chatID, addButtonText))
+
+	if got := tg.sent(); len(got) != 1 || got[0] != prompt {
+		t.Fatalf("sent after repeated /add = %q, want one prompt", got)
+	}
+	p, ok := b.pendingSnapshot(chatID)
+	if !ok || p.State != StateWaitingMedicine || p.Medicine != "" {
+		t.Fatalf("pending after repeated /add = %+v, want waiting for medicine", p)
+	}
+
+	// После addCooldown кнопка начинает диалог заново
+	clock.Advance(addCooldown)
+	b.handleUpdate(textUpdate(chatID, addButtonText))
+	if got := tg.sent(); len(got) != 2 || got[1] != prompt {
+		t.Fatalf("sent after cooldown = %q, want a second prompt", got)
+	}
+
+	// Название по-прежнему принимается
+	b.handleUpdate(textUpdate(chatID, "Аспирин"))
+	if p, _ := b.pendingSnapshot(chatID); p.Medicine != "Аспирин" || p.State != StateWaitingDoseAmount {
+		t.Fatalf("pending after medicine = %+v, want Аспирин waiting for dose amount", p)
+	}
+
+	// На шаге дозировки кнопка тоже не становится ответом, а начинает диалог заново
+	b.handleUpdate(textUpdate(chatID, addButtonText))
+	if p, _ := b.pendingSnapshot(chatID); p.State != StateWaitingMedicine || p.Medicine != "" || p.DoseAmount != "" {
+		t.Fatalf("pending after %q at dose step = %+v, want a new dialog", addButtonText, p)
+	}
+	if got := tg.sent(); got[len(got)-1] != prompt {
+		t.Fatalf("sent after %q at dose step = %q, want a new prompt", addButtonText, got)
+	}
+}
+
+// TestConcurrentDialogCallbacks гоняет выбор часа, минут и отмену одного
+// диалога из нескольких горутин — как при частых нажатиях, когда обновления
+// обрабатываются параллельно. Запускать с -race: состояние диалога меняется
+// только под mu, а читается копией.
+func TestConcurrentDialogCallbacks(t *testing.T) {
+	b, _, _ := newTestBot(t)
+	const chatID = 1
+
+	start := func() {
+		b.mu.Lock()
+		b.pending[chatID] = &PendingReminder{State: StateWaitingHour, Medicine: "Аспирин", StartedAt: b.now()}
+		b.mu.Unlock()
+	}
+	start()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 20 {
+				switch (i + j) % 4 {
+				case 0:
+					b.handleUpdate(callbackUpdate(chatID, 10, fmt.Sprintf("hour_%d", 6+j%12)))
+				case 1:
+					b.handleUpdate(callbackUpdate(chatID, 10, fmt.Sprintf("time_%d:%d", 6+j%12, 15*(j%4))))
+				case 2:
+					b.handleUpdate(callbackUpdate(chatID, 10, "cancel"))
+				case 3:
+					start()
+				}
+				b.persistPending(chatID)
+				if p, ok := b.pendingSnapshot(chatID); ok && p.Medicine != "Аспирин" {
+					t.Errorf("pending medicine = %q, want Аспирин", p.Medicine)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p, ok := b.pendingSnapshot(chatID); ok && (p.Hour < 0 || p.Hour > 23 || p.Minute < 0 || p.Minute > 59) {
+		t.Errorf("pending after concurrent callbacks = %+v, want a valid time", p)
+	}
+}
+
+func TestInvoiceFailure(t *testing.T) {
+	apiErr := func(code int, message string, retryAfter int) error {
+		return &tgbotapi.Error{Code: code, Message: message, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: retryAfter}}
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		wantText  string // подстрока ответа; "" — не отвечать
+		wantRetry bool
+		wantWait  time.Duration
+	}{
+		{"сеть", &url.Error{Op: "Post", URL: "https://api.telegram.org", Err: errors.New("connection reset")}, "не отвечает", true, invoiceRetryDelay},
+		{"таймаут", errors.New("context deadline exceeded"), "не отвечает", true, invoiceRetryDelay},
+		{"500", apiErr(500, "Internal Server Error", 0), "не отвечает", true, invoiceRetryDelay},
+		{"502", apiErr(502, "Bad Gateway", 0), "не отвечает", true, invoiceRetryDelay},
+		{"429 коротко", apiErr(429, "Too Many Requests: retry after 3", 3), "Слишком много запросов", true, 3 * time.Second},
+		{"429 на границе", apiErr(429, "Too Many Requests: retry after 5", 5), "Слишком много запросов", true, invoiceMaxRetryAfter},
+		{"429 долго", apiErr(429, "Too Many Requests: retry after 60", 60), "Слишком много запросов", false, time.Minute},
+		{"403", apiErr(403, "Forbidden: bot was blocked by the user", 0), "", false, 0},
+		{"сумма", apiErr(400, "Bad Request: CURRENCY_TOTAL_AMOUNT_INVALID", 0), "не принял эту сумму", false, 0},
+		{"звёзды недоступны", apiErr(400, "Bad Request: STARS_INVOICE_INVALID", 0), "звёздами сейчас недоступна", false, 0},
+		{"другая 400", apiErr(400, "Bad Request: chat not found", 0), "Не удалось создать платёж", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, retry, wait := invoiceFailure(tt.err)
+			if tt.wantText == "" && text != "" || !strings.Contains(text, tt.wantText) {
+				t.Errorf("invoiceFailure text = %q, want containing %q", text, tt.wantText)
+			}
+			if retry != tt.wantRetry || (tt.wantRetry && wait != tt.wantWait) {
+				t.Errorf("invoiceFailure retry, wait = %v, %s; want %v, %s", retry, wait, tt.wantRetry, tt.wantWait)
+			}
+		})
+	}
+}
+
+// TestSendStarsInvoiceErrors проверяет sendStarsInvoice с Bot API, отвечающим ошибками:
+// серверная ошибка повторяется один раз, отказ в сумме объясняется без повтора.
+func TestSendStarsInvoiceErrors(t *testing.T) {
+	t.Run("повтор после 500", func(t *testing.T) {
+		b, tg, _ := newTestBot(t)
+		tg.failNext("sendInvoice", 500, "Internal Server Error", 0)
+
+		b.sendStarsInvoice(1, 50)
+		if n := tg.count("sendInvoice"); n != 2 {
+			t.Errorf("sendInvoice calls = %d, want 2", n)
+		}
+		if sent := tg.sent(); len(sent) != 0 {
+			t.Errorf("sent %q, want nothing after successful retry", sent)
+		}
+	})
+
+	t.Run("сумма отклонена", func(t *testing.T) {
+		b, tg, _ := newTestBot(t)
+		tg.failNext("sendInvoice", 400, "Bad Request: CURRENCY_TOTAL_AMOUNT_INVALID", 0)
+
+		b.sendStarsInvoice(1, 100000)
+		if n := tg.count("sendInvoice"); n != 1 {
+			t.Errorf("sendInvoice calls = %d, want 1", n)
+		}
+		if sent := tg.sent(); len(sent) != 1 || !strings.Contains(sent[0], "не принял эту сумму") {
+			t.Errorf("sent %q, want the amount explanation", sent)
+		}
+	})
+}
+
+func TestParseShiftOffset(t *testing.T) {
+	tests := []struct {
+		in   string
+		step int
+		want int
+		ok   bool
+	}{
+		{"+1h", 15, 60, true},
+		{"-30m", 15, -30, true},
+		{"+1h30m", 15, 90, true},
+		{"-1ч", 15, -60, true},
+		{"+10m", 15, 0, false},
+		{"+10m", 5, 10, true},
+		{"+10мин", 10, 10, true},
+		{"-5m", 5, -5, true},
+		{"+7m", 5, 0, false},
+		{"+15m", 30, 0, false},
+		{"0m", 5, 0, false},
+		{"+13h", 5, 0, false},
+		{"завтра", 5, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseShiftOffset(tt.in, tt.step)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseShiftOffset(%q, %d) = %d, %v, want %d, %v", tt.in, tt.step, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestReminderKeyboardDefaultSnooze(t *testing.T) {
+	b, _, clock := newTestBot(t)
+	slot := clock.Now()
+
+	keyboard := b.reminderKeyboard(defaultLocale, Reminder{ID: 12}, slot)
+	if len(keyboard.InlineKeyboard) < 2 || len(keyboard.InlineKeyboard[1]) == 0 {
+		t.Fatalf("reminder keyboard = %+v, want a snooze row", keyboard.InlineKeyboard)
+	}
+	button := keyboard.InlineKeyboard[1][0]
+	want := fmt.Sprintf("snooze_12_%d_10", slot.Unix())
+	if button.CallbackData == nil || *button.CallbackData != want {
+		t.Errorf("first snooze button data = %v, want %q", button.CallbackData, want)
+	}
+}
+
+// TestDoseAmountPromptButtons проверяет, что после дозировки, введённой
+// текстом, у вопроса о дозировке убираются кнопки "Пропустить" и "Отмена"
+func TestDoseAmountPromptButtons(t *testing.T) {
+	b, tg, _ := newTestBot(t)
+	const chatID = 1
+
+	b.handleUpdate(textUpdate(chatID, "/add"))
+	b.handleUpdate(textUpdate(chatID, "Аспирин"))
+	b.handleUpdate(textUpdate(chatID, "2 таблетки"))
+
+	tg.mu.Lock()
+	calls := slices.Clone(tg.calls)
+	tg.mu.Unlock()
+
+	prompt := 0
+	for _, c := range calls {
+		if c.Method == "sendMessage" && strings.Contains(c.Text, "Сколько принимать за раз?") {
+			prompt = c.ResultID
+		}
+	}
+	if prompt == 0 {
+		t.Fatalf("calls = %+v, want a dose amount prompt", calls)
+	}
+	removed := slices.ContainsFunc(calls, func(c fakeCall) bool {
+		return c.Method == "editMessageReplyMarkup" && c.MessageID == prompt
+	})
+	if !removed {
+		t.Errorf("calls = %+v, want buttons removed from prompt %d", calls, prompt)
+	}
+
+	p, _ := b.pendingSnapshot(chatID)
+	if p.State != StateWaitingHour || p.DoseAmount != "2 таблетки" || p.PromptMsgID != 0 {
+		t.Errorf("pending after dose amount = %+v, want waiting for hour with 2 таблетки", p)
+	}
+}
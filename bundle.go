@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Как присылать напоминания
+const (
+	bundlingDose   = "dose"   // по одному в своё время — по умолчанию
+	bundlingHalves = "halves" // утренние и вечерние одним сообщением
+)
+
+// bundlingModes — варианты в порядке кнопок /settings
+var bundlingModes = []struct {
+	Mode  string
+	Label string
+}{
+	{bundlingDose, "🔔 По одному"},
+	{bundlingHalves, "📦 Утро и вечер"},
+}
+
+// bundleNoonHour делит день пользователя на утро (00:00–11:59) и вечер (12:00–23:59)
+const bundleNoonHour = 12
+
+// bundling возвращает, как пользователь получает напоминания (bundlingDose при ошибке)
+func (b *Bot) bundling(chatID int64) string {
+	mode, err := b.storage.GetBundling(chatID)
+	if err != nil {
+		log.Printf("Failed to get bundling for %d: %v", chatID, err)
+		return bundlingDose
+	}
+	if mode == bundlingHalves {
+		return mode
+	}
+	return bundlingDose
+}
+
+// bundleWindowEnd возвращает конец половины дня, в которую попадает t
+// (полдень или следующая полночь в поясе t) и вечерняя ли это половина
+func bundleWindowEnd(t time.Time) (end time.Time, evening bool) {
+	if t.Hour() < bundleNoonHour {
+		return time.Date(t.Year(), t.Month(), t.Day(), bundleNoonHour, 0, 0, 0, t.Location()), false
+	}
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()), true
+}
+
+// startsBundle проверяет, открывают ли напоминания слота пачку. Приём каждые
+// N часов приходит в своё время и не тянет за собой остальные лекарства половины дня.
+func startsBundle(reminders []Reminder) bool {
+	for _, r := range reminders {
+		if r.IntervalMinutes == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// bundleItems собирает пачку пользователя на слот: все его приёмы с slot до
+// конца половины дня, ещё не записанные в журнал. Каждый приём записывается
+// в dose_log под своим временем, поэтому в свой слот он уже не придёт повторно,
+// а напоминание, добавленное после отправки пачки, придёт в своё время.
+// Приём каждые N часов заранее не присылается — он попадает только в пачку своего слота.
+// false — не удалось загрузить данные; тогда напоминания слота отправляются по одному.
+func (s *Scheduler) bundleItems(chatID int64, slot time.Time, stats *slotStats) ([]occurrence, bool) {
+	bot := s.bot
+
+	user, err := bot.storage.GetUser(chatID)
+	if err != nil || user == nil {
+		log.Printf("Failed to get user %d for bundle: %v", chatID, err)
+		return nil, false
+	}
+	reminders, err := bot.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders for bundle: %v", err)
+		return nil, false
+	}
+
+	loc := bot.userLoc(chatID)
+	end, _ := bundleWindowEnd(slot.In(loc))
+
+	var items []occurrence
+	for _, o := range reminderOccurrences(reminders, slot, end, bot.now(), loc, user.VacationFrom, user.VacationUntil) {
+		if o.Reminder.IntervalMinutes > 0 && !o.At.Equal(slot) {
+			continue
+		}
+		stats.reminders++
+		metricSlotReminders.Inc()
+
+		fresh, err := bot.storage.MarkDoseScheduled(chatID, o.Reminder.ID, o.Reminder.Medicine, o.At)
+		if err != nil {
+			log.Printf("Failed to log scheduled dose: %v", err)
+		} else if !fresh {
+			// Уже ушло раньше — в предыдущей пачке или в своё время
+			stats.skip(chatID, o.Reminder)
+			metricSlotSkipped.Inc()
+			continue
+		}
+		items = append(items, o)
+	}
+	return items, true
+}
+
+// sendBundle отправляет пачку одним сообщением: строка и кнопка "принял" на каждый
+// приём. Напоминания без подтверждения идут без кнопки и засчитываются сразу.
+func (s *Scheduler) sendBundle(chatID int64, lang string, slot time.Time, items []occurrence) error {
+	bot := s.bot
+
+	// О пропущенной предыдущей дозе — один раз на лекарство, до приёмов пачки
+	warned := make(map[string]bool)
+	for _, o := range items {
+		if o.Reminder.Important && !warned[o.Reminder.Medicine] {
+			warned[o.Reminder.Medicine] = true
+			bot.notifyMissedPreviousDose(chatID, lang, o.Reminder, slot)
+		}
+	}
+
+	l := bot.userLocale(chatID, lang)
+	_, evening := bundleWindowEnd(slot.In(l.Loc))
+	header := T(lang, "bundle.morning")
+	if evening {
+		header = T(lang, "bundle.evening")
+	}
+
+	lines := []string{header, ""}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, o := range items {
+		mark := "⏰"
+		if !o.Reminder.RequireConfirm {
+			mark = "🔔"
+		}
+		clock := formatClock(l, o.At)
+		lines = append(lines, fmt.Sprintf("%s %s — 💊 %s (%s)", mark, clock, doseName(o.Reminder), o.Reminder.CourseString(bot.now())))
+		if o.Reminder.RequireConfirm {
+			rows = append(rows, appendDataButton(nil,
+				fmt.Sprintf("✅ %s %s", clock, buttonName(o.Reminder.Medicine)),
+				fmt.Sprintf("btaken_%d_%d_%d", o.Reminder.ID, o.At.Unix(), len(lines)-1),
+			))
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, strings.Join(lines, "\n"))
+	if len(rows) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	}
+	sendStarted := time.Now()
+	_, err := bot.api.Send(msg)
+	recordSend(err, time.Since(sendStarted))
+	bot.deactivateIfBlocked(chatID, err)
+
+	switch {
+	case err == nil:
+		for _, o := range items {
+			if !o.Reminder.RequireConfirm {
+				bot.autoCountDose(chatID, o.Reminder, o.At)
+			}
+		}
+	case isTimeout(err):
+		log.Printf("Timed out sending bundle of %d reminders to %d for slot %s, needs retry: %v",
+			len(items), chatID, slot.Format("15:04"), err)
+	default:
+		log.Printf("Failed to send bundle to %d: %v", chatID, err)
+	}
+	return err
+}
+
+// handleBundleTaken отмечает приём из пачки: btaken_<id>_<unix времени приёма>_<номер строки>.
+// Строка приёма помечается принятой, его кнопка убирается, остальные остаются.
+// Приём можно отметить заранее — до его времени по расписанию.
+func (b *Bot) handleBundleTaken(callback *tgbotapi.CallbackQuery) {
+	chatID := callback.Message.Chat.ID
+	messageID := callback.Message.MessageID
+	lang := callback.From.LanguageCode
+
+	parts := strings.Split(strings.TrimPrefix(callback.Data, "btaken_"), "_")
+	if len(parts) != 3 {
+		b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	reminderID, _ := strconv.Atoi(parts[0])
+	ts, _ := strconv.ParseInt(parts[1], 10, 64)
+	line, _ := strconv.Atoi(parts[2])
+	slot := time.Unix(ts, 0)
+
+	// Кнопки без нажатой: остальные приёмы пачки ещё можно отметить
+	keyboard := tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	if callback.Message.ReplyMarkup != nil {
+		for _, row := range callback.Message.ReplyMarkup.InlineKeyboard {
+			if len(row) > 0 && row[0].CallbackData != nil && *row[0].CallbackData == callback.Data {
+				continue
+			}
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, row)
+		}
+	}
+	dropButton := func() {
+		edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+		if _, err := b.api.Send(edit); err != nil {
+			log.Printf("Failed to edit message: %v", err)
+		}
+	}
+
+	if b.now().Sub(slot) > takenConfirmWindow {
+		b.api.Request(tgbotapi.NewCallback(callback.ID, T(lang, "bundle.stale")))
+		dropButton()
+		return
+	}
+
+	text, completionText, err := b.confirmDose(chatID, reminderID, slot)
+	switch {
+	case errors.Is(err, ErrCourseCompleted):
+		b.api.Request(tgbotapi.NewCallbackWithAlert(callback.ID, "🏁 Курс уже завершён — напоминание больше не нужно отмечать"))
+		dropButton()
+		return
+	case err != nil:
+		// Уже отмечено или напоминание удалено
+		b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+		dropButton()
+		return
+	}
+	b.api.Request(tgbotapi.NewCallback(callback.ID, ""))
+
+	lines := strings.Split(callback.Message.Text, "\n")
+	if line > 0 && line < len(lines) {
+		lines[line] = strings.Replace(lines[line], "⏰", "✅", 1) + " — " + T(lang, "bundle.taken")
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, strings.Join(lines, "\n"))
+	if len(keyboard.InlineKeyboard) > 0 {
+		edit.ReplyMarkup = &keyboard
+	}
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+
+	// Предупреждение о скором конце курса идёт после строк подтверждения
+	if _, notice, ok := strings.Cut(text, "\n\n"); ok {
+		b.sendMessage(chatID, notice)
+	}
+	if completionText != "" {
+		b.sendMessage(chatID, completionText)
+	}
+}
+
+// bundlingKeyboardRow — выбор, как присылать напоминания, текущий вариант отмечен ✓
+func bundlingKeyboardRow(current string) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, m := range bundlingModes {
+		label := m.Label
+		if m.Mode == current {
+			label = "✓ " + label
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, "bundle_"+m.Mode))
+	}
+	return row
+}
+
+// handleBundlingSelected сохраняет, как присылать напоминания.
+// Уже отправленные пачки и напоминания не меняются.
+func (b *Bot) handleBundlingSelected(chatID int64, messageID int, mode string) {
+	switch mode {
+	case bundlingDose, bundlingHalves:
+	default:
+		return
+	}
+	if mode == b.bundling(chatID) {
+		return
+	}
+
+	if err := b.storage.SetBundling(chatID, mode); err != nil {
+		log.Printf("Failed to set bundling: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	keyboard := settingsKeyboard(b.celebration(chatID), b.minuteStep(chatID), mode)
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
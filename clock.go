@@ -0,0 +1,19 @@
+package main
+
+import "time"
+
+// Clock — источник текущего времени. Бот и планировщик берут время только
+// через него, поэтому в тестах время можно заморозить или сдвинуть.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock возвращает системное время
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// now возвращает текущее время по часам бота
+func (b *Bot) now() time.Time {
+	return b.clock.Now()
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// maxSameTimeReminders — сколько напоминаний на одно время считается нормой.
+// Если после добавления их больше, бот советует разнести приём.
+const maxSameTimeReminders = 3
+
+// drugInteraction — пара лекарств, которые не стоит принимать одновременно.
+// Названия сравниваются без учёта регистра как подстроки названия напоминания,
+// поэтому "аспирин" совпадёт с "Аспирин Кардио 100 мг".
+type drugInteraction struct {
+	A    string `json:"a"`
+	B    string `json:"b"`
+	Note string `json:"note"` // пояснение для пользователя, необязательно
+}
+
+// matches проверяет, что пара medicine/other — это лекарства из взаимодействия
+func (i drugInteraction) matches(medicine, other string) bool {
+	medicine, other = strings.ToLower(medicine), strings.ToLower(other)
+	a, b := strings.ToLower(i.A), strings.ToLower(i.B)
+	return (strings.Contains(medicine, a) && strings.Contains(other, b)) ||
+		(strings.Contains(medicine, b) && strings.Contains(other, a))
+}
+
+// loadInteractions читает список взаимодействий из JSON-файла INTERACTIONS_FILE:
+// [{"a": "...", "b": "...", "note": "..."}]. Без файла проверка взаимодействий отключена —
+// бот не поставляет собственных медицинских данных.
+func loadInteractions(path string) []drugInteraction {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read interactions file: %v", err)
+		return nil
+	}
+
+	var interactions []drugInteraction
+	if err := json.Unmarshal(data, &interactions); err != nil {
+		log.Printf("Failed to parse interactions file: %v", err)
+		return nil
+	}
+
+	valid := interactions[:0]
+	for _, i := range interactions {
+		if strings.TrimSpace(i.A) == "" || strings.TrimSpace(i.B) == "" {
+			log.Printf("Ignoring interaction with empty medicine name: %+v", i)
+			continue
+		}
+		valid = append(valid, i)
+	}
+	log.Printf("Loaded %d drug interactions from %s", len(valid), path)
+	return valid
+}
+
+// warnReminderConflicts после добавления напоминания r предупреждает,
+// если на это время (у приёма каждые N часов — на любое из его времён)
+// уже слишком много напоминаний или среди них есть
+// лекарство, которое не стоит принимать вместе с r. Добавление не отменяется.
+func (b *Bot) warnReminderConflicts(chatID int64, r Reminder) {
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+		return
+	}
+
+	var sameTime []Reminder
+	for _, other := range reminders {
+		// Разовые напоминания на другие даты и напоминания в другие дни недели не пересекаются с r
+		otherDay := r.FireDate != nil && other.FireDate != nil && !other.FireDate.Equal(*r.FireDate)
+		otherDay = otherDay || r.weekdays()&other.weekdays() == 0
+		if sharesDailyTime(r, other) && !otherDay {
+			sameTime = append(sameTime, other)
+		}
+	}
+
+	var warnings []string
+	if len(sameTime) > maxSameTimeReminders {
+		warnings = append(warnings, fmt.Sprintf("⚠️ На %s уже %d напоминаний. Если врач не против, разнеси приём на 15–30 минут — так проще ничего не пропустить",
+			r.TimeString(), len(sameTime)))
+	}
+
+	for _, other := range sameTime {
+		if other.ID == r.ID {
+			continue
+		}
+		for _, i := range b.interactions {
+			if !i.matches(r.Medicine, other.Medicine) {
+				continue
+			}
+			text := fmt.Sprintf("⚠️ 💊 %s и 💊 %s обычно не принимают одновременно", displayName(r.Medicine), displayName(other.Medicine))
+			if i.Note != "" {
+				text += ": " + i.Note
+			}
+			warnings = append(warnings, text+". Уточни у врача")
+			break
+		}
+	}
+
+	if len(warnings) > 0 {
+		b.sendMessage(chatID, strings.Join(warnings, "\n\n"))
+	}
+}
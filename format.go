@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// maxButtonNameRunes — сколько символов названия помещается в кнопку
+	maxButtonNameRunes = 24
+	// maxCallbackDataBytes — ограничение Telegram на callback_data
+	maxCallbackDataBytes = 64
+	// maxMessageLength — ограничение Telegram на длину текста сообщения (в единицах UTF-16)
+	maxMessageLength = 4096
+)
+
+// textLength считает длину текста так же, как Telegram, — в единицах UTF-16
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		if l := utf16.RuneLen(r); l > 0 {
+			n += l
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// splitMessage делит текст на части не длиннее limit по границам строк, чтобы
+// строки с числами и таблицы не разрывались посередине. Только строка, которая
+// сама длиннее limit, режется по символам.
+func splitMessage(text string, limit int) []string {
+	var parts []string
+	var cur strings.Builder
+	curLen := 0
+	flush := func() {
+		if part := strings.TrimRight(cur.String(), "\n"); part != "" {
+			parts = append(parts, part)
+		}
+		cur.Reset()
+		curLen = 0
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineLen := textLength(line)
+		if curLen+lineLen > limit {
+			flush()
+		}
+		for lineLen > limit {
+			// Длинная строка без переносов — режем по символам
+			n, cut := 0, 0
+			for i, r := range line {
+				if n+textLength(string(r)) > limit {
+					cut = i
+					break
+				}
+				n += textLength(string(r))
+			}
+			parts = append(parts, line[:cut])
+			line = line[cut:]
+			lineLen = textLength(line)
+		}
+		cur.WriteString(line)
+		curLen += lineLen
+	}
+	flush()
+	return parts
+}
+
+// truncateRunes обрезает строку до max символов (не байт), добавляя многоточие
+func truncateRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	if max <= 1 {
+		return "…"
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// isolateBidi изолирует текст с RTL-символами, чтобы он не переворачивал
+// порядок соседних элементов строки (времени, счётчика и т.п.)
+func isolateBidi(s string) string {
+	for _, r := range s {
+		if unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			return "\u2068" + s + "\u2069"
+		}
+	}
+	return s
+}
+
+// displayName подготавливает название лекарства для вывода в тексте
+func displayName(medicine string) string {
+	return isolateBidi(medicine)
+}
+
+// buttonName подготавливает название лекарства для подписи кнопки
+func buttonName(medicine string) string {
+	return isolateBidi(truncateRunes(medicine, maxButtonNameRunes))
+}
+
+// CallbackDataTooLongError — callback_data кнопки длиннее ограничения Telegram.
+// Обрезать её нельзя: обработчик не разобрал бы нажатие.
+type CallbackDataTooLongError struct {
+	Data string
+}
+
+func (e *CallbackDataTooLongError) Error() string {
+	return fmt.Sprintf("callback data too long (%d bytes, max %d): %q", len(e.Data), maxCallbackDataBytes, e.Data)
+}
+
+// newDataButton создаёт inline-кнопку, проверяя длину callback_data
+func newDataButton(text, data string) (tgbotapi.InlineKeyboardButton, error) {
+	if len(data) > maxCallbackDataBytes {
+		return tgbotapi.InlineKeyboardButton{}, &CallbackDataTooLongError{Data: data}
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(text, data), nil
+}
+
+// appendDataButton добавляет кнопку к ряду. Кнопка, которую нельзя создать,
+// пропускается с записью в лог: лучше без неё, чем с неразбираемым нажатием.
+func appendDataButton(row []tgbotapi.InlineKeyboardButton, text, data string) []tgbotapi.InlineKeyboardButton {
+	button, err := newDataButton(text, data)
+	if err != nil {
+		log.Printf("Failed to create button %q: %v", text, err)
+		return row
+	}
+	return append(row, button)
+}
+
+// userLabel возвращает подпись пользователя для логов и админских сообщений:
+// "@username", если он есть, иначе имя, иначе ID
+func userLabel(username, firstName string, chatID int64) string {
+	switch {
+	case username != "":
+		return "@" + username
+	case firstName != "":
+		return firstName
+	}
+	return fmt.Sprintf("id%d", chatID)
+}
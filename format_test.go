@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"короткое", "Аспирин", 24, "Аспирин"},
+		{"ровно max", strings.Repeat("я", 24), 24, strings.Repeat("я", 24)},
+		{"кириллица", strings.Repeat("я", 30), 24, strings.Repeat("я", 23) + "…"},
+		{"эмодзи", strings.Repeat("💊", 30), 24, strings.Repeat("💊", 23) + "…"},
+		{"пробел перед обрезкой", "Витамин D3 и Омега 3 утром", 12, "Витамин D3…"},
+		{"max 1", "Аспирин", 1, "…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateRunes(tt.in, tt.max)
+			if got != tt.want {
+				t.Errorf("truncateRunes(%q, %d) = %q, want %q", tt.in, tt.max, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateRunes(%q, %d) = %q, invalid UTF-8", tt.in, tt.max, got)
+			}
+		})
+	}
+}
+
+func TestButtonName(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		maxLen  int // наибольшее число символов результата
+		isolate bool
+	}{
+		{"кириллица", strings.Repeat("Ацетилсалициловая кислота ", 3), maxButtonNameRunes, false},
+		{"семья эмодзи", strings.Repeat("👨‍👩‍👧", 10), maxButtonNameRunes, false},
+		{"флаги", strings.Repeat("🇷🇺", 20), maxButtonNameRunes, false},
+		{"иврит", strings.Repeat("אספירין ", 5), maxButtonNameRunes + 2, true},
+		{"арабский", "باراسيتامول", maxButtonNameRunes + 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buttonName(tt.in)
+			if !utf8.ValidString(got) {
+				t.Fatalf("buttonName(%q) = %q, invalid UTF-8", tt.in, got)
+			}
+			if n := utf8.RuneCountInString(got); n > tt.maxLen {
+				t.Errorf("buttonName(%q) = %q, %d runes, want at most %d", tt.in, got, n, tt.maxLen)
+			}
+			isolated := strings.HasPrefix(got, "\u2068") && strings.HasSuffix(got, "\u2069")
+			if isolated != tt.isolate {
+				t.Errorf("buttonName(%q) = %q, isolated = %v, want %v", tt.in, got, isolated, tt.isolate)
+			}
+		})
+	}
+}
+
+func TestIsolateBidi(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Аспирин", "Аспирин"},
+		{"Aspirin 500", "Aspirin 500"},
+		{"💊🌙", "💊🌙"},
+		{"אספירין", "\u2068אספירין\u2069"},
+		{"Аспирин باراسيتامول", "\u2068Аспирин باراسيتامول\u2069"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := isolateBidi(tt.in); got != tt.want {
+			t.Errorf("isolateBidi(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNewDataButton(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"обычная", "snooze_12_1760000000_15", true},
+		{"ровно 64 байта", strings.Repeat("x", maxCallbackDataBytes), true},
+		{"65 байт", strings.Repeat("x", maxCallbackDataBytes+1), false},
+		// 33 символа кириллицы — 66 байт: обрезка по байту 64 разрезала бы символ
+		{"кириллица", strings.Repeat("я", 33), false},
+		{"эмодзи", "del_" + strings.Repeat("💊", 16), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			button, err := newDataButton("💊", tt.data)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("newDataButton(%q): %v", tt.data, err)
+				}
+				if button.CallbackData == nil || *button.CallbackData != tt.data {
+					t.Errorf("newDataButton(%q) callback data = %v, want unchanged", tt.data, button.CallbackData)
+				}
+				return
+			}
+			var tooLong *CallbackDataTooLongError
+			if !errors.As(err, &tooLong) {
+				t.Fatalf("newDataButton(%q) error = %v, want CallbackDataTooLongError", tt.data, err)
+			}
+		})
+	}
+
+	row := appendDataButton(nil, "💊", strings.Repeat("x", maxCallbackDataBytes+1))
+	if len(row) != 0 {
+		t.Errorf("appendDataButton with too long data = %d buttons, want none", len(row))
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// historyLimit — сколько последних завершённых курсов показывает /history
+const historyLimit = 50
+
+// handleHistory показывает завершённые курсы: они больше не приходят и не видны
+// в /list, но принятые дозы и даты курса остаются
+func (b *Bot) handleHistory(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	courses, err := b.storage.GetCompletedCourses(chatID, historyLimit)
+	if err != nil {
+		log.Printf("Failed to get completed courses: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки истории")
+		return
+	}
+	if len(courses) == 0 {
+		b.sendMessage(chatID, "Завершённых курсов пока нет. Курс попадает сюда, когда принята последняя доза "+
+			"или прошли все его дни по календарю")
+		return
+	}
+
+	l := b.userLocale(chatID, defaultLocale)
+	var text strings.Builder
+	text.WriteString("📜 Завершённые курсы:\n\n")
+	for _, c := range courses {
+		text.WriteString(fmt.Sprintf("🏁 💊 %s\n", displayName(c.Medicine)))
+		if c.StartsAt == nil {
+			// Курс завершён до появления истории — от него осталась только дата
+			text.WriteString(fmt.Sprintf("    ↳ завершён %s\n", formatDate(l, c.CompletedAt)))
+			continue
+		}
+		text.WriteString(fmt.Sprintf("    ↳ %s – %s, %s, принято доз: %d\n",
+			formatDate(l, *c.StartsAt), formatDate(l, c.CompletedAt), courseLengthString(c.CourseDays, c.CourseType), c.DosesTaken))
+	}
+	if len(courses) == historyLimit {
+		text.WriteString(fmt.Sprintf("\nПоказаны последние %d курсов", historyLimit))
+	}
+
+	b.sendLongMessage(chatID, text.String())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultLocale — язык, на который откатываются отсутствующие переводы
+const defaultLocale = "ru"
+
+// messages — каталог сообщений по языкам. Ключи, которых нет в переводе,
+// берутся из defaultLocale, поэтому новый текст достаточно добавить в "ru".
+var messages = map[string]map[string]string{
+	"ru": {
+		"reminder.text":     "⏰ Время принять: 💊 %s\n📊 Приём: %s",
+		"button.taken":      "✅ Принял",
+		"button.snooze":     "⏰ +%s",
+		"button.skip":       "⏭ Пропустить сегодня",
+		"snooze.scheduled":  "⏰ Отложено на %s: 💊 %s",
+		"skip.done":         "⏭ Пропущено сегодня: 💊 %s\nКурс не сдвигается — следующий приём по расписанию",
+		"reminder.missed":   "⚠️ Ты пропустил предыдущую дозу 💊 %s (%s). Не принимай две дозы сразу без совета врача",
+		"reminder.preAlert": "🔔 Через %s: %s",
+		"reminder.nudge":    "🔔 Напоминаю ещё раз: 💊 %s (приём в %s) ещё не отмечен",
+		"bundle.morning":    "☀️ Утренние лекарства — отметь каждое, когда примешь:",
+		"bundle.evening":    "🌙 Вечерние лекарства — отметь каждое, когда примешь:",
+		"bundle.taken":      "принято, приём %s",
+		"bundle.stale":      "⌛ Время подтверждения прошло",
+		"duration.minutes":  "%d мин",
+		"duration.hours":    "%d ч",
+		"duration.hoursMin": "%d ч %d мин",
+		"format.date":       "02.01.2006",
+		"format.dateShort":  "02.01",
+
+		"command.start":     "Начать работу",
+		"command.add":       "Добавить напоминание",
+		"command.import":    "Добавить назначение врача списком",
+		"command.list":      "Мои напоминания",
+		"command.clear":     "Удалить все напоминания",
+		"command.wake":      "Время пробуждения",
+		"command.sleep":     "Время отхода ко сну",
+		"command.vacation":  "Пауза на время отпуска",
+		"command.report":    "Отчёт для врача",
+		"command.mystats":   "Соблюдение режима по неделям",
+		"command.adherence": "Соблюдение режима за 7 и 30 дней",
+		"command.history":   "Завершённые курсы",
+		"command.timezone":  "Часовой пояс",
+		"command.shift":     "Сдвинуть время всех напоминаний",
+		"command.prealert":  "Предупреждать заранее",
+		"command.settings":  "Настройки",
+		"command.stop":      "Отключить напоминания",
+		"command.donate":    "Поддержать автора",
+		"command.stats":     "Статистика бота",
+	},
+	"en": {
+		"reminder.text":     "⏰ Time to take: 💊 %s\n📊 Dose: %s",
+		"button.taken":      "✅ Taken",
+		"button.snooze":     "⏰ +%s",
+		"button.skip":       "⏭ Skip today",
+		"snooze.scheduled":  "⏰ Snoozed for %s: 💊 %s",
+		"skip.done":         "⏭ Skipped today: 💊 %s\nThe course isn't advanced — next dose as scheduled",
+		"reminder.missed":   "⚠️ You missed the previous dose of 💊 %s (%s). Don't take a double dose without asking your doctor",
+		"reminder.preAlert": "🔔 In %s: %s",
+		"reminder.nudge":    "🔔 Reminder again: 💊 %s (dose at %s) is not marked yet",
+		"bundle.morning":    "☀️ Morning medicines — tap each one once taken:",
+		"bundle.evening":    "🌙 Evening medicines — tap each one once taken:",
+		"bundle.taken":      "taken, dose %s",
+		"bundle.stale":      "⌛ Too late to confirm",
+		"duration.minutes":  "%d min",
+		"duration.hours":    "%d h",
+		"duration.hoursMin": "%d h %d min",
+		"format.date":       "Jan 2, 2006",
+		"format.dateShort":  "Jan 2",
+
+		"command.start":     "Get started",
+		"command.add":       "Add a reminder",
+		"command.import":    "Add a prescription as a list",
+		"command.list":      "My reminders",
+		"command.clear":     "Delete all reminders",
+		"command.wake":      "Wake-up time",
+		"command.sleep":     "Bedtime",
+		"command.vacation":  "Pause while on vacation",
+		"command.report":    "Report for your doctor",
+		"command.mystats":   "Adherence by week",
+		"command.adherence": "Adherence over 7 and 30 days",
+		"command.history":   "Finished courses",
+		"command.timezone":  "Time zone",
+		"command.shift":     "Shift all reminder times",
+		"command.prealert":  "Heads-up before reminders",
+		"command.settings":  "Settings",
+		"command.stop":      "Turn reminders off",
+		"command.donate":    "Support the author",
+		"command.stats":     "Bot statistics",
+	},
+}
+
+// normalizeLocale приводит language_code Telegram ("en-US") к ключу каталога ("en").
+// Неизвестные языки заменяются на defaultLocale.
+func normalizeLocale(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := messages[code]; ok {
+		return code
+	}
+	return defaultLocale
+}
+
+// T возвращает сообщение по ключу на языке lang с подстановкой аргументов
+func T(lang, key string, args ...interface{}) string {
+	format, ok := messages[normalizeLocale(lang)][key]
+	if !ok {
+		format, ok = messages[defaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// formatDuration возвращает локализованную длительность: "15 мин", "2 ч", "1 ч 30 мин"
+func formatDuration(lang string, minutes int) string {
+	h, m := minutes/60, minutes%60
+	switch {
+	case h == 0:
+		return T(lang, "duration.minutes", m)
+	case m == 0:
+		return T(lang, "duration.hours", h)
+	}
+	return T(lang, "duration.hoursMin", h, m)
+}
+
+// Locale — язык и часовой пояс, в которых пользователю показываются даты и время
+type Locale struct {
+	Lang    string
+	Loc     *time.Location // nil — время не переводится
+	Clock12 bool           // 12-часовой формат: "8:05 AM"
+}
+
+// newLocale возвращает настройки отображения для языка Telegram.
+// 12-часовой формат по умолчанию используется для английского.
+func newLocale(lang string, loc *time.Location) Locale {
+	lang = normalizeLocale(lang)
+	return Locale{Lang: lang, Loc: loc, Clock12: lang == "en"}
+}
+
+func (l Locale) in(t time.Time) time.Time {
+	if l.Loc == nil {
+		return t
+	}
+	return t.In(l.Loc)
+}
+
+// formatTime форматирует время суток: "08:05" или "8:05 AM"
+func formatTime(l Locale, hour, minute int) string {
+	if !l.Clock12 {
+		return fmt.Sprintf("%02d:%02d", hour, minute)
+	}
+
+	suffix := "AM"
+	if hour >= 12 {
+		suffix = "PM"
+	}
+	h := hour % 12
+	if h == 0 {
+		h = 12
+	}
+	return fmt.Sprintf("%d:%02d %s", h, minute, suffix)
+}
+
+// formatClock форматирует время момента t в часовом поясе пользователя
+func formatClock(l Locale, t time.Time) string {
+	t = l.in(t)
+	return formatTime(l, t.Hour(), t.Minute())
+}
+
+// formatDate форматирует дату момента t в часовом поясе пользователя: "02.01.2006"
+func formatDate(l Locale, t time.Time) string {
+	return l.in(t).Format(T(l.Lang, "format.date"))
+}
+
+// formatShortDate форматирует дату без года: "02.01"
+func formatShortDate(l Locale, t time.Time) string {
+	return l.in(t).Format(T(l.Lang, "format.dateShort"))
+}
+
+// formatCalendarDate форматирует календарную дату (колонка DATE) без перевода
+// в часовой пояс — иначе при отрицательном смещении дата сдвинется на день назад
+func formatCalendarDate(l Locale, date time.Time) string {
+	return date.Format(T(l.Lang, "format.date"))
+}
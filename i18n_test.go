@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTFallback(t *testing.T) {
+	// Ключ, переведённый только на язык по умолчанию
+	const ruOnly = "test.ruOnly"
+	messages[defaultLocale][ruOnly] = "Принято: %d"
+	t.Cleanup(func() { delete(messages[defaultLocale], ruOnly) })
+
+	tests := []struct {
+		name string
+		lang string
+		key  string
+		args []any
+		want string
+	}{
+		{"en без перевода", "en", ruOnly, []any{3}, "Принято: 3"},
+		{"en-US без перевода", "en-US", ruOnly, []any{3}, "Принято: 3"},
+		{"перевод есть", "en", "button.taken", nil, messages["en"]["button.taken"]},
+		{"неизвестный язык", "xx", "button.taken", nil, messages[defaultLocale]["button.taken"]},
+		{"пустой язык", "", "button.taken", nil, messages[defaultLocale]["button.taken"]},
+		{"неизвестный ключ", "en", "test.missing", nil, "test.missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := T(tt.lang, tt.key, tt.args...); got != tt.want {
+				t.Errorf("T(%q, %q) = %q, want %q", tt.lang, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		lang    string
+		clock12 bool
+		hour    int
+		minute  int
+		want    string
+	}{
+		{"ru", false, 0, 5, "00:05"},
+		{"ru", false, 8, 5, "08:05"},
+		{"ru", false, 12, 0, "12:00"},
+		{"ru", false, 23, 59, "23:59"},
+		{"en", false, 0, 5, "00:05"},
+		{"en", false, 12, 30, "12:30"},
+		{"ru", true, 0, 5, "12:05 AM"},
+		{"ru", true, 12, 30, "12:30 PM"},
+		{"en", true, 0, 0, "12:00 AM"},
+		{"en", true, 0, 45, "12:45 AM"},
+		{"en", true, 8, 5, "8:05 AM"},
+		{"en", true, 12, 0, "12:00 PM"},
+		{"en", true, 12, 59, "12:59 PM"},
+		{"en", true, 13, 0, "1:00 PM"},
+		{"en", true, 23, 59, "11:59 PM"},
+	}
+	for _, tt := range tests {
+		l := Locale{Lang: tt.lang, Clock12: tt.clock12}
+		if got := formatTime(l, tt.hour, tt.minute); got != tt.want {
+			t.Errorf("formatTime(%s, 12h=%v, %02d:%02d) = %q, want %q", tt.lang, tt.clock12, tt.hour, tt.minute, got, tt.want)
+		}
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	// 21:05 UTC — 00:05 следующего дня в Москве
+	at := time.Date(2026, 3, 1, 21, 5, 0, 0, time.UTC)
+
+	tests := []struct {
+		lang string
+		loc  *time.Location
+		want string
+	}{
+		{"ru", moscow, "00:05"},
+		{"en", moscow, "12:05 AM"},
+		{"ru", nil, "21:05"},
+		{"en", nil, "9:05 PM"},
+		{"en-GB", time.UTC, "9:05 PM"},
+		{"de", moscow, "00:05"},
+	}
+	for _, tt := range tests {
+		if got := formatClock(newLocale(tt.lang, tt.loc), at); got != tt.want {
+			t.Errorf("formatClock(%s, %v) = %q, want %q", tt.lang, tt.loc, got, tt.want)
+		}
+	}
+}
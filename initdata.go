@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// initDataMaxAge — сколько действительна подпись initData Web App.
+// Более старые данные отклоняются, чтобы перехваченный заголовок нельзя
+// было использовать бесконечно.
+const initDataMaxAge = 24 * time.Hour
+
+// initDataMaxSkew — насколько auth_date может опережать часы бота.
+// Telegram подписывает initData своим временем, поэтому небольшой разброс
+// допустим, а дата из будущего продлевала бы срок действия подписи.
+const initDataMaxSkew = time.Minute
+
+// Ошибки проверки initData
+var (
+	ErrInitDataHash    = errors.New("initData hash mismatch")
+	ErrInitDataExpired = errors.New("initData auth_date expired")
+	ErrInitDataFuture  = errors.New("initData auth_date is in the future")
+	ErrInitDataUser    = errors.New("initData has no user")
+)
+
+// validateInitData проверяет подпись Telegram Web App initData и возвращает
+// пользователя (ID, username, имя, язык — поля совпадают с User из Bot API). Порядок проверки — из документации Telegram: все поля, кроме
+// hash, сортируются по ключу и склеиваются как "key=value" через \n; ключ
+// подписи — HMAC-SHA256 токена бота с ключом "WebAppData"; hash должен
+// совпасть с HMAC-SHA256 этой строки. auth_date старше initDataMaxAge
+// или позже now больше чем на initDataMaxSkew отклоняется.
+func validateInitData(initData, botToken string, now time.Time) (*tgbotapi.User, error) {
+	params, err := url.ParseQuery(initData)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := params.Get("hash")
+	if hash == "" {
+		return nil, ErrInitDataHash
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + params.Get(key)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	expected, err := hex.DecodeString(hash)
+	if err != nil || !hmac.Equal(mac.Sum(nil), expected) {
+		return nil, ErrInitDataHash
+	}
+
+	authDate, err := strconv.ParseInt(params.Get("auth_date"), 10, 64)
+	if err != nil || now.Sub(time.Unix(authDate, 0)) > initDataMaxAge {
+		return nil, ErrInitDataExpired
+	}
+	if time.Unix(authDate, 0).Sub(now) > initDataMaxSkew {
+		return nil, ErrInitDataFuture
+	}
+
+	var user tgbotapi.User
+	if err := json.Unmarshal([]byte(params.Get("user")), &user); err != nil || user.ID == 0 {
+		return nil, ErrInitDataUser
+	}
+	return &user, nil
+}
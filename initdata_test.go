@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInitDataToken = "123456:TEST-token"
+
+// testInitData — initData, подписанная testInitDataToken отдельно от
+// validateInitData (по алгоритму из документации Telegram), auth_date —
+// 2026-03-02 05:00 UTC
+const testInitData = "query_id=AAHdF6IQAAAAAN0XohDhrOrc" +
+	"&user=%7B%22id%22%3A1001%2C%22first_name%22%3A%22%D0%A2%D0%B5%D1%81%D1%82%22%2C%22username%22%3A%22test_user%22%2C%22language_code%22%3A%22ru%22%7D" +
+	"&auth_date=1772427600" +
+	"&hash=3aebe12955d5eaaf2d536f7aa3d5b696ea28e39f3d5b0d092dcdb1c9f83e4800"
+
+// signInitData подписывает поля initData токеном token
+func signInitData(fields url.Values, token string) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + fields.Get(key)
+	}
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(token))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(lines, "\n")))
+
+	signed := url.Values{}
+	for key := range fields {
+		signed.Set(key, fields.Get(key))
+	}
+	signed.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+	return signed.Encode()
+}
+
+func TestValidateInitData(t *testing.T) {
+	authDate := time.Unix(1772427600, 0)
+
+	// Подделка последнего символа hash
+	tampered := testInitData[:len(testInitData)-1] + "1"
+	withoutHash, _, _ := strings.Cut(testInitData, "&hash=")
+	withoutUser := signInitData(url.Values{"query_id": {"AAHdF6IQAAAAAN0XohDhrOrc"}, "auth_date": {"1772427600"}}, testInitDataToken)
+	// Подписанные данные с подменённым пользователем
+	forged := strings.Replace(testInitData, "%22id%22%3A1001", "%22id%22%3A1002", 1)
+
+	tests := []struct {
+		name     string
+		initData string
+		token    string
+		now      time.Time
+		err      error
+	}{
+		{"верная подпись", testInitData, testInitDataToken, authDate.Add(time.Hour), nil},
+		{"auth_date немного впереди", testInitData, testInitDataToken, authDate.Add(-30 * time.Second), nil},
+		{"подделанный hash", tampered, testInitDataToken, authDate.Add(time.Hour), ErrInitDataHash},
+		{"подменённый user", forged, testInitDataToken, authDate.Add(time.Hour), ErrInitDataHash},
+		{"чужой токен", testInitData, "654321:OTHER-token", authDate.Add(time.Hour), ErrInitDataHash},
+		{"устаревшая auth_date", testInitData, testInitDataToken, authDate.Add(initDataMaxAge + time.Second), ErrInitDataExpired},
+		{"auth_date из будущего", testInitData, testInitDataToken, authDate.Add(-time.Hour), ErrInitDataFuture},
+		{"нет hash", withoutHash, testInitDataToken, authDate.Add(time.Hour), ErrInitDataHash},
+		{"нет user", withoutUser, testInitDataToken, authDate.Add(time.Hour), ErrInitDataUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := validateInitData(tt.initData, tt.token, tt.now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("validateInitData error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if user != nil {
+					t.Errorf("validateInitData user = %+v, want nil", *user)
+				}
+				return
+			}
+			if user == nil || user.ID != 1001 || user.UserName != "test_user" || user.FirstName != "Тест" || user.LanguageCode != "ru" {
+				t.Errorf("validateInitData user = %+v, want test_user 1001", user)
+			}
+		})
+	}
+}
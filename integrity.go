@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// integrityCheckInterval — как часто планировщик проверяет согласованность данных
+const integrityCheckInterval = 6 * time.Hour
+
+// checkIntegrity раз в integrityCheckInterval ищет несогласованные строки,
+// исправляет безопасные случаи и пишет найденное в лог. В DRY_RUN только сообщает.
+func (s *Scheduler) checkIntegrity(now time.Time) {
+	if now.Sub(s.lastIntegrityRun) < integrityCheckInterval {
+		return
+	}
+	s.lastIntegrityRun = now
+
+	issues, err := s.bot.storage.CheckIntegrity(!s.bot.dryRun)
+	if err != nil {
+		log.Printf("Failed to check data integrity: %v", err)
+		return
+	}
+	for _, issue := range issues {
+		log.Printf("Integrity: %s: found=%d repaired=%d", issue.Kind, issue.Count, issue.Repaired)
+	}
+}
+
+// handleIntegrity показывает расхождения в данных (только для админа):
+// /integrity — отчёт, /integrity fix — исправить то, что исправляется безопасно
+func (b *Bot) handleIntegrity(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	var repair bool
+	switch strings.ToLower(strings.TrimSpace(msg.CommandArguments())) {
+	case "":
+	case "fix":
+		repair = true
+	default:
+		b.sendMessage(chatID, "Использование: /integrity — отчёт, /integrity fix — исправить")
+		return
+	}
+
+	issues, err := b.storage.CheckIntegrity(repair)
+	if err != nil {
+		log.Printf("Failed to check data integrity: %v", err)
+		b.sendMessage(chatID, "Ошибка проверки данных")
+		return
+	}
+	if repair {
+		var details []string
+		for _, issue := range issues {
+			details = append(details, fmt.Sprintf("%s=%d", issue.Kind, issue.Repaired))
+		}
+		b.audit(chatID, auditIntegrity, strings.Join(details, " "))
+	}
+
+	if len(issues) == 0 {
+		b.sendMessage(chatID, "✅ Расхождений в данных нет")
+		return
+	}
+
+	var text strings.Builder
+	text.WriteString("🩺 Расхождения в данных:\n\n")
+	canRepair := false
+	for _, issue := range issues {
+		text.WriteString(fmt.Sprintf("• %s: %d", issue.Description, issue.Count))
+		switch {
+		case issue.Repaired > 0:
+			text.WriteString(fmt.Sprintf(" — исправлено %d", issue.Repaired))
+		case !issue.Repairable:
+			text.WriteString(" — только отчёт, исправлять вручную")
+		default:
+			canRepair = true
+		}
+		text.WriteString(fmt.Sprintf(" (%s)\n", issue.Kind))
+	}
+	if canRepair {
+		text.WriteString("\nИсправить: /integrity fix")
+	}
+	b.sendLongMessage(chatID, text.String())
+}
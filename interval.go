@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// intervalOptions — интервалы приёма "каждые N часов" в минутах, в порядке кнопок.
+// Все делят сутки нацело, поэтому приёмы приходятся на одни и те же часы каждый день
+// и планировщик находит их по hour:minute без времени последней отправки (см. reminderAtCond).
+// Интервалы вроде 5 или 7 часов сдвигались бы изо дня в день, поэтому их нет.
+var intervalOptions = []int{4 * 60, 6 * 60, 8 * 60, 12 * 60}
+
+// validInterval проверяет, что интервал можно выбрать кнопкой (0 — раз в день)
+func validInterval(minutes int) bool {
+	if minutes == 0 {
+		return true
+	}
+	for _, m := range intervalOptions {
+		if m == minutes {
+			return true
+		}
+	}
+	return false
+}
+
+// formatInterval описывает интервал приёма: "каждые 8 ч"
+func formatInterval(minutes int) string {
+	return fmt.Sprintf("каждые %d ч", minutes/60)
+}
+
+// DailyTimes возвращает время всех приёмов за сутки по порядку: одно для обычного
+// напоминания, каждые IntervalMinutes от Hour:Minute — для приёма каждые N часов
+func (r Reminder) DailyTimes() [][2]int {
+	if r.IntervalMinutes <= 0 {
+		return [][2]int{{r.Hour, r.Minute}}
+	}
+
+	var times [][2]int
+	start := r.Hour*60 + r.Minute
+	for m := start; m < start+24*60; m += r.IntervalMinutes {
+		times = append(times, [2]int{m % (24 * 60) / 60, m % 60})
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i][0]*60+times[i][1] < times[j][0]*60+times[j][1]
+	})
+	return times
+}
+
+// intervalRow — кнопки "каждые N часов" под выбором курса; выбранный интервал
+// отмечен, а вместо него предлагается вернуться к приёму раз в день
+func intervalRow(selected int) []tgbotapi.InlineKeyboardButton {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, m := range intervalOptions {
+		label := fmt.Sprintf("🔁 %d ч", m/60)
+		data := fmt.Sprintf("interval_%d", m)
+		if m == selected {
+			label = "✓ " + label
+			data = "interval_0"
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, data))
+	}
+	return row
+}
+
+// handleIntervalSelected меняет интервал приёма в диалоге /add и заново
+// показывает выбор курса. minutes == 0 — снова раз в день.
+func (b *Bot) handleIntervalSelected(chatID int64, messageID int, minutes int) {
+	if !validInterval(minutes) {
+		return
+	}
+
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" || p.State != StateWaitingCourse {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	p.IntervalMinutes = minutes
+	dialog := *p
+	b.mu.Unlock()
+
+	b.showCourseSelection(chatID, messageID, dialog)
+}
+
+// sharesDailyTime проверяет, приходят ли два напоминания хотя бы раз в одно время суток
+func sharesDailyTime(a, b Reminder) bool {
+	for _, ta := range a.DailyTimes() {
+		for _, tb := range b.DailyTimes() {
+			if ta == tb {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Выбор ведущего планировщика. При нескольких запущенных экземплярах
+// (например, во время выкатки новой версии) напоминания рассылает только тот,
+// кто держит advisory-блокировку PostgreSQL; остальные ждут в резерве и раз
+// в тик пытаются её взять. Блокировка привязана к соединению, поэтому при
+// падении ведущего PostgreSQL освобождает её сам, и резервный экземпляр
+// подхватывает рассылку с последнего проверенного слота из пульса.
+
+// leaderCheckTimeout — сколько ждать ответа базы при проверке блокировки
+const leaderCheckTimeout = 5 * time.Second
+
+// schedulerResumeWindow — насколько далеко назад новый ведущий досылает
+// слоты, пропущенные при смене ведущего. Более старые напоминания уже
+// неактуальны: их пора было подтверждать.
+const schedulerResumeWindow = 10 * time.Minute
+
+// instanceName возвращает имя экземпляра для пульса: хост и PID
+func instanceName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}
+
+// ensureLeader проверяет, что экземпляр ведущий, и при необходимости
+// пытается им стать. Ведущий обновляет пульс; потеряв соединение с
+// блокировкой, он уходит в резерв до следующей успешной попытки.
+func (s *Scheduler) ensureLeader() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), leaderCheckTimeout)
+	defer cancel()
+	now := s.clock.Now()
+
+	if s.lock != nil {
+		if err := s.lock.Check(ctx); err != nil {
+			log.Printf("Scheduler leadership lost by %s: %v", s.instance, err)
+			s.lock.Release(ctx)
+			s.lock = nil
+			return false
+		}
+		s.heartbeat(now)
+		return true
+	}
+
+	lock, err := s.bot.storage.TryLockScheduler(ctx)
+	if err != nil {
+		log.Printf("Failed to acquire scheduler lock: %v", err)
+		return false
+	}
+	if lock == nil {
+		if !s.standby {
+			s.standby = true
+			log.Printf("Scheduler on %s is standby: another instance is the leader", s.instance)
+		}
+		return false
+	}
+
+	s.lock = lock
+	s.standby = false
+	s.leaderSince = now
+	log.Printf("Scheduler leadership acquired by %s", s.instance)
+
+	s.resume(now)
+	s.heartbeat(now)
+	return true
+}
+
+// resignLeader освобождает блокировку при остановке, чтобы резервный
+// экземпляр стал ведущим сразу, а не после обрыва соединения
+func (s *Scheduler) resignLeader() {
+	if s.lock == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), leaderCheckTimeout)
+	defer cancel()
+	s.lock.Release(ctx)
+	s.lock = nil
+	log.Printf("Scheduler leadership released by %s", s.instance)
+}
+
+// heartbeat записывает пульс ведущего вместе с последним проверенным слотом
+func (s *Scheduler) heartbeat(now time.Time) {
+	hb := SchedulerHeartbeat{Instance: s.instance, LeaderSince: s.leaderSince, BeatAt: now}
+	if !s.lastSlot.IsZero() {
+		hb.LastSlot = &s.lastSlot
+	}
+	if err := s.bot.storage.SaveSchedulerHeartbeat(hb); err != nil {
+		log.Printf("Failed to save scheduler heartbeat: %v", err)
+	}
+}
+
+// resume досылает слоты, которые прошли между последним проверенным слотом
+// прежнего ведущего и текущей минутой, но не дальше schedulerResumeWindow.
+// Повторов не будет: уже отправленные за слот напоминания отсекает dose_log.
+func (s *Scheduler) resume(now time.Time) {
+	bot := s.bot
+	if bot.schedulerPaused() {
+		return
+	}
+
+	hb, err := bot.storage.GetSchedulerHeartbeat()
+	if err != nil {
+		log.Printf("Failed to get scheduler heartbeat: %v", err)
+		return
+	}
+	if hb == nil || hb.LastSlot == nil {
+		return
+	}
+
+	current := now.In(bot.loc).Truncate(time.Minute)
+	from := hb.LastSlot.In(bot.loc).Add(time.Minute)
+	if earliest := current.Add(-schedulerResumeWindow); from.Before(earliest) {
+		from = earliest
+	}
+
+	for slot := from; slot.Before(current); slot = slot.Add(time.Minute) {
+		if slot.Minute()%checkStep(minuteSteps[0]) != 0 {
+			continue
+		}
+		reminders := bot.GetRemindersForTime(slot)
+		s.lastSlot = slot
+		if len(reminders) == 0 {
+			continue
+		}
+		log.Printf("Resuming missed slot %s after leader change (previous leader %s)", slot.Format("15:04"), hb.Instance)
+		s.sendSlot(slot, reminders, bot.dryRun)
+	}
+}
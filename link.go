@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Связанные напоминания ("принимать вместе") объединяются в группу по group_id.
+// Подтверждение или "отложить" одного из них никогда не применяется к остальным
+// молча: бот предлагает отдельной кнопкой сделать то же для каждого связанного
+// напоминания, у которого есть неподтверждённая доза за тот же слот.
+
+// linkedPartners возвращает напоминания из той же группы, что и r
+func linkedPartners(all []Reminder, r Reminder) []Reminder {
+	if r.GroupID == nil {
+		return nil
+	}
+
+	var partners []Reminder
+	for _, other := range all {
+		if other.ID != r.ID && other.GroupID != nil && *other.GroupID == *r.GroupID {
+			partners = append(partners, other)
+		}
+	}
+	return partners
+}
+
+// groupLinked переставляет напоминания так, чтобы связанные шли подряд
+// вслед за первым из группы; остальной порядок сохраняется
+func groupLinked(reminders []Reminder) []Reminder {
+	result := make([]Reminder, 0, len(reminders))
+	placed := make(map[int]bool)
+
+	for _, r := range reminders {
+		if placed[r.ID] {
+			continue
+		}
+		result = append(result, r)
+		placed[r.ID] = true
+
+		for _, partner := range linkedPartners(reminders, r) {
+			if !placed[partner.ID] {
+				result = append(result, partner)
+				placed[partner.ID] = true
+			}
+		}
+	}
+	return result
+}
+
+// medicineNames перечисляет названия лекарств через запятую
+func medicineNames(reminders []Reminder) string {
+	names := make([]string, len(reminders))
+	for i, r := range reminders {
+		names[i] = displayName(r.Medicine)
+	}
+	return strings.Join(names, ", ")
+}
+
+// showLinkChoices предлагает выбрать напоминание, которое принимается вместе с reminderID
+func (b *Bot) showLinkChoices(chatID int64, messageID int, reminderID int) {
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+		return
+	}
+
+	var reminder *Reminder
+	for i := range reminders {
+		if reminders[i].ID == reminderID {
+			reminder = &reminders[i]
+		}
+	}
+	if reminder == nil {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	linked := make(map[int]bool)
+	for _, p := range linkedPartners(reminders, *reminder) {
+		linked[p.ID] = true
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, r := range reminders {
+		if r.ID == reminderID || linked[r.ID] {
+			continue
+		}
+		rows = append(rows, appendDataButton(nil,
+			fmt.Sprintf("🔗 %s %s", r.TimeString(), buttonName(r.Medicine)),
+			fmt.Sprintf("remlink_%d_%d", reminderID, r.ID),
+		))
+	}
+
+	text := fmt.Sprintf("🔗 С каким лекарством ты принимаешь 💊 %s?\n\nПри подтверждении или \"отложить\" одного бот предложит сделать то же для остальных.", displayName(reminder.Medicine))
+	if len(rows) == 0 {
+		text = "🔗 Нет других напоминаний, которые можно связать с этим. Добавь их через /add"
+	}
+	rows = append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", fmt.Sprintf("remlink_%d_back", reminderID)),
+	})
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleLinkReminders связывает два напоминания и возвращается к меню настроек
+func (b *Bot) handleLinkReminders(chatID int64, messageID int, reminderID, otherID int) {
+	err := b.storage.LinkReminders(chatID, reminderID, otherID)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to link reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// handleUnlinkReminder убирает напоминание из группы и возвращается к меню настроек
+func (b *Bot) handleUnlinkReminder(chatID int64, messageID int, reminderID int) {
+	err := b.storage.UnlinkReminder(chatID, reminderID)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to unlink reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	b.editReminderSettings(chatID, messageID, reminderID)
+}
+
+// offerLinkedTaken после подтверждения приёма предлагает отметить
+// связанные лекарства того же слота
+func (b *Bot) offerLinkedTaken(chatID int64, reminderID int, slot time.Time) {
+	doses := b.linkedPendingDoses(chatID, reminderID, slot)
+	if len(doses) == 0 {
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, d := range doses {
+		rows = append(rows, appendDataButton(nil,
+			fmt.Sprintf("✅ %s", buttonName(d.Medicine)),
+			fmt.Sprintf("taken_%d_%d", d.ReminderID, d.ScheduledAt.Unix()),
+		))
+	}
+
+	reply := tgbotapi.NewMessage(chatID, "🔗 Принимаешь вместе с ним — отметить?")
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// offerLinkedSnooze после "отложить" предлагает отложить на то же время
+// связанные лекарства того же слота, которые ещё не отложены
+func (b *Bot) offerLinkedSnooze(chatID int64, lang string, reminderID int, slot time.Time, minutes int) {
+	doses := b.linkedPendingDoses(chatID, reminderID, slot)
+
+	b.snoozeMu.Lock()
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, d := range doses {
+		if sn := b.snoozes[d.ReminderID]; sn != nil && sn.slot.Equal(slot) {
+			continue
+		}
+		rows = append(rows, appendDataButton(nil,
+			fmt.Sprintf("⏰ %s", buttonName(d.Medicine)),
+			fmt.Sprintf("snooze_%d_%d_%d", d.ReminderID, d.ScheduledAt.Unix(), minutes),
+		))
+	}
+	b.snoozeMu.Unlock()
+
+	if len(rows) == 0 {
+		return
+	}
+
+	reply := tgbotapi.NewMessage(chatID, fmt.Sprintf("🔗 Отложить на %s и связанные лекарства?", formatDuration(lang, minutes)))
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// linkedPendingDoses возвращает неподтверждённые дозы связанных напоминаний за слот
+func (b *Bot) linkedPendingDoses(chatID int64, reminderID int, slot time.Time) []PendingDose {
+	if slot.IsZero() {
+		// Старая кнопка без времени слота — не знаем, какие дозы предлагать
+		return nil
+	}
+
+	doses, err := b.storage.GetLinkedPendingDoses(chatID, reminderID, slot)
+	if err != nil {
+		log.Printf("Failed to get linked pending doses: %v", err)
+		return nil
+	}
+	return doses
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maintenanceText — ответ на изменяющие действия в режиме обслуживания
+const maintenanceText = "🛠 Идут технические работы — изменения временно недоступны. Посмотреть напоминания можно в /list"
+
+// maintenanceCommands — команды, разрешённые в режиме обслуживания: только чтение.
+// /user и /audit пишут лишь журнал действий администратора, /maintenance — выход из режима.
+var maintenanceCommands = map[string]bool{
+	"list":        true,
+	"report":      true,
+	"mystats":     true,
+	"adherence":   true,
+	"history":     true,
+	"stats":       true,
+	"user":        true,
+	"audit":       true,
+	"maintenance": true,
+}
+
+// maintenanceCallback сообщает, разрешена ли кнопка в режиме обслуживания:
+// листание расписания, меню напоминания, отчёт и отмена диалога ничего не меняют
+func maintenanceCallback(data string) bool {
+	return data == "preview" || data == "cancel" ||
+		strings.HasPrefix(data, "preview_") ||
+		strings.HasPrefix(data, "rem_") ||
+		strings.HasPrefix(data, "report_")
+}
+
+// maintenanceMessage сообщает, разрешено ли сообщение в режиме обслуживания:
+// команды чтения и кнопка "Мои напоминания". Шаги диалогов, заметки к дозам,
+// ответы "принял", геопозиция и данные Web App блокируются.
+func maintenanceMessage(msg *tgbotapi.Message, webApp bool) bool {
+	if webApp || msg.Location != nil {
+		return false
+	}
+	if msg.IsCommand() {
+		return maintenanceCommands[msg.Command()]
+	}
+	return strings.Contains(msg.Text, "напоминания")
+}
+
+// inMaintenance — включён ли режим обслуживания
+func (b *Bot) inMaintenance() bool {
+	return b.maintenance.Load()
+}
+
+// schedulerPaused — режим обслуживания включён вместе с остановкой планировщика
+func (b *Bot) schedulerPaused() bool {
+	return b.maintenance.Load() && b.maintenancePauseScheduler.Load()
+}
+
+// handleMaintenance включает и выключает режим обслуживания (только для админа):
+// /maintenance — текущее состояние, /maintenance on [scheduler] — включить
+// (scheduler — ещё и остановить планировщик), /maintenance off — выключить
+func (b *Bot) handleMaintenance(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	args := strings.Fields(strings.ToLower(msg.CommandArguments()))
+	if len(args) == 0 {
+		b.sendMessage(chatID, b.maintenanceStatus())
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		pause := len(args) > 1 && args[1] == "scheduler"
+		b.maintenancePauseScheduler.Store(pause)
+		b.maintenance.Store(true)
+	case "off":
+		b.maintenance.Store(false)
+		b.maintenancePauseScheduler.Store(false)
+	default:
+		b.sendMessage(chatID, "Использование: /maintenance on [scheduler] или /maintenance off")
+		return
+	}
+
+	log.Printf("[MAINTENANCE] %s by admin %d", strings.Join(args, " "), chatID)
+	b.audit(chatID, auditMaintenance, strings.Join(args, " "))
+	b.sendMessage(chatID, b.maintenanceStatus())
+}
+
+// maintenanceStatus описывает текущее состояние режима обслуживания
+func (b *Bot) maintenanceStatus() string {
+	switch {
+	case b.schedulerPaused():
+		return "🛠 Режим обслуживания включён, планировщик остановлен: напоминания не отправляются"
+	case b.inMaintenance():
+		return "🛠 Режим обслуживания включён: изменения недоступны, напоминания отправляются"
+	default:
+		return "✅ Режим обслуживания выключен"
+	}
+}
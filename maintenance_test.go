@@ -0,0 +1,92 @@
+package main
+
+import "testing"
+
+func TestMaintenanceMessage(t *testing.T) {
+	tests := []struct {
+		text  string
+		allow bool
+	}{
+		{"/list", true},
+		{"/list неделя", true},
+		{"/report", true},
+		{"/stats", true},
+		{"/mystats", true},
+		{"/adherence", true},
+		{"/history", true},
+		{"/maintenance off", true},
+		{"📋 Мои напоминания", true},
+		{"/add", false},
+		{"/clear", false},
+		{"/stop", false},
+		{"/import", false},
+		{"/shift +1h", false},
+		{"/donate", false},
+		{"Аспирин", false},
+		{"принял", false},
+		{addButtonText, false},
+	}
+	for _, tt := range tests {
+		msg := textUpdate(1, tt.text).Message
+		if got := maintenanceMessage(msg, false); got != tt.allow {
+			t.Errorf("maintenanceMessage(%q) = %v, want %v", tt.text, got, tt.allow)
+		}
+	}
+
+	if maintenanceMessage(textUpdate(1, "/list").Message, true) {
+		t.Errorf("maintenanceMessage(Web App data) = true, want false")
+	}
+}
+
+func TestMaintenanceCallback(t *testing.T) {
+	tests := []struct {
+		data  string
+		allow bool
+	}{
+		{"preview", true},
+		{"preview_3", true},
+		{"rem_12", true},
+		{"report_30", true},
+		{"cancel", true},
+		{"taken_12_1760000000", false},
+		{"snooze_12_1760000000_15", false},
+		{"skip_12_1760000000", false},
+		{"del_12", false},
+		{"rempause_12", false},
+		{"remcourse_12", false},
+		{"clear_confirm", false},
+		{"hour_8", false},
+		{"stars_50", false},
+	}
+	for _, tt := range tests {
+		if got := maintenanceCallback(tt.data); got != tt.allow {
+			t.Errorf("maintenanceCallback(%q) = %v, want %v", tt.data, got, tt.allow)
+		}
+	}
+}
+
+// TestMaintenanceReadsWork проверяет путь через handleUpdate: чтение доходит
+// до обработчика, изменение получает ответ о технических работах.
+func TestMaintenanceReadsWork(t *testing.T) {
+	b, tg, _ := newTestBot(t)
+	b.maintenance.Store(true)
+
+	for _, text := range []string{"/list", "/report", "/stats"} {
+		before := len(tg.sent())
+		b.handleUpdate(textUpdate(1, text))
+		for _, sent := range tg.sent()[before:] {
+			if sent == maintenanceText {
+				t.Errorf("%s in maintenance answered %q, want the command to run", text, sent)
+			}
+		}
+	}
+
+	before := len(tg.sent())
+	b.handleUpdate(textUpdate(1, "/add"))
+	if got := tg.sent()[before:]; len(got) != 1 || got[0] != maintenanceText {
+		t.Errorf("/add in maintenance sent %q, want maintenance text", got)
+	}
+	if _, ok := b.pendingSnapshot(1); ok {
+		t.Errorf("/add in maintenance started a dialog")
+	}
+}
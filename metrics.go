@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Метрики отдаются на /metrics в текстовом формате Prometheus.
+// Запись — только атомарные операции без блокировок и аллокаций,
+// поэтому не замедляет рассылку.
+
+// counter — монотонно растущий счётчик
+type counter struct {
+	v atomic.Uint64
+}
+
+func (c *counter) Inc() { c.v.Add(1) }
+
+// histogram — распределение длительностей по фиксированным корзинам
+type histogram struct {
+	bounds []float64 // верхние границы корзин в секундах
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sumNs  atomic.Int64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds))}
+}
+
+// Observe учитывает одно измерение
+func (h *histogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bound := range h.bounds {
+		if seconds <= bound {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+	h.count.Add(1)
+	h.sumNs.Add(int64(d))
+}
+
+// labeledCounters — счётчики с одной меткой; набор значений метки небольшой и заранее известный
+type labeledCounters struct {
+	mu     sync.Mutex
+	values map[string]*counter
+}
+
+func (l *labeledCounters) With(value string) *counter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.values == nil {
+		l.values = make(map[string]*counter)
+	}
+	c := l.values[value]
+	if c == nil {
+		c = &counter{}
+		l.values[value] = c
+	}
+	return c
+}
+
+// Метрики планировщика
+var (
+	metricSendsTotal    counter         // все попытки отправки напоминаний
+	metricSendsOK       counter         // успешные отправки
+	metricSendFailures  labeledCounters // неудачные отправки по типу ошибки
+	metricSendDuration  = newHistogram(0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10)
+	metricSlotDuration  = newHistogram(0.1, 0.5, 1, 5, 10, 30, 60, 120)
+	metricSlotsTotal    counter // обработанные слоты с напоминаниями
+	metricSlotSkipped   counter // напоминания, пропущенные как уже отправленные
+	metricSlotReminders counter // напоминания во всех слотах
+)
+
+// sendErrorType классифицирует ошибку отправки для метрик
+func sendErrorType(err error) string {
+	switch code := apiErrorCode(err); {
+	case isTimeout(err):
+		return "timeout"
+	case code == 429:
+		return "rate_limited"
+	case code == 403:
+		return "blocked"
+	case code != 0:
+		return "api"
+	default:
+		return "network"
+	}
+}
+
+// recordSend учитывает результат и длительность одной отправки
+func recordSend(err error, d time.Duration) {
+	metricSendsTotal.Inc()
+	metricSendDuration.Observe(d)
+	if err == nil {
+		metricSendsOK.Inc()
+		return
+	}
+	metricSendFailures.With(sendErrorType(err)).Inc()
+}
+
+// metricsHandler отдаёт метрики в текстовом формате Prometheus
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeCounter(w, "medbot_reminder_sends_total", "Reminder send attempts.", &metricSendsTotal)
+	writeCounter(w, "medbot_reminder_sends_success_total", "Reminders sent successfully.", &metricSendsOK)
+
+	fmt.Fprintf(w, "# HELP medbot_reminder_send_failures_total Failed reminder sends by error type.\n")
+	fmt.Fprintf(w, "# TYPE medbot_reminder_send_failures_total counter\n")
+	metricSendFailures.mu.Lock()
+	types := make([]string, 0, len(metricSendFailures.values))
+	for t := range metricSendFailures.values {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "medbot_reminder_send_failures_total{type=%q} %d\n", t, metricSendFailures.values[t].v.Load())
+	}
+	metricSendFailures.mu.Unlock()
+
+	writeHistogram(w, "medbot_reminder_send_duration_seconds", "Telegram API latency of a reminder send.", metricSendDuration)
+
+	writeCounter(w, "medbot_scheduler_slots_total", "Scheduler slots that had reminders to send.", &metricSlotsTotal)
+	writeCounter(w, "medbot_scheduler_slot_reminders_total", "Reminders due across all slots.", &metricSlotReminders)
+	writeCounter(w, "medbot_scheduler_slot_skipped_total", "Reminders skipped because the slot was already sent.", &metricSlotSkipped)
+	writeHistogram(w, "medbot_scheduler_slot_duration_seconds", "Time to process one scheduler slot.", metricSlotDuration)
+}
+
+func writeCounter(w io.Writer, name, help string, c *counter) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, c.v.Load())
+}
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	count := h.count.Load()
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, time.Duration(h.sumNs.Load()).Seconds())
+	fmt.Fprintf(w, "%s_count %d\n", name, count)
+}
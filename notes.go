@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Заметка к приёму ("принял, но с опозданием из-за встречи") — ответ на сообщение
+// с подтверждением. Заметка необязательна: ответы на другие сообщения
+// обрабатываются как обычно.
+
+// doseNoteWindow — сколько времени после подтверждения можно добавить заметку
+const doseNoteWindow = 24 * time.Hour
+
+// maxDoseNoteLength — максимальная длина заметки в символах
+const maxDoseNoteLength = 500
+
+// doseNoteHint — подсказка под подтверждением приёма
+const doseNoteHint = "\n\n💬 Ответь на это сообщение, чтобы добавить заметку"
+
+// rememberConfirmMessage связывает сообщение с подтверждением с дозой.
+// Для кнопок старого формата слот неизвестен — заметку к ним добавить нельзя.
+func (b *Bot) rememberConfirmMessage(chatID int64, reminderID int, slot time.Time, messageID int) {
+	if slot.IsZero() {
+		return
+	}
+	if err := b.storage.SetDoseConfirmMessage(chatID, reminderID, slot, messageID); err != nil {
+		log.Printf("Failed to save confirm message: %v", err)
+	}
+}
+
+// handleDoseNote сохраняет ответ на подтверждение приёма как заметку к дозе.
+// Возвращает false, если сообщение не ответ на недавнее подтверждение.
+func (b *Bot) handleDoseNote(msg *tgbotapi.Message) bool {
+	chatID := msg.Chat.ID
+	note := strings.TrimSpace(msg.Text)
+	if msg.ReplyToMessage == nil || note == "" {
+		return false
+	}
+
+	if utf8.RuneCountInString(note) > maxDoseNoteLength {
+		// Слишком длинную заметку обрезаем, а не отклоняем: ответ ничего не блокирует
+		note = string([]rune(note)[:maxDoseNoteLength])
+	}
+
+	medicine, ok, err := b.storage.SetDoseNote(chatID, msg.ReplyToMessage.MessageID, note, b.now().Add(-doseNoteWindow))
+	if err != nil {
+		log.Printf("Failed to save dose note: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+
+	b.sendMessage(chatID, fmt.Sprintf("📝 Заметка к приёму 💊 %s сохранена", displayName(medicine)))
+	return true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Повтор неподтверждённых напоминаний: через сколько минут после отправки
+// и сколько раз напомнить снова, если "Принял" так и не нажали
+const (
+	defaultNudgeMinutes = 30
+	maxNudgeMinutes     = 180
+	maxNudges           = 2
+)
+
+// parseNudgeMinutes разбирает NUDGE_MINUTES — интервал повтора неподтверждённого
+// напоминания в минутах; 0 — не повторять
+func parseNudgeMinutes(value string) int {
+	if value == "" {
+		return defaultNudgeMinutes
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 0 || n > maxNudgeMinutes {
+		log.Printf("Ignoring invalid NUDGE_MINUTES %q", value)
+		return defaultNudgeMinutes
+	}
+	return n
+}
+
+// sendNudges раз в минуту повторяет напоминания, приём по которым не подтверждён
+// за nudgeMinutes, — не больше maxNudges раз за дозу. Какие дозы ждут подтверждения
+// и сколько повторов ушло, хранится в dose_log, поэтому повторы переживают
+// перезапуск, а подтверждение, пропуск или "отложить" их прекращают.
+// Повтор не пишется в журнал отдельной дозой и не двигает курс: его кнопки
+// подтверждают ту же дозу, что и исходное напоминание.
+func (s *Scheduler) sendNudges(now time.Time) {
+	bot := s.bot
+	if bot.nudgeMinutes == 0 || bot.dryRun {
+		return
+	}
+
+	minute := now.Format("15:04")
+	if minute == s.lastNudge {
+		return
+	}
+	s.lastNudge = minute
+
+	nudges, err := bot.storage.TakeDueNudges(now, bot.nudgeMinutes, maxNudges)
+	if err != nil {
+		log.Printf("Failed to get due nudges: %v", err)
+		return
+	}
+	for _, n := range nudges {
+		bot.sendLimiter.Wait()
+		if err := bot.sendNudge(n.ChatID, n.Language, n.Reminder, n.ScheduledAt); err != nil {
+			log.Printf("Failed to send nudge to %d: %v", n.ChatID, err)
+		}
+	}
+	if len(nudges) > 0 {
+		log.Printf("Nudges %s done: reminders=%d", minute, len(nudges))
+	}
+}
+
+// sendNudge повторяет напоминание о дозе slot с теми же кнопками
+func (b *Bot) sendNudge(chatID int64, lang string, r Reminder, slot time.Time) error {
+	l := b.userLocale(chatID, lang)
+	msg := tgbotapi.NewMessage(chatID, T(lang, "reminder.nudge", doseName(r), formatClock(l, slot)))
+	msg.ReplyMarkup = b.reminderKeyboard(lang, r, slot)
+	_, err := b.api.Send(msg)
+	b.deactivateIfBlocked(chatID, err)
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Допустимое время предупреждения до напоминания, в минутах
+const (
+	minPreAlertMinutes = 1
+	maxPreAlertMinutes = 60
+)
+
+// handlePreAlert настраивает предупреждение перед напоминаниями: /prealert 10, /prealert off
+func (b *Bot) handlePreAlert(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	arg := strings.ToLower(strings.TrimSpace(msg.CommandArguments()))
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+
+	if arg == "" {
+		minutes, err := b.storage.GetPreAlertMinutes(chatID)
+		if err != nil {
+			log.Printf("Failed to get pre-alert minutes: %v", err)
+		}
+		text := "🔔 Предупреждение перед напоминанием выключено"
+		if minutes > 0 {
+			text = fmt.Sprintf("🔔 Предупреждаю за %s до напоминания", formatDuration(defaultLocale, minutes))
+		}
+		b.sendMessage(chatID, text+fmt.Sprintf("\n\nУкажи, за сколько минут предупреждать (от %d до %d), например: /prealert 10\n"+
+			"Выключить: /prealert off\n\nПредупреждение не нужно подтверждать и не приходит во время сна (/sleep — /wake).",
+			minPreAlertMinutes, maxPreAlertMinutes))
+		return
+	}
+
+	minutes := 0
+	if arg != "off" && arg != "выкл" {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSuffix(arg, "м"), "m"))
+		if err != nil || n < minPreAlertMinutes || n > maxPreAlertMinutes {
+			b.sendMessage(chatID, fmt.Sprintf("Укажи число минут от %d до %d, например: /prealert 10\nВыключить: /prealert off",
+				minPreAlertMinutes, maxPreAlertMinutes))
+			return
+		}
+		minutes = n
+	}
+
+	if err := b.storage.SetPreAlertMinutes(chatID, minutes); err != nil {
+		log.Printf("Failed to set pre-alert minutes: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+
+	if minutes == 0 {
+		b.sendMessage(chatID, "✅ Предупреждение перед напоминанием выключено")
+		return
+	}
+	b.sendMessage(chatID, fmt.Sprintf("✅ Буду предупреждать за %s до каждого напоминания", formatDuration(defaultLocale, minutes)))
+}
+
+// sendPreAlert отправляет предупреждение о скорых напоминаниях — без кнопок,
+// чтобы приём не засчитался раньше основного напоминания
+func (b *Bot) sendPreAlert(chatID int64, lang string, alert *PreAlert) {
+	names := make([]string, 0, len(alert.Reminders))
+	for _, r := range alert.Reminders {
+		names = append(names, "💊 "+displayName(r.Medicine))
+	}
+
+	text := T(lang, "reminder.preAlert", formatDuration(lang, alert.Minutes), strings.Join(names, ", "))
+	if _, err := b.api.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+		log.Printf("Failed to send pre-alert to %d: %v", chatID, err)
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxImportReminders — сколько напоминаний можно создать одним назначением
+const maxImportReminders = 20
+
+// importHelp возвращает подсказку о формате назначения для /import
+// с минутами, кратными шагу пользователя
+func importHelp(minuteStep int) string {
+	return "По строке на лекарство: название, время приёма и длительность курса, например:\n\n" +
+		"Аспирин 08:00 30 дней\n" +
+		"Витамин D 09:00 90 дней\n" +
+		"Омепразол 07:30, 19:30 — 2 недели\n" +
+		"Эутирокс в 06:00 постоянно\n\n" +
+		"Время — ЧЧ:ММ (минуты " + minuteStepHint(minuteStep) + "), несколько времён через запятую. " +
+		"Курс — в днях, неделях или месяцах; без курса напоминание бессрочное."
+}
+
+var (
+	// prescriptionTimeRe — время приёма: 08:00, 8.30
+	prescriptionTimeRe = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+	// prescriptionCourseRe — курс одним словом: 30д, 14дней, 2нед, 1мес
+	prescriptionCourseRe = regexp.MustCompile(`^(\d+)([а-яa-z]+)\.?$`)
+	// prescriptionNumberingRe — нумерация строки: 1. или 1)
+	prescriptionNumberingRe = regexp.MustCompile(`^\d+[.)]$`)
+)
+
+// prescriptionError — строка назначения, которую не удалось разобрать
+type prescriptionError struct {
+	Line   int // номер строки с 1
+	Reason string
+}
+
+// courseUnitDays возвращает число дней в единице длительности курса
+// ("дней", "нед", "мес", "days"...), 0 — это не единица курса
+func courseUnitDays(unit string) int {
+	unit = strings.TrimSuffix(strings.ToLower(unit), ".")
+	switch {
+	case unit == "д" || unit == "d" || strings.HasPrefix(unit, "дн") || strings.HasPrefix(unit, "ден") || strings.HasPrefix(unit, "day"):
+		return 1
+	case strings.HasPrefix(unit, "нед") || strings.HasPrefix(unit, "week"):
+		return 7
+	case strings.HasPrefix(unit, "мес") || strings.HasPrefix(unit, "month"):
+		return 30
+	}
+	return 0
+}
+
+// parsePrescription разбирает назначение по строкам: на каждое время приёма —
+// отдельное напоминание с курсом по календарю. Пустые строки пропускаются.
+// Нумерация и маркеры списка, слова "в", "курс" и тире не входят в название.
+// Минуты времени приёма должны быть кратны minuteStep.
+// Если хоть одна строка не разобрана, возвращаются все ошибки с номерами строк.
+func parsePrescription(text string, maxCourseDays, minuteStep int) ([]Reminder, []prescriptionError) {
+	var reminders []Reminder
+	var errs []prescriptionError
+
+	for i, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(strings.NewReplacer(",", " ", ";", " ").Replace(line))
+		if len(fields) == 0 {
+			continue
+		}
+		fail := func(format string, args ...any) {
+			errs = append(errs, prescriptionError{Line: i + 1, Reason: fmt.Sprintf(format, args...)})
+		}
+
+		var name []string
+		var times [][2]int
+		courseDays, courseSet := 0, false
+		badTime := ""
+
+		for j := 0; j < len(fields); j++ {
+			field := fields[j]
+			lower := strings.ToLower(field)
+
+			if m := prescriptionTimeRe.FindStringSubmatch(field); m != nil {
+				hour, _ := strconv.Atoi(m[1])
+				minute, _ := strconv.Atoi(m[2])
+				switch {
+				case hour <= 23 && minute <= 59 && minute%minuteStep == 0:
+					times = append(times, [2]int{hour, minute})
+					continue
+				case strings.Contains(field, ":"):
+					badTime = field
+					continue
+				}
+				// 0.25 с точкой — скорее дозировка, чем время: остаётся в названии
+			}
+
+			// Курс: "30 дней", "2 недели", "30д"
+			if n, err := strconv.Atoi(field); err == nil && j+1 < len(fields) && courseUnitDays(fields[j+1]) > 0 {
+				courseDays, courseSet = n*courseUnitDays(fields[j+1]), true
+				j++
+				continue
+			}
+			if m := prescriptionCourseRe.FindStringSubmatch(lower); m != nil && courseUnitDays(m[2]) > 0 {
+				n, _ := strconv.Atoi(m[1])
+				courseDays, courseSet = n*courseUnitDays(m[2]), true
+				continue
+			}
+
+			switch lower {
+			case "бессрочно", "постоянно", "∞":
+				courseDays, courseSet = 0, true
+				continue
+			case "в", "at", "курс", "-", "—", "–", "•", "*":
+				continue
+			}
+			if len(name) == 0 && prescriptionNumberingRe.MatchString(field) {
+				continue
+			}
+			name = append(name, field)
+		}
+
+		medicine := strings.Trim(strings.Join(name, " "), " -—–:")
+		switch {
+		case badTime != "":
+			fail("время %s не подходит — нужно ЧЧ:ММ, минуты %s", badTime, minuteStepHint(minuteStep))
+		case len(times) == 0:
+			fail("не нашёл время приёма (ЧЧ:ММ)")
+		case medicine == "":
+			fail("не нашёл название лекарства")
+		case checkMedicine(medicine) != nil:
+			fail("слишком длинное название (макс %d символов)", maxMedicineLength)
+		case courseSet && courseDays > maxCourseDays:
+			fail("курс %d дней — больше максимума %d", courseDays, maxCourseDays)
+		default:
+			for _, t := range times {
+				reminders = append(reminders, Reminder{
+					Medicine:   medicine,
+					Hour:       t[0],
+					Minute:     t[1],
+					CourseDays: courseDays,
+					CourseType: CourseByDays,
+				})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return reminders, nil
+}
+
+// handleImport начинает импорт назначения: /import с текстом сразу разбирает его,
+// без текста — просит вставить назначение следующим сообщением
+func (b *Bot) handleImport(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+
+	if text := strings.TrimSpace(msg.CommandArguments()); text != "" {
+		b.previewPrescription(chatID, text)
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{State: StateWaitingImport, StartedAt: b.now()}
+	b.mu.Unlock()
+
+	reply := tgbotapi.NewMessage(chatID, "📋 Вставь назначение врача одним сообщением.\n\n"+importHelp(b.minuteStep(chatID)))
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// handleImportInput принимает назначение, вставленное после /import
+func (b *Bot) handleImportInput(msg *tgbotapi.Message) {
+	b.previewPrescription(msg.Chat.ID, msg.Text)
+}
+
+// previewPrescription разбирает назначение и показывает, какие напоминания
+// будут созданы. Ошибки перечисляются с номерами строк — назначение можно
+// исправить и прислать заново, не начиная /import сначала.
+func (b *Bot) previewPrescription(chatID int64, text string) {
+	step := b.minuteStep(chatID)
+	reminders, errs := parsePrescription(text, b.storage.MaxCourseDays(), step)
+	if len(errs) == 0 && len(reminders) > maxImportReminders {
+		errs = []prescriptionError{{Reason: fmt.Sprintf("слишком много напоминаний: %d, за раз можно до %d", len(reminders), maxImportReminders)}}
+	}
+	if len(errs) == 0 && len(reminders) == 0 {
+		errs = []prescriptionError{{Reason: "назначение пустое"}}
+	}
+
+	if len(errs) > 0 {
+		b.mu.Lock()
+		b.pending[chatID] = &PendingReminder{State: StateWaitingImport, StartedAt: b.now()}
+		b.mu.Unlock()
+
+		var reply strings.Builder
+		reply.WriteString("⚠️ Не получилось разобрать назначение:\n\n")
+		for _, e := range errs {
+			if e.Line > 0 {
+				reply.WriteString(fmt.Sprintf("Строка %d: %s\n", e.Line, e.Reason))
+			} else {
+				reply.WriteString(e.Reason + "\n")
+			}
+		}
+		reply.WriteString("\nИсправь и пришли назначение целиком ещё раз (любая команда — выйти).\n\n" + importHelp(step))
+		b.sendLongMessage(chatID, reply.String())
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{State: StateWaitingImportConfirm, StartedAt: b.now(), Import: reminders}
+	b.mu.Unlock()
+
+	l := b.userLocale(chatID, defaultLocale)
+	var preview strings.Builder
+	preview.WriteString(fmt.Sprintf("📋 Будет создано напоминаний: %d\n\n", len(reminders)))
+	for _, r := range reminders {
+		preview.WriteString(fmt.Sprintf("⏰ %s — 💊 %s — 📅 %s\n",
+			formatTime(l, r.Hour, r.Minute), r.Medicine, courseLengthString(r.CourseDays, r.CourseType)))
+	}
+	preview.WriteString("\nКурс считается по календарю: закончится через указанное число дней, даже если были пропуски. Создать?")
+
+	reply := tgbotapi.NewMessage(chatID, preview.String())
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Создать", "import_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel"),
+		),
+	)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// handleImportConfirm создаёт все напоминания из назначения одной транзакцией
+func (b *Bot) handleImportConfirm(chatID int64, messageID int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.State != StateWaitingImportConfirm || len(p.Import) == 0 {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /import")
+		return
+	}
+	reminders := p.Import
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+
+	now := b.now().In(b.userLoc(chatID))
+	for i := range reminders {
+		reminders[i].StartsAt = firstOccurrence(now, reminders[i].Hour, reminders[i].Minute)
+	}
+
+	ids, err := b.storage.AddReminders(chatID, reminders, ReminderSourceImport)
+	if err != nil {
+		log.Printf("Failed to import reminders: %v", err)
+		var outOfRange *CourseDaysRangeError
+		if errors.As(err, &outOfRange) {
+			b.sendMessage(chatID, fmt.Sprintf("Курс должен быть не длиннее %d дней. Исправь назначение: /import", outOfRange.Max))
+			return
+		}
+		b.sendMessage(chatID, "Ошибка сохранения. Ни одно напоминание не создано — попробуй снова: /import")
+		return
+	}
+
+	b.deleteMessage(chatID, messageID)
+	b.sendMessage(chatID, fmt.Sprintf("✅ Добавлено напоминаний: %d\n\nИспользуй /list чтобы увидеть все напоминания", len(ids)))
+	for i, id := range ids {
+		reminders[i].ID = id
+		b.warnReminderConflicts(chatID, reminders[i])
+	}
+	b.reactivateAfterAdd(chatID)
+}
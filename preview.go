@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// previewDays — на сколько дней вперёд показывает расписание /list неделя
+const previewDays = 7
+
+// previewWeekdays — дни недели для заголовка страницы, с воскресенья, как time.Weekday
+var previewWeekdays = [...]string{"воскресенье", "понедельник", "вторник", "среда", "четверг", "пятница", "суббота"}
+
+// occurrence — один запланированный приём в расписании на несколько дней
+type occurrence struct {
+	At       time.Time
+	Reminder Reminder
+}
+
+// reminderOccurrences возвращает приёмы из [from, to), которые отправит планировщик
+// при текущих настройках: без приостановленных и завершённых напоминаний, разовые —
+// только в свою дату, не раньше первого приёма и не дальше конца курса
+// (оставшиеся дозы курса по приёмам или последний день курса по дням).
+// Время считается в своём поясе напоминания, иначе в loc. Дни отпуска
+// (даты vacationFrom..vacationUntil включительно, nil — без отпуска) пропускаются.
+func reminderOccurrences(reminders []Reminder, from, to, now time.Time, loc *time.Location, vacationFrom, vacationUntil *time.Time) []occurrence {
+	var result []occurrence
+	for _, r := range reminders {
+		if !r.IsRunnable(now) {
+			continue
+		}
+
+		rloc := loc
+		if r.Timezone != "" {
+			if l, err := loadTimezone(r.Timezone); err == nil {
+				rloc = l
+			}
+		}
+
+		// Курс по приёмам ограничен оставшимися дозами, курс по дням — последним днём
+		remaining := -1
+		var courseEnd time.Time
+		switch {
+		case r.CourseDays > 0 && r.CourseType == CourseByDays:
+			courseEnd = r.StartsAt.Add(time.Duration(r.CourseDays) * 24 * time.Hour)
+		case r.CourseDays > 0:
+			remaining = r.CourseDays - r.DosesTaken
+		}
+
+		// Начинаем с предыдущего дня: в поясе напоминания он может ещё не закончиться
+		day := from.In(rloc).AddDate(0, 0, -1)
+	days:
+		for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+			for _, t := range r.DailyTimes() {
+				at := time.Date(day.Year(), day.Month(), day.Day(), t[0], t[1], 0, 0, rloc)
+				if at.Before(from) || !at.Before(to) || at.Before(r.StartsAt) {
+					continue
+				}
+				if !courseEnd.IsZero() && !at.Before(courseEnd) {
+					break days
+				}
+				date := calendarDate(at)
+				if r.FireDate != nil && !calendarDate(*r.FireDate).Equal(date) {
+					continue
+				}
+				if r.FireDate == nil && !r.OnWeekday(at.Weekday()) {
+					continue
+				}
+				if vacationFrom != nil && vacationUntil != nil &&
+					!date.Before(calendarDate(*vacationFrom)) && !date.After(calendarDate(*vacationUntil)) {
+					continue
+				}
+				// Прошедшие сегодня приёмы показываем, но дозы курса расходуют только будущие
+				if at.After(now) {
+					if remaining == 0 {
+						break days
+					}
+					if remaining > 0 {
+						remaining--
+					}
+				}
+				result = append(result, occurrence{At: at, Reminder: r})
+			}
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].At.Before(result[j].At) })
+	return result
+}
+
+// nextDoseHorizon — как далеко ищется ближайший приём: разовые напоминания
+// выбираются не дальше недели вперёд, ежедневные приходят каждый день
+const nextDoseHorizon = 8
+
+// nextDose возвращает ближайший приём напоминания не раньше now.
+// false — в ближайшие дни приёмов нет (пауза, курс закончился, разовая дата прошла).
+// Отпуск не учитывается: порядок в списке от него не зависит.
+func nextDose(r Reminder, now time.Time, loc *time.Location) (time.Time, bool) {
+	occurrences := reminderOccurrences([]Reminder{r}, now, now.AddDate(0, 0, nextDoseHorizon), now, loc, nil, nil)
+	if len(occurrences) == 0 {
+		return time.Time{}, false
+	}
+	return occurrences[0].At, true
+}
+
+// sortByNextDose упорядочивает напоминания по ближайшему приёму после now:
+// в 23:00 напоминание на 08:00 идёт раньше напоминания на 22:00.
+// Напоминания без ближайшего приёма идут в конце в прежнем порядке.
+func sortByNextDose(reminders []Reminder, now time.Time, loc *time.Location) {
+	next := make(map[int]time.Time, len(reminders))
+	for _, r := range reminders {
+		if at, ok := nextDose(r, now, loc); ok {
+			next[r.ID] = at
+		}
+	}
+
+	sort.SliceStable(reminders, func(i, j int) bool {
+		a, aok := next[reminders[i].ID]
+		b, bok := next[reminders[j].ID]
+		if aok != bok {
+			return aok
+		}
+		return aok && a.Before(b)
+	})
+}
+
+// calendarDate отбрасывает время и часовой пояс: колонки DATE приходят как полночь UTC
+func calendarDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// handlePreview показывает расписание на день page (0 — сегодня) из ближайшей недели.
+// messageID == 0 — отправить новое сообщение, иначе отредактировать страницу.
+func (b *Bot) handlePreview(chatID int64, messageID int, page int) {
+	if page < 0 || page >= previewDays {
+		return
+	}
+
+	user, err := b.storage.GetUser(chatID)
+	if err != nil || user == nil {
+		log.Printf("Failed to get user %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+	reminders, err := b.storage.GetReminders(chatID)
+	if err != nil {
+		log.Printf("Failed to get reminders: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+
+	l := b.userLocale(chatID, defaultLocale)
+	now := b.now().In(l.Loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Loc)
+	from := today.AddDate(0, 0, page)
+	to := from.AddDate(0, 0, 1)
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("🗓 %s, %s — день %d из %d (часовой пояс %s)\n\n",
+		formatShortDate(l, from), previewWeekdays[from.Weekday()], page+1, previewDays, zoneLabel(l.Loc, from)))
+
+	occurrences := reminderOccurrences(reminders, from, to, now, l.Loc, user.VacationFrom, user.VacationUntil)
+	switch {
+	case !user.Active:
+		text.WriteString("🔕 Напоминания выключены через /stop — включить их можно кнопкой «▶️ Включить»\n\n")
+	case len(occurrences) == 0:
+		text.WriteString("Напоминаний нет\n")
+	}
+	for _, o := range occurrences {
+		mark := "⏰"
+		if o.At.Before(now) {
+			mark = "✓"
+		}
+		text.WriteString(fmt.Sprintf("%s %s — 💊 %s\n", mark, formatClock(l, o.At), displayName(o.Reminder.Medicine)))
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		nav = appendDataButton(nav, "◀️", "preview_"+strconv.Itoa(page-1))
+	}
+	if page < previewDays-1 {
+		nav = appendDataButton(nav, "▶️", "preview_"+strconv.Itoa(page+1))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(nav)
+
+	if messageID == 0 {
+		msg := tgbotapi.NewMessage(chatID, text.String())
+		msg.ReplyMarkup = keyboard
+		if _, err := b.api.Send(msg); err != nil {
+			log.Printf("Failed to send message: %v", err)
+		}
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text.String())
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
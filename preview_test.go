@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSortByNextDose проверяет порядок /list ближайшие через полночь: в 23:50
+// напоминание на 00:10 идёт раньше 08:00, а на 22:00 — после них, завтра.
+func TestSortByNextDose(t *testing.T) {
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	// Понедельник
+	now := time.Date(2026, 3, 2, 23, 50, 0, 0, loc)
+	started := now.AddDate(0, 0, -1)
+	past := time.Date(2026, 2, 20, 0, 0, 0, 0, time.UTC)
+
+	reminders := []Reminder{
+		{ID: 1, Hour: 8, Minute: 0, StartsAt: started},
+		{ID: 2, Hour: 0, Minute: 10, StartsAt: started},
+		{ID: 3, Hour: 23, Minute: 55, StartsAt: started},
+		{ID: 4, Hour: 22, Minute: 0, StartsAt: started},
+		{ID: 5, Hour: 0, Minute: 5, StartsAt: started, Paused: true},
+		{ID: 6, Hour: 0, Minute: 1, StartsAt: started, WeekdayMask: 1 << weekdayBit(time.Wednesday)},
+		{ID: 7, Hour: 3, Minute: 0, StartsAt: started, IntervalMinutes: 6 * 60},
+		{ID: 8, Hour: 0, Minute: 0, StartsAt: started, FireDate: &past},
+		{ID: 9, Hour: 23, Minute: 45, StartsAt: started},
+	}
+	sortByNextDose(reminders, now, loc)
+
+	// 23:55 сегодня; 00:10, 03:00, 08:00, 22:00, 23:45 завтра; среда 00:01;
+	// без ближайшего приёма (пауза, прошедшая разовая дата) — в конце в прежнем порядке
+	want := []int{3, 2, 7, 1, 4, 9, 6, 5, 8}
+	for i, r := range reminders {
+		if r.ID != want[i] {
+			got := make([]int, len(reminders))
+			for j, r := range reminders {
+				got[j] = r.ID
+			}
+			t.Fatalf("sortByNextDose order = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Рассылка напоминаний: сколько отправок идёт одновременно и сколько
+// сообщений в секунду можно отправить всего (лимит Telegram — около 30)
+const (
+	defaultSendConcurrency = 8
+	maxSendConcurrency     = 64
+	defaultSendRate        = 30
+	maxSendRate            = 30
+)
+
+// parseSendConcurrency разбирает SEND_CONCURRENCY — число потоков рассылки слота.
+// Каждая отправка ограничена apiRequestTimeout, поэтому зависший запрос
+// задерживает только свой поток, а не всю рассылку.
+func parseSendConcurrency(value string) int {
+	if value == "" {
+		return defaultSendConcurrency
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 1 || n > maxSendConcurrency {
+		log.Printf("Ignoring invalid SEND_CONCURRENCY %q", value)
+		return defaultSendConcurrency
+	}
+	return n
+}
+
+// parseSendRate разбирает SEND_RATE — сколько сообщений в секунду можно отправить при рассылке
+func parseSendRate(value string) int {
+	if value == "" {
+		return defaultSendRate
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || n < 1 || n > maxSendRate {
+		log.Printf("Ignoring invalid SEND_RATE %q", value)
+		return defaultSendRate
+	}
+	return n
+}
+
+// tokenBucket ограничивает частоту отправки: в среднем не больше rate сообщений
+// в секунду и не больше burst подряд после простоя. Общий для всех потоков
+// рассылки, поэтому лимит не зависит от их числа. Время берётся настоящее, а не
+// часы бота: ограничение касается запросов к Telegram.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // токенов в секунду
+	burst  float64
+	tokens float64 // может уйти в минус: очередь уже ждущих
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst int) *tokenBucket {
+	return &tokenBucket{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// Wait забирает токен, при необходимости дождавшись его. nil — без ограничения.
+func (tb *tokenBucket) Wait() {
+	if tb == nil {
+		return
+	}
+
+	tb.mu.Lock()
+	now := time.Now()
+	tb.tokens = min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+	tb.tokens--
+	// Токен занят сразу, а ждём уже без блокировки — следующие встают в очередь за нами
+	wait := time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+	tb.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
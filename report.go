@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// reportPeriods — периоды отчёта для врача в днях
+var reportPeriods = []int{30, 90}
+
+// reportTemplate — отчёт в виде HTML-страницы: открывается в любом браузере
+// и печатается или сохраняется в PDF без дополнительных программ
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>Отчёт о приёме лекарств</title>
+<style>
+  body { font-family: -apple-system, "Segoe UI", Roboto, Arial, sans-serif; color: #222; max-width: 760px; margin: 24px auto; padding: 0 16px; }
+  h1 { font-size: 22px; margin-bottom: 4px; }
+  .meta { color: #666; margin-bottom: 24px; }
+  table { width: 100%; border-collapse: collapse; margin-bottom: 24px; }
+  th, td { border: 1px solid #ccc; padding: 6px 8px; text-align: left; }
+  th { background: #f3f3f3; }
+  td.num { text-align: right; }
+  .low { color: #b00020; font-weight: bold; }
+  @media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+<h1>Отчёт о приёме лекарств</h1>
+<div class="meta">Период: {{.From}} — {{.To}} ({{.Days}} дн.)<br>Сформирован: {{.Generated}}</div>
+
+<h2>По лекарствам</h2>
+{{if .Medicines}}
+<table>
+  <tr><th>Лекарство</th><th>Принято</th><th>Пропущено</th><th>Пропущено намеренно</th><th>Соблюдение</th></tr>
+  {{range .Medicines}}
+  <tr><td>{{.Medicine}}</td><td class="num">{{.Taken}}</td><td class="num">{{.Missed}}</td><td class="num">{{.Skipped}}</td><td class="num{{if .Low}} low{{end}}">{{.Adherence}}</td></tr>
+  {{end}}
+  <tr><th>Итого</th><th class="num">{{.TotalTaken}}</th><th class="num">{{.TotalMissed}}</th><th class="num">{{.TotalSkipped}}</th><th class="num">{{.TotalAdherence}}</th></tr>
+</table>
+{{else}}
+<p>За этот период приёмов не записано.</p>
+{{end}}
+
+{{if .Daily}}
+<h2>По дням</h2>
+<table>
+  <tr><th>Дата</th><th>Принято</th><th>Пропущено</th><th>Пропущено намеренно</th></tr>
+  {{range .Daily}}
+  <tr><td>{{.Date}}</td><td class="num">{{.Taken}}</td><td class="num">{{.Missed}}</td><td class="num">{{.Skipped}}</td></tr>
+  {{end}}
+</table>
+{{end}}
+
+{{if .Notes}}
+<h2>Заметки</h2>
+<table>
+  <tr><th>Дата и время</th><th>Лекарство</th><th>Заметка</th></tr>
+  {{range .Notes}}
+  <tr><td>{{.When}}</td><td>{{.Medicine}}</td><td>{{.Note}}</td></tr>
+  {{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// reportMedicine — строка таблицы по лекарству
+type reportMedicine struct {
+	Medicine  string
+	Taken     int
+	Missed    int
+	Skipped   int
+	Adherence string
+	Low       bool // соблюдение ниже 80%
+}
+
+// reportNote — строка таблицы заметок к приёмам
+type reportNote struct {
+	When     string
+	Medicine string
+	Note     string
+}
+
+// reportData — данные шаблона отчёта
+type reportData struct {
+	From, To, Generated string
+	Days                int
+
+	Medicines      []reportMedicine
+	TotalTaken     int
+	TotalMissed    int
+	TotalSkipped   int
+	TotalAdherence string
+
+	Daily []DayHistory
+	Notes []reportNote
+}
+
+// adherencePercent возвращает долю принятых доз в процентах ("—", если доз не было)
+func adherencePercent(taken, missed int) (string, int) {
+	if taken+missed == 0 {
+		return "—", 100
+	}
+	p := taken * 100 / (taken + missed)
+	return fmt.Sprintf("%d%%", p), p
+}
+
+// handleReport предлагает выбрать период отчёта для врача
+func (b *Bot) handleReport(msg *tgbotapi.Message) {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, days := range reportPeriods {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d дней", days), fmt.Sprintf("report_%d", days)))
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, "📄 Отчёт для врача\n\nЗа какой период подготовить отчёт о приёме лекарств?")
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// sendReport формирует отчёт за days дней и отправляет его документом
+func (b *Bot) sendReport(chatID int64, messageID int, days int) {
+	valid := false
+	for _, d := range reportPeriods {
+		valid = valid || d == days
+	}
+	if !valid {
+		return
+	}
+	b.deleteMessage(chatID, messageID)
+
+	l := b.userLocale(chatID, defaultLocale)
+	now := b.now().In(l.Loc)
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Loc).AddDate(0, 0, -(days - 1))
+	missedBefore := now.Add(-takenConfirmWindow)
+
+	medicines, err := b.storage.GetMedicineAdherence(chatID, since, missedBefore)
+	if err != nil {
+		log.Printf("Failed to get medicine adherence: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки истории")
+		return
+	}
+	history, err := b.storage.GetDoseHistory(chatID, since, missedBefore, l.Loc)
+	if err != nil {
+		log.Printf("Failed to get dose history: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки истории")
+		return
+	}
+	notes, err := b.storage.GetDoseNotes(chatID, since)
+	if err != nil {
+		log.Printf("Failed to get dose notes: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки истории")
+		return
+	}
+
+	data := reportData{
+		From:      formatDate(l, since),
+		To:        formatDate(l, now),
+		Generated: formatDate(l, now) + " " + formatClock(l, now),
+		Days:      days,
+		Daily:     history,
+	}
+	for _, m := range medicines {
+		adherence, p := adherencePercent(m.Taken, m.Missed)
+		data.Medicines = append(data.Medicines, reportMedicine{
+			Medicine:  m.Medicine,
+			Taken:     m.Taken,
+			Missed:    m.Missed,
+			Skipped:   m.Skipped,
+			Adherence: adherence,
+			Low:       p < 80,
+		})
+		data.TotalTaken += m.Taken
+		data.TotalMissed += m.Missed
+		data.TotalSkipped += m.Skipped
+	}
+	data.TotalAdherence, _ = adherencePercent(data.TotalTaken, data.TotalMissed)
+	for _, n := range notes {
+		data.Notes = append(data.Notes, reportNote{
+			When:     formatDate(l, n.ScheduledAt) + " " + formatClock(l, n.ScheduledAt),
+			Medicine: n.Medicine,
+			Note:     n.Note,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := reportTemplate.Execute(&buf, data); err != nil {
+		log.Printf("Failed to render report: %v", err)
+		b.sendMessage(chatID, "Ошибка формирования отчёта")
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("report_%s.html", now.Format("2006-01-02")),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = fmt.Sprintf("📄 Отчёт о приёме лекарств за %d дней\n\nОткрой файл в браузере — его можно распечатать или сохранить в PDF", days)
+	if _, err := b.api.Send(doc); err != nil {
+		log.Printf("Failed to send report: %v", err)
+		b.sendMessage(chatID, "Не удалось отправить отчёт. Попробуй позже")
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// schedulerInterval — как часто планировщик проверяет время
+const schedulerInterval = 15 * time.Second
+
+// Scheduler рассылает напоминания в наступившие слоты.
+// Время берётся из clock, а моменты проверки — из канала тиков,
+// поэтому в тестах можно подать свои часы и тики и проверить рассылку детерминированно.
+type Scheduler struct {
+	bot           *Bot
+	clock         Clock
+	lastSentTime  string    // последний обработанный слот — защита от повторной отправки
+	lastMissedRun time.Time // последняя проверка пропущенных доз
+	lastPreAlert  string    // последняя минута, за которую отправлены предупреждения
+	lastNudge     string    // последняя минута, за которую повторены неподтверждённые напоминания
+
+	lastIntegrityRun time.Time // последняя проверка согласованности данных
+
+	// Выбор ведущего среди нескольких экземпляров (leader.go)
+	instance    string         // имя экземпляра в пульсе
+	lock        *SchedulerLock // nil — экземпляр не ведущий
+	leaderSince time.Time
+	standby     bool      // о том, что ведёт другой экземпляр, уже написано в лог
+	lastSlot    time.Time // последний проверенный слот — докуда дошла рассылка
+}
+
+// zoneOffsetStep — часовые пояса сдвинуты друг относительно друга на целое
+// число четвертей часа (Asia/Kathmandu — UTC+5:45), поэтому 08:00 пользователя
+// может прийтись на :15 или :45 по bot.loc
+const zoneOffsetStep = 15
+
+// checkStep возвращает, какие минуты по bot.loc нужно проверять, чтобы не
+// пропустить минуты, кратные step, в любом поясе: НОД step и zoneOffsetStep.
+// Для шага 10 это каждые 5 минут: 08:00 в Катманду — 05:15 в Москве.
+func checkStep(step int) int {
+	a, b := step, zoneOffsetStep
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// missedCheckInterval — как часто неподтверждённые дозы проверяются на пропуск
+const missedCheckInterval = 15 * time.Minute
+
+func NewScheduler(bot *Bot, clock Clock) *Scheduler {
+	return &Scheduler{bot: bot, clock: clock, instance: instanceName()}
+}
+
+// StartScheduler запускает планировщик с часами бота и реальным тикером.
+// Возвращается после отмены ctx, дождавшись текущей рассылки.
+func StartScheduler(ctx context.Context, bot *Bot) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	ticks := make(chan time.Time)
+	go func() {
+		defer close(ticks)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case t := <-ticker.C:
+				select {
+				case ticks <- t:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	NewScheduler(bot, bot.clock).Run(ticks)
+}
+
+// Run обрабатывает тики, пока канал не закроется. Напоминания рассылает
+// только ведущий экземпляр; при выходе блокировка ведущего освобождается.
+func (s *Scheduler) Run(ticks <-chan time.Time) {
+	defer s.resignLeader()
+	for range ticks {
+		if !s.ensureLeader() {
+			continue
+		}
+		s.Tick()
+	}
+}
+
+// Tick проверяет текущее время и, если наступил новый слот, рассылает напоминания.
+// Рассылка слота завершается внутри Tick, поэтому следующий тик начинает
+// выборку только после неё; пропущенные за это время тики тикер отбрасывает.
+func (s *Scheduler) Tick() {
+	bot := s.bot
+	now := s.clock.Now().In(bot.loc)
+
+	// Режим обслуживания с остановкой планировщика: ни напоминаний, ни записи в журнал доз
+	if bot.schedulerPaused() {
+		return
+	}
+
+	s.finalizeMissed(now)
+	s.checkIntegrity(now)
+	s.sendPreAlerts(now)
+	s.sendNudges(now)
+
+	hour := now.Hour()
+	minute := now.Minute()
+
+	// Проверяем только минуты, которые в каком-нибудь поясе кратны самому мелкому
+	// шагу среди пользователей и напоминаний (по умолчанию 0, 15, 30, 45, см. checkStep);
+	// мельче minuteSteps[0] шагов нет. Чьё напоминание пора отправлять в его
+	// поясе, решает GetRemindersForTime.
+	if minute%checkStep(minuteSteps[0]) != 0 {
+		s.lastSentTime = ""
+		return
+	}
+	step, err := bot.storage.GetFinestMinuteStep()
+	if err != nil {
+		log.Printf("Failed to get finest minute step: %v", err)
+		step = minuteSteps[0]
+	}
+	if minute%checkStep(step) != 0 {
+		s.lastSentTime = ""
+		return
+	}
+
+	currentTime := fmt.Sprintf("%02d:%02d", hour, minute)
+	if currentTime == s.lastSentTime {
+		return
+	}
+
+	// Получаем напоминания для текущего времени
+	reminders := bot.GetRemindersForTime(now)
+	s.lastSlot = now.Truncate(time.Minute)
+	if len(reminders) == 0 {
+		return
+	}
+
+	s.lastSentTime = currentTime
+	s.sendSlot(now.Truncate(time.Minute), reminders, bot.dryRun)
+}
+
+// sendSlot рассылает напоминания слота slot и возвращает итоги с результатом
+// по каждому напоминанию. dryRun — только перечислить, что было бы отправлено
+// (по одному напоминанию, без сборки утренних и вечерних пачек).
+// Через него же идёт ручная проверка слота /checkslot, чтобы она вела себя как планировщик.
+func (s *Scheduler) sendSlot(slot time.Time, reminders map[int64][]Reminder, dryRun bool) *slotStats {
+	bot := s.bot
+	currentTime := slot.Format("15:04")
+	started := time.Now()
+
+	if dryRun {
+		// Ни журнала, ни отправки: иначе неотправленные дозы позже станут "пропущенными"
+		stats := &slotStats{users: len(reminders)}
+		for chatID, userReminders := range reminders {
+			for _, r := range userReminders {
+				log.Printf("[DRY RUN] Would send reminder %d (%s) to %d for slot %s",
+					r.ID, r.Medicine, chatID, slot.Format("2006-01-02 15:04 MST"))
+				stats.reminders++
+				stats.deliveries = append(stats.deliveries, slotDelivery{ChatID: chatID, Reminder: r, DryRun: true})
+			}
+		}
+		return stats
+	}
+
+	chatIDs := make([]int64, 0, len(reminders))
+	for chatID := range reminders {
+		chatIDs = append(chatIDs, chatID)
+	}
+	languages, err := bot.storage.GetUserLanguages(chatIDs)
+	if err != nil {
+		log.Printf("Failed to get user languages: %v", err)
+	}
+	bundling, err := bot.storage.GetBundlingUsers(chatIDs)
+	if err != nil {
+		log.Printf("Failed to get bundling users: %v", err)
+	}
+
+	stats := &slotStats{users: len(reminders)}
+
+	// Отправляют sendConcurrency потоков из общей очереди, не быстрее sendLimiter.
+	// Ошибка отправки записывается в итоги и не останавливает рассылку.
+	jobs := make(chan slotJob)
+	var wg sync.WaitGroup
+	for range max(bot.sendConcurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				s.sendJob(job, languages[job.chatID], slot, stats)
+			}
+		}()
+	}
+
+	for chatID, userReminders := range reminders {
+		// Утренние и вечерние пачки: все приёмы половины дня одним сообщением
+		if bundling[chatID] == bundlingHalves && startsBundle(userReminders) {
+			if items, ok := s.bundleItems(chatID, slot, stats); ok {
+				if len(items) > 0 {
+					jobs <- slotJob{chatID: chatID, items: items}
+				}
+				continue
+			}
+		}
+
+		for _, r := range userReminders {
+			stats.reminders++
+			metricSlotReminders.Inc()
+
+			fresh, err := bot.storage.MarkDoseScheduled(chatID, r.ID, r.Medicine, slot)
+			if err != nil {
+				log.Printf("Failed to log scheduled dose: %v", err)
+			} else if !fresh {
+				// Слот уже отправлялся (например, до перезапуска) — не дублируем
+				stats.skip(chatID, r)
+				metricSlotSkipped.Inc()
+				continue
+			}
+
+			jobs <- slotJob{chatID: chatID, reminder: r}
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	metricSlotsTotal.Inc()
+	metricSlotDuration.Observe(time.Since(started))
+
+	log.Printf("Slot %s done: users=%d reminders=%d sent=%d failed=%d timed_out=%d skipped=%d duration=%s",
+		currentTime, stats.users, stats.reminders, stats.sent, stats.failed, stats.timedOut, stats.skipped,
+		time.Since(started).Round(time.Millisecond))
+	return stats
+}
+
+// slotJob — одна отправка слота: напоминание или утренняя/вечерняя пачка
+type slotJob struct {
+	chatID   int64
+	reminder Reminder
+	items    []occurrence // не пусто — пачка вместо одного напоминания
+}
+
+// sendJob отправляет напоминание или пачку из очереди слота, дождавшись лимита отправки
+func (s *Scheduler) sendJob(job slotJob, lang string, slot time.Time, stats *slotStats) {
+	s.bot.sendLimiter.Wait()
+
+	if len(job.items) > 0 {
+		err := s.sendBundle(job.chatID, lang, slot, job.items)
+		for _, o := range job.items {
+			stats.record(job.chatID, o.Reminder, err)
+		}
+		return
+	}
+	stats.record(job.chatID, job.reminder, s.send(job.chatID, lang, job.reminder, slot))
+}
+
+// slotStats — итоги рассылки одного слота для лога
+type slotStats struct {
+	mu         sync.Mutex
+	users      int
+	reminders  int
+	sent       int
+	failed     int // включая timedOut
+	timedOut   int
+	skipped    int // слот уже был отправлен раньше
+	deliveries []slotDelivery
+}
+
+// slotDelivery — что стало с одним напоминанием слота
+type slotDelivery struct {
+	ChatID   int64
+	Reminder Reminder
+	Err      error // ошибка отправки
+	Skipped  bool  // слот уже был отправлен раньше
+	DryRun   bool  // пробный прогон: ничего не отправлялось
+}
+
+// skip учитывает напоминание, слот которого уже был отправлен
+func (st *slotStats) skip(chatID int64, r Reminder) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.skipped++
+	st.deliveries = append(st.deliveries, slotDelivery{ChatID: chatID, Reminder: r, Skipped: true})
+}
+
+// record учитывает результат одной отправки
+func (st *slotStats) record(chatID int64, r Reminder, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.deliveries = append(st.deliveries, slotDelivery{ChatID: chatID, Reminder: r, Err: err})
+
+	switch {
+	case err == nil:
+		st.sent++
+	case isTimeout(err):
+		st.timedOut++
+		st.failed++
+	default:
+		st.failed++
+	}
+}
+
+// finalizeMissed помечает пропущенными дозы, которые уже нельзя подтвердить,
+// отправляет их на webhook пользователей и удаляет истёкшие разовые напоминания
+func (s *Scheduler) finalizeMissed(now time.Time) {
+	if now.Sub(s.lastMissedRun) < missedCheckInterval {
+		return
+	}
+	s.lastMissedRun = now
+
+	if s.bot.dryRun {
+		return
+	}
+
+	doses, err := s.bot.storage.FinalizeMissedDoses(now.Add(-takenConfirmWindow))
+	if err != nil {
+		log.Printf("Failed to finalize missed doses: %v", err)
+		return
+	}
+	if len(doses) > 0 {
+		log.Printf("Marked %d doses as missed", len(doses))
+	}
+	notifyMissedDoses(doses)
+
+	// Разовые напоминания, которые уже нельзя подтвердить, больше не нужны
+	deleted, err := s.bot.storage.DeleteExpiredOneOffs(now.Add(-takenConfirmWindow))
+	if err != nil {
+		log.Printf("Failed to delete expired one-off reminders: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d expired one-off reminders", deleted)
+	}
+
+	// Курсы по дням, последнюю дозу которых уже нельзя подтвердить, завершаются по календарю
+	s.bot.completeFinishedDayCourses(now.Add(-takenConfirmWindow))
+}
+
+// sendPreAlerts раз в минуту рассылает предупреждения о скорых напоминаниях.
+// Предупреждение не пишется в dose_log и не имеет кнопок, поэтому доза
+// учитывается только по основному напоминанию.
+func (s *Scheduler) sendPreAlerts(now time.Time) {
+	minute := now.Format("15:04")
+	if minute == s.lastPreAlert {
+		return
+	}
+	s.lastPreAlert = minute
+
+	alerts, err := s.bot.storage.GetPreAlertsForTime(now.Truncate(time.Minute))
+	if err != nil {
+		log.Printf("Failed to get pre-alerts: %v", err)
+		return
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	if s.bot.dryRun {
+		for chatID, alert := range alerts {
+			log.Printf("[DRY RUN] Would send pre-alert for %d reminders to %d at %s", len(alert.Reminders), chatID, minute)
+		}
+		return
+	}
+
+	chatIDs := make([]int64, 0, len(alerts))
+	for chatID := range alerts {
+		chatIDs = append(chatIDs, chatID)
+	}
+	languages, err := s.bot.storage.GetUserLanguages(chatIDs)
+	if err != nil {
+		log.Printf("Failed to get user languages: %v", err)
+	}
+
+	for chatID, alert := range alerts {
+		s.bot.sendLimiter.Wait()
+		s.bot.sendPreAlert(chatID, languages[chatID], alert)
+	}
+	log.Printf("Pre-alerts %s done: users=%d", minute, len(alerts))
+}
+
+// send отправляет одно напоминание. Отправки, прерванные по таймауту,
+// логируются отдельно — доза остаётся в dose_log со статусом scheduled.
+func (s *Scheduler) send(chatID int64, lang string, r Reminder, slot time.Time) error {
+	if r.Important {
+		s.bot.notifyMissedPreviousDose(chatID, lang, r, slot)
+	}
+
+	sendStarted := time.Now()
+	err := s.bot.sendReminderWithButton(chatID, lang, r, slot)
+	recordSend(err, time.Since(sendStarted))
+
+	switch {
+	case err == nil:
+		if !r.RequireConfirm {
+			s.bot.autoCountDose(chatID, r, slot)
+		}
+	case isTimeout(err):
+		log.Printf("Timed out sending reminder %d to %d for slot %s, needs retry: %v",
+			r.ID, chatID, slot.Format("15:04"), err)
+	default:
+		log.Printf("Failed to send reminder to %d: %v", chatID, err)
+	}
+	return err
+}
@@ -0,0 +1,222 @@
+//go:build integration
+
+// Интеграционные тесты планировщика: настоящий PostgreSQL, поддельный Bot API
+// и часы (bot_test.go). Запуск — как у storage_integration_test.go.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestScheduler — планировщик бота из newTestBot с хранилищем на TEST_DATABASE_URL
+func newTestScheduler(t *testing.T) (*Scheduler, *fakeTelegram, *fakeClock) {
+	t.Helper()
+
+	b, tg, clock := newTestBot(t)
+	b.storage = newTestStorage(t)
+	return NewScheduler(b, clock), tg, clock
+}
+
+// sentReminders возвращает отправленные сообщения, в которых упомянуто лекарство medicine
+func sentReminders(tg *fakeTelegram, medicine string) []string {
+	var result []string
+	for _, text := range tg.sent() {
+		if strings.Contains(text, medicine) {
+			result = append(result, text)
+		}
+	}
+	return result
+}
+
+// TestSchedulerZoneOffsetStep проверяет напоминание пользователя из пояса со
+// сдвигом 45 минут: 08:00 в Катманду — 07:15 по поясу бота (Екатеринбург),
+// и при шаге 10 минут оно всё равно должно прийти
+func TestSchedulerZoneOffsetStep(t *testing.T) {
+	s, tg, clock := newTestScheduler(t)
+	storage := s.bot.storage
+
+	kathmandu, err := time.LoadLocation("Asia/Kathmandu")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	slot := time.Date(2026, 3, 2, 8, 0, 0, 0, kathmandu)
+	clock.Advance(slot.Sub(clock.Now()))
+
+	addTestReminder(t, storage, 1, 0, slot.Add(-24*time.Hour))
+	if err := storage.SetUserTimezone(1, "Asia/Kathmandu"); err != nil {
+		t.Fatalf("SetUserTimezone: %v", err)
+	}
+	if err := storage.SetMinuteStep(1, 10); err != nil {
+		t.Fatalf("SetMinuteStep: %v", err)
+	}
+
+	if got := clock.Now().In(s.bot.loc).Format("15:04"); got != "07:15" {
+		t.Fatalf("bot time = %s, want 07:15", got)
+	}
+	s.Tick()
+	if got := sentReminders(tg, "Аспирин"); len(got) != 1 {
+		t.Errorf("reminders sent at 08:00 Kathmandu = %q, want one", got)
+	}
+}
+
+// addSchedulerReminder добавляет пользователю 1 ежедневное напоминание на hour:minute
+func addSchedulerReminder(t *testing.T, s *Scheduler, medicine string, hour, minute int) int {
+	t.Helper()
+
+	if _, _, err := s.bot.storage.GetOrCreateUser(1); err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	id, err := s.bot.storage.AddReminder(1, Reminder{
+		Medicine: medicine,
+		Hour:     hour,
+		Minute:   minute,
+		StartsAt: s.clock.Now().Add(-24 * time.Hour),
+	}, ReminderSourceChat)
+	if err != nil {
+		t.Fatalf("AddReminder: %v", err)
+	}
+	return id
+}
+
+// TestSchedulerTick подаёт тики с поддельными часами и проверяет, какие слоты
+// рассылаются: минуты вне шага пропускаются, а повторный тик в том же слоте
+// отсекает lastSentTime — даже для напоминания, которого ещё нет в dose_log
+func TestSchedulerTick(t *testing.T) {
+	s, tg, clock := newTestScheduler(t)
+	at := func(hour, minute, second int) {
+		now := clock.Now()
+		target := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, second, 0, s.bot.loc)
+		clock.Advance(target.Sub(now))
+		s.Tick()
+	}
+	addSchedulerReminder(t, s, "Аспирин", 8, 0)
+	addSchedulerReminder(t, s, "Витамин D", 8, 15)
+
+	at(7, 59, 45)
+	if got := tg.sent(); len(got) != 0 {
+		t.Fatalf("sent at 07:59 = %q, want nothing", got)
+	}
+
+	at(8, 0, 0)
+	if got := sentReminders(tg, "Аспирин"); len(got) != 1 {
+		t.Fatalf("Аспирин sent at 08:00 = %q, want once", got)
+	}
+	if s.lastSentTime != "08:00" {
+		t.Errorf("lastSentTime = %q, want 08:00", s.lastSentTime)
+	}
+
+	// Второй тик в том же слоте не перечитывает напоминания
+	addSchedulerReminder(t, s, "Омега 3", 8, 0)
+	at(8, 0, 15)
+	if got := sentReminders(tg, "Омега 3"); len(got) != 0 {
+		t.Errorf("second tick in slot 08:00 sent %q, want nothing", got)
+	}
+	if got := sentReminders(tg, "Аспирин"); len(got) != 1 {
+		t.Errorf("Аспирин sent after second tick = %q, want once", got)
+	}
+
+	// 08:05 и 08:07 не кратны шагу 15 минут — слот не проверяется
+	checked := s.lastSlot
+	at(8, 5, 0)
+	at(8, 7, 0)
+	if !s.lastSlot.Equal(checked) {
+		t.Errorf("last checked slot after 08:05 and 08:07 = %v, want %v", s.lastSlot, checked)
+	}
+	if s.lastSentTime != "" {
+		t.Errorf("lastSentTime after off-step minute = %q, want reset", s.lastSentTime)
+	}
+
+	at(8, 15, 0)
+	if got := sentReminders(tg, "Витамин D"); len(got) != 1 {
+		t.Errorf("Витамин D sent at 08:15 = %q, want once", got)
+	}
+	if got := sentReminders(tg, "Омега 3"); len(got) != 0 {
+		t.Errorf("Омега 3 sent after its slot = %q, want nothing", got)
+	}
+}
+
+// TestSchedulerRun проверяет, что Run берёт блокировку ведущего, рассылает
+// слот по тику из канала и освобождает блокировку, когда канал закрыт
+func TestSchedulerRun(t *testing.T) {
+	s, tg, _ := newTestScheduler(t)
+	addSchedulerReminder(t, s, "Аспирин", 8, 0)
+
+	ticks := make(chan time.Time, 1)
+	ticks <- time.Now()
+	close(ticks)
+	s.Run(ticks)
+
+	if got := sentReminders(tg, "Аспирин"); len(got) != 1 {
+		t.Errorf("Аспирин sent by Run at 08:00 = %q, want once", got)
+	}
+	if s.lock != nil {
+		t.Error("scheduler lock is still held after Run")
+	}
+	hb, err := s.bot.storage.GetSchedulerHeartbeat()
+	if err != nil || hb == nil || hb.Instance != s.instance {
+		t.Errorf("heartbeat = %+v, %v, want one from %s", hb, err, s.instance)
+	}
+}
+
+// TestSchedulerResume проверяет смену ведущего: прежний ведущий проверил слот
+// 07:30 и пропал, новый становится ведущим в 08:00. Досылаются только слоты
+// последних schedulerResumeWindow минут, по порядку, а слот, уже записанный
+// в dose_log, повторно не отправляется.
+func TestSchedulerResume(t *testing.T) {
+	old, tg, clock := newTestScheduler(t)
+	if _, _, err := old.bot.storage.GetOrCreateUser(1); err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if err := old.bot.storage.SetMinuteStep(1, 5); err != nil {
+		t.Fatalf("SetMinuteStep: %v", err)
+	}
+	at := func(hour, minute int) time.Time {
+		now := clock.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, old.bot.loc)
+	}
+
+	addSchedulerReminder(t, old, "Аспирин", 7, 40)   // вне окна досылки
+	addSchedulerReminder(t, old, "Витамин D", 7, 45) // вне окна досылки
+	addSchedulerReminder(t, old, "Омега 3", 7, 50)
+	sent := addSchedulerReminder(t, old, "Магний", 7, 55)
+	addSchedulerReminder(t, old, "Цинк", 7, 55)
+
+	clock.Advance(at(7, 30).Sub(clock.Now()))
+	if !old.ensureLeader() {
+		t.Fatal("old scheduler did not become the leader")
+	}
+	old.Tick()
+	old.heartbeat(clock.Now())
+	old.resignLeader()
+
+	// "Магний" за 07:55 уже записан в журнал, например отправлен до перезапуска
+	if _, err := old.bot.storage.MarkDoseScheduled(1, sent, "Магний", at(7, 55)); err != nil {
+		t.Fatalf("MarkDoseScheduled: %v", err)
+	}
+
+	clock.Advance(at(8, 0).Sub(clock.Now()))
+	s := NewScheduler(old.bot, clock)
+	if !s.ensureLeader() {
+		t.Fatal("new scheduler did not become the leader")
+	}
+	defer s.resignLeader()
+
+	var got []string
+	for _, text := range tg.sent() {
+		for _, medicine := range []string{"Аспирин", "Витамин D", "Омега 3", "Магний", "Цинк"} {
+			if strings.Contains(text, medicine) {
+				got = append(got, medicine)
+			}
+		}
+	}
+	want := []string{"Омега 3", "Цинк"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("resumed reminders = %q, want %q", got, want)
+	}
+	if !s.lastSlot.Equal(at(7, 55)) {
+		t.Errorf("last checked slot = %v, want 07:55", s.lastSlot)
+	}
+}
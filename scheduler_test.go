@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestCheckStep(t *testing.T) {
+	tests := []struct {
+		step int
+		want int
+	}{
+		{5, 5},
+		{10, 5},
+		{15, 15},
+		{30, 15},
+		{60, 15},
+	}
+	for _, tt := range tests {
+		if got := checkStep(tt.step); got != tt.want {
+			t.Errorf("checkStep(%d) = %d, want %d", tt.step, got, tt.want)
+		}
+	}
+}
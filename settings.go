@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Как поздравлять с завершением курса
+const (
+	celebrationEmoji = "emoji" // "🎉 ... Ты молодец!" — по умолчанию
+	celebrationPlain = "plain" // только факт завершения и итоги
+	celebrationOff   = "off"   // без сообщения о завершении
+)
+
+// celebrationStyles — варианты в порядке кнопок /settings
+var celebrationStyles = []struct {
+	Style string
+	Label string
+}{
+	{celebrationEmoji, "🎉 С эмодзи"},
+	{celebrationPlain, "📝 Сдержанно"},
+	{celebrationOff, "🔕 Не поздравлять"},
+}
+
+// defaultMinuteStep — шаг минут по умолчанию: 00, 15, 30, 45
+const defaultMinuteStep = 15
+
+// minuteSteps — шаги минут в порядке кнопок /settings; первый — самый мелкий,
+// чаще него планировщик напоминания не проверяет
+var minuteSteps = []int{5, 10, 15, 30}
+
+// minuteStep возвращает шаг минут пользователя (defaultMinuteStep при ошибке)
+func (b *Bot) minuteStep(chatID int64) int {
+	step, err := b.storage.GetMinuteStep(chatID)
+	if err != nil {
+		log.Printf("Failed to get minute step for %d: %v", chatID, err)
+		return defaultMinuteStep
+	}
+	if !slices.Contains(minuteSteps, step) {
+		return defaultMinuteStep
+	}
+	return step
+}
+
+// stepMinutes возвращает минуты, которые можно выбрать с шагом step
+func stepMinutes(step int) []int {
+	var minutes []int
+	for m := 0; m < 60; m += step {
+		minutes = append(minutes, m)
+	}
+	return minutes
+}
+
+// minuteStepHint описывает допустимые минуты для подсказок: "00, 15, 30 или 45", "кратные 5"
+func minuteStepHint(step int) string {
+	minutes := stepMinutes(step)
+	if len(minutes) > 4 {
+		return fmt.Sprintf("кратные %d", step)
+	}
+	labels := make([]string, len(minutes))
+	for i, m := range minutes {
+		labels[i] = fmt.Sprintf("%02d", m)
+	}
+	return strings.Join(labels[:len(labels)-1], ", ") + " или " + labels[len(labels)-1]
+}
+
+// celebration возвращает, как поздравлять пользователя (celebrationEmoji при ошибке)
+func (b *Bot) celebration(chatID int64) string {
+	style, err := b.storage.GetCelebration(chatID)
+	if err != nil {
+		log.Printf("Failed to get celebration for %d: %v", chatID, err)
+		return celebrationEmoji
+	}
+	switch style {
+	case celebrationPlain, celebrationOff:
+		return style
+	}
+	return celebrationEmoji
+}
+
+// courseCompletedText возвращает сообщение о завершении курса в стиле style
+// ("" для celebrationOff)
+func (b *Bot) courseCompletedText(chatID int64, style, medicine string, summary *CourseSummary) string {
+	summaryText := b.courseSummaryText(b.userLocale(chatID, defaultLocale), summary)
+	switch style {
+	case celebrationOff:
+		return ""
+	case celebrationPlain:
+		return fmt.Sprintf("Курс \"%s\" завершён.", medicine) + summaryText
+	}
+	return fmt.Sprintf("🎉 Курс \"%s\" завершён! Ты молодец!", medicine) + summaryText
+}
+
+// handleSettings показывает личные настройки
+func (b *Bot) handleSettings(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+
+	reply := tgbotapi.NewMessage(chatID, settingsText)
+	reply.ReplyMarkup = settingsKeyboard(b.celebration(chatID), b.minuteStep(chatID), b.bundling(chatID))
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// settingsText — текст меню /settings; остальные настройки задаются своими командами
+const settingsText = "⚙️ Настройки\n\n🎉 Как поздравлять с завершением курса — кнопки сверху\n" +
+	"⏱ Шаг минут при выборе времени — предпоследний ряд: с шагом 5 минут можно выбрать 08:05\n" +
+	"📦 Как присылать напоминания — нижний ряд: по одному в своё время или утренние (до 12:00) и вечерние " +
+	"одним сообщением в время первого из них, с кнопкой на каждое лекарство\n\n" +
+	"Ещё: /timezone — часовой пояс, /wake и /sleep — распорядок дня, /prealert — предупреждение перед напоминанием"
+
+// settingsKeyboard — выбор поздравления, шага минут и пачек, текущие варианты отмечены ✓
+func settingsKeyboard(celebration string, step int, bundling string) tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, c := range celebrationStyles {
+		label := c.Label
+		if c.Style == celebration {
+			label = "✓ " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "celebrate_"+c.Style),
+		))
+	}
+
+	var stepRow []tgbotapi.InlineKeyboardButton
+	for _, s := range minuteSteps {
+		label := fmt.Sprintf("%d мин", s)
+		if s == step {
+			label = "✓ " + label
+		}
+		stepRow = append(stepRow, tgbotapi.NewInlineKeyboardButtonData(label, "minstep_"+strconv.Itoa(s)))
+	}
+	rows = append(rows, stepRow, bundlingKeyboardRow(bundling))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleCelebrationSelected сохраняет выбранный вариант поздравления
+func (b *Bot) handleCelebrationSelected(chatID int64, messageID int, style string) {
+	switch style {
+	case celebrationEmoji, celebrationPlain, celebrationOff:
+	default:
+		return
+	}
+	if style == b.celebration(chatID) {
+		return
+	}
+
+	if err := b.storage.SetCelebration(chatID, style); err != nil {
+		log.Printf("Failed to set celebration: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	keyboard := settingsKeyboard(style, b.minuteStep(chatID), b.bundling(chatID))
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleMinuteStepSelected сохраняет шаг минут: он меняет кнопки выбора минут
+// и какое время можно ввести текстом. Существующие напоминания не меняются.
+func (b *Bot) handleMinuteStepSelected(chatID int64, messageID int, step int) {
+	if !slices.Contains(minuteSteps, step) || step == b.minuteStep(chatID) {
+		return
+	}
+
+	if err := b.storage.SetMinuteStep(chatID, step); err != nil {
+		log.Printf("Failed to set minute step: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	keyboard := settingsKeyboard(b.celebration(chatID), step, b.bundling(chatID))
+	edit := tgbotapi.NewEditMessageReplyMarkup(chatID, messageID, keyboard)
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
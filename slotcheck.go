@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleCheckSlot прогоняет рассылку слота сегодня в ЧЧ:ММ, не дожидаясь его
+// (только для админа): /checkslot 08:05 — отправить, /checkslot 08:05 dry — только
+// показать, что было бы отправлено. Рассылка идёт тем же путём, что и у
+// планировщика: уже отправленные за слот напоминания не дублируются.
+func (b *Bot) handleCheckSlot(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+
+	if b.adminID == 0 || chatID != b.adminID {
+		b.sendMessage(chatID, "⛔ Эта команда доступна только администратору")
+		return
+	}
+
+	args := strings.Fields(msg.CommandArguments())
+	usage := "Укажи время слота сегодня: /checkslot ЧЧ:ММ [dry]\n\n" +
+		"dry — только показать, кому ушли бы напоминания, без отправки и записи в журнал доз"
+	if len(args) == 0 || len(args) > 2 {
+		b.sendMessage(chatID, usage)
+		return
+	}
+	hour, minute, ok := parseClockTime(args[0])
+	if !ok {
+		b.sendMessage(chatID, usage)
+		return
+	}
+	dryRun := b.dryRun
+	if len(args) == 2 {
+		if strings.ToLower(args[1]) != "dry" {
+			b.sendMessage(chatID, usage)
+			return
+		}
+		dryRun = true
+	}
+	if !dryRun && b.schedulerPaused() {
+		b.sendMessage(chatID, "⏸ Планировщик остановлен режимом обслуживания — доступен только пробный прогон: /checkslot ЧЧ:ММ dry")
+		return
+	}
+
+	now := b.now().In(b.loc)
+	slot := time.Date(now.Year(), now.Month(), now.Day(), hour, minute, 0, 0, b.loc)
+
+	details := slot.Format("15:04")
+	if dryRun {
+		details += " dry"
+	}
+	b.audit(chatID, auditCheckSlot, details)
+
+	var text strings.Builder
+	mode := "рассылка"
+	if dryRun {
+		mode = "пробный прогон"
+	}
+	text.WriteString(fmt.Sprintf("🔍 Слот %s (%s), %s\n", slot.Format("02.01 15:04"), zoneLabel(b.loc, slot), mode))
+
+	// Кто из экземпляров рассылает напоминания (leader.go)
+	if hb, err := b.storage.GetSchedulerHeartbeat(); err != nil {
+		log.Printf("Failed to get scheduler heartbeat: %v", err)
+	} else if hb != nil {
+		text.WriteString(fmt.Sprintf("Ведущий планировщик: %s, пульс %s назад", hb.Instance, b.now().Sub(hb.BeatAt).Round(time.Second)))
+		if hb.LastSlot != nil {
+			text.WriteString(", последний слот " + hb.LastSlot.In(b.loc).Format("15:04"))
+		}
+		text.WriteString("\n")
+	}
+
+	// Сам планировщик проверяет только минуты, кратные самому мелкому шагу
+	step, err := b.storage.GetFinestMinuteStep()
+	if err != nil {
+		log.Printf("Failed to get finest minute step: %v", err)
+	} else if minute%step != 0 {
+		text.WriteString(fmt.Sprintf("⚠️ Планировщик эту минуту не проверяет: слоты идут с шагом %d мин\n", step))
+	}
+
+	reminders, err := b.storage.GetRemindersForTime(slot)
+	if err != nil {
+		log.Printf("Failed to get reminders for time: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+	if len(reminders) == 0 {
+		text.WriteString("\nНапоминаний на этот слот нет: выключенные пользователи, отпуск, пауза, " +
+			"ещё не начавшиеся и завершённые курсы не выбираются")
+		b.sendMessage(chatID, text.String())
+		return
+	}
+
+	stats := NewScheduler(b, b.clock).sendSlot(slot, reminders, dryRun)
+	sort.Slice(stats.deliveries, func(i, j int) bool {
+		a, c := stats.deliveries[i], stats.deliveries[j]
+		if a.ChatID != c.ChatID {
+			return a.ChatID < c.ChatID
+		}
+		return a.Reminder.ID < c.Reminder.ID
+	})
+
+	if dryRun {
+		text.WriteString(fmt.Sprintf("\nБыло бы отправлено: %d напоминаний %d пользователям\n\n", stats.reminders, stats.users))
+	} else {
+		text.WriteString(fmt.Sprintf("\nНапоминаний: %d у %d пользователей — отправлено %d, ошибок %d (таймаутов %d), уже отправлялись %d\n\n",
+			stats.reminders, stats.users, stats.sent, stats.failed, stats.timedOut, stats.skipped))
+	}
+	for _, d := range stats.deliveries {
+		var result string
+		switch {
+		case d.DryRun:
+			result = "было бы отправлено"
+		case d.Skipped:
+			result = "уже отправлялось за этот слот"
+		case d.Err != nil:
+			result = fmt.Sprintf("ошибка: %v", d.Err)
+		default:
+			result = "отправлено"
+		}
+		text.WriteString(fmt.Sprintf("• %d — #%d %s — %s\n", d.ChatID, d.Reminder.ID, displayName(d.Reminder.Medicine), result))
+	}
+
+	b.sendLongMessage(chatID, text.String())
+}
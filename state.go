@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// shutdownTimeout ограничивает сохранение состояния при остановке бота
+const shutdownTimeout = 5 * time.Second
+
+// Брошенный диалог (например, /add без ответа) забывается через pendingDialogTTL
+// после начала: иначе b.pending растёт с каждым ушедшим пользователем. Проверка
+// идёт раз в pendingSweepInterval; при запуске такие диалоги не восстанавливаются.
+const (
+	pendingDialogTTL     = 30 * time.Minute
+	pendingSweepInterval = 5 * time.Minute
+)
+
+// evictStalePending периодически удаляет устаревшие диалоги, пока не отменён ctx
+func (b *Bot) evictStalePending(ctx context.Context) {
+	ticker := time.NewTicker(pendingSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.evictPendingBefore(b.now().Add(-pendingDialogTTL))
+		}
+	}
+}
+
+// evictPendingBefore удаляет диалоги, начатые раньше cutoff, вместе с их копией
+// в pending_dialogs. Оставшееся без ответа приглашение ввести название тоже удаляется.
+func (b *Bot) evictPendingBefore(cutoff time.Time) {
+	stale := make(map[int64]PendingReminder)
+	b.mu.Lock()
+	for chatID, p := range b.pending {
+		if p.StartedAt.Before(cutoff) {
+			stale[chatID] = *p
+			delete(b.pending, chatID)
+		}
+	}
+	b.mu.Unlock()
+
+	for chatID, p := range stale {
+		b.persistPending(chatID)
+		if (p.State == StateWaitingMedicine || p.State == StateWaitingDoseAmount) && p.PromptMsgID != 0 {
+			b.deleteMessage(chatID, p.PromptMsgID)
+		}
+	}
+	if len(stale) > 0 {
+		log.Printf("Evicted %d stale pending dialogs", len(stale))
+	}
+}
+
+// updateChatID возвращает чат, к которому относится обновление (0 — ни к какому)
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	}
+	return 0
+}
+
+// persistPending сохраняет диалог пользователя в pending_dialogs после обработки
+// обновления или удаляет его, если диалог завершён или отменён. Запись идёт,
+// только если диалог изменился, поэтому команды без диалога базу не трогают.
+// Разобранное назначение /import не сохраняется — после перезапуска его нужно прислать снова.
+func (b *Bot) persistPending(chatID int64) {
+	if chatID == 0 {
+		return
+	}
+	b.persistMu.Lock()
+	defer b.persistMu.Unlock()
+
+	b.mu.RLock()
+	p, active := b.pending[chatID]
+	var current PendingReminder
+	if active {
+		current = *p
+	}
+	saved, wasSaved := b.savedPending[chatID]
+	b.mu.RUnlock()
+
+	switch {
+	case !active && !wasSaved:
+		return
+	case !active:
+		if err := b.storage.DeletePendingDialog(chatID); err != nil {
+			log.Printf("Failed to delete pending dialog: %v", err)
+			return
+		}
+	case wasSaved && samePendingDialog(saved, current):
+		return
+	default:
+		if err := b.storage.SavePendingDialog(chatID, current); err != nil {
+			log.Printf("Failed to save pending dialog: %v", err)
+			return
+		}
+	}
+
+	b.mu.Lock()
+	if active {
+		b.savedPending[chatID] = current
+	} else {
+		delete(b.savedPending, chatID)
+	}
+	b.mu.Unlock()
+}
+
+// samePendingDialog сравнивает поля диалога, которые хранятся в pending_dialogs
+func samePendingDialog(a, c PendingReminder) bool {
+	return a.State == c.State && a.Medicine == c.Medicine && a.Hour == c.Hour && a.Minute == c.Minute &&
+		a.Anchor == c.Anchor && a.AnchorOffset == c.AnchorOffset && a.MsgID == c.MsgID &&
+		a.StartedAt.Equal(c.StartedAt) && a.ReminderID == c.ReminderID &&
+		a.CourseDays == c.CourseDays && a.CourseType == c.CourseType && a.CopyCourse == c.CopyCourse &&
+		a.IntervalMinutes == c.IntervalMinutes && a.WeekdayMask == c.WeekdayMask && a.DoseAmount == c.DoseAmount
+}
+
+// SaveState останавливает таймеры отложенных напоминаний и сохраняет их
+// вместе с незавершёнными диалогами /add, чтобы продолжить после перезапуска.
+// Диалоги уже сохраняются после каждого шага (persistPending) — здесь они
+// дописываются на случай, если последняя запись не удалась.
+func (b *Bot) SaveState(ctx context.Context) {
+	b.snoozeMu.Lock()
+	snoozes := make([]SavedSnooze, 0, len(b.snoozes))
+	for reminderID, sn := range b.snoozes {
+		if !sn.timer.Stop() {
+			// Таймер уже сработал — напоминание отправляется прямо сейчас
+			continue
+		}
+		snoozes = append(snoozes, SavedSnooze{
+			ReminderID: reminderID,
+			ChatID:     sn.chatID,
+			Language:   sn.lang,
+			Slot:       sn.slot,
+			FireAt:     sn.fireAt,
+		})
+	}
+	b.snoozes = make(map[int]*snooze)
+	b.snoozeMu.Unlock()
+
+	b.mu.RLock()
+	pending := make(map[int64]PendingReminder, len(b.pending))
+	for chatID, p := range b.pending {
+		pending[chatID] = *p
+	}
+	b.mu.RUnlock()
+
+	if err := b.storage.SaveSnoozes(ctx, snoozes); err != nil {
+		log.Printf("Failed to save snoozes: %v", err)
+	} else {
+		log.Printf("Saved %d snoozed reminders", len(snoozes))
+	}
+
+	if err := b.storage.SavePendingDialogs(ctx, pending); err != nil {
+		log.Printf("Failed to save pending dialogs: %v", err)
+	} else {
+		log.Printf("Saved %d pending dialogs", len(pending))
+	}
+}
+
+// RestoreState загружает сохранённые при остановке отложенные напоминания
+// и диалоги. Просроченные за время простоя напоминания отправляются сразу.
+// Диалоги остаются в базе, пока не завершатся, — так они переживают и падение бота.
+func (b *Bot) RestoreState() {
+	ctx := context.Background()
+
+	snoozes, err := b.storage.TakeSnoozes(ctx)
+	if err != nil {
+		log.Printf("Failed to load snoozes: %v", err)
+	}
+	for _, sn := range snoozes {
+		delay := sn.FireAt.Sub(b.now())
+		if delay < 0 {
+			delay = 0
+		}
+		b.scheduleSnooze(sn.ChatID, sn.Language, sn.ReminderID, sn.Slot, delay)
+	}
+
+	pending, err := b.storage.LoadPendingDialogs(ctx, b.now().Add(-pendingDialogTTL))
+	if err != nil {
+		log.Printf("Failed to load pending dialogs: %v", err)
+	}
+	b.mu.Lock()
+	for chatID, p := range pending {
+		b.pending[chatID] = p
+		b.savedPending[chatID] = *p
+	}
+	b.mu.Unlock()
+
+	if len(snoozes) > 0 || len(pending) > 0 {
+		log.Printf("Restored %d snoozed reminders and %d pending dialogs", len(snoozes), len(pending))
+	}
+}
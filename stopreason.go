@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// stopReason — вариант ответа на вопрос, почему пользователь отключил напоминания
+type stopReason struct {
+	Key   string // значение в stop_reasons.reason и в callback stopreason_<key>
+	Label string
+}
+
+// stopReasons — варианты причин в порядке кнопок
+var stopReasons = []stopReason{
+	{"course", "✅ Закончил курс"},
+	{"often", "🔔 Слишком часто"},
+	{"other", "🤷 Другое"},
+}
+
+// stopReasonSkip — callback кнопки "просто отключить": причина не записывается
+const stopReasonSkip = "skip"
+
+// askStopReason после /stop предлагает необязательно указать причину.
+// Напоминания уже отключены — вопрос можно проигнорировать.
+func (b *Bot) askStopReason(chatID int64) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, r := range stopReasons {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(r.Label, "stopreason_"+r.Key),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Просто отключить", "stopreason_"+stopReasonSkip),
+	))
+
+	msg := tgbotapi.NewMessage(chatID, "Подскажешь, почему отключаешь? Это поможет сделать бота лучше")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("Failed to send message: %v", err)
+	}
+}
+
+// handleStopReason сохраняет выбранную причину отключения
+func (b *Bot) handleStopReason(chatID int64, messageID int, key string) {
+	if key == stopReasonSkip {
+		b.deleteMessage(chatID, messageID)
+		return
+	}
+
+	var reason *stopReason
+	for i := range stopReasons {
+		if stopReasons[i].Key == key {
+			reason = &stopReasons[i]
+		}
+	}
+	if reason == nil {
+		b.deleteMessage(chatID, messageID)
+		return
+	}
+
+	if err := b.storage.RecordStopReason(chatID, reason.Key); err != nil {
+		log.Printf("Failed to record stop reason: %v", err)
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, "Спасибо, что рассказал! Включить напоминания снова — кнопка «▶️ Включить»")
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// stopReasonStats — блок причин отключения для /stats ("" — причин ещё не указывали)
+func (b *Bot) stopReasonStats() string {
+	counts, err := b.storage.GetStopReasonCounts()
+	if err != nil {
+		log.Printf("Failed to get stop reasons: %v", err)
+		return ""
+	}
+	if len(counts) == 0 {
+		return ""
+	}
+
+	text := "\n\n🚪 Причины отключения:"
+	for _, r := range stopReasons {
+		text += fmt.Sprintf("\n   %s: %d", r.Label, counts[r.Key])
+	}
+	return text
+}
@@ -2,31 +2,127 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Storage — доступ к PostgreSQL.
+//
+// Все методы, работающие с данными конкретного пользователя, принимают chatID
+// первым аргументом и обязаны фильтровать по нему каждую таблицу запроса
+// (reminders.chat_id, dose_log.chat_id). ID напоминания приходит от клиента
+// (callback, Web App) и сам по себе не доказывает владения: запрос вида
+// "WHERE id = $1" без "AND chat_id = $2" позволил бы изменить чужие данные.
+// Изменения одного напоминания идут через execOwnReminder, который добавляет
+// это условие сам.
 type Storage struct {
 	pool *pgxpool.Pool
+
+	maxCourseDays int // Наибольшая длина курса для любого способа создания и изменения
+}
+
+// defaultMaxCourseDays — наибольшая длина курса, если MAX_COURSE_DAYS не задан
+const defaultMaxCourseDays = 365
+
+// CourseDaysRangeError — длина курса вне допустимого диапазона 0..Max
+type CourseDaysRangeError struct {
+	CourseDays int
+	Max        int
+}
+
+func (e *CourseDaysRangeError) Error() string {
+	return fmt.Sprintf("course_days %d is out of range 0..%d", e.CourseDays, e.Max)
+}
+
+// maxMedicineLength — наибольшая длина названия лекарства в символах (не байтах).
+// Под неё setMedicineLength задаёт тип колонок medicine: VARCHAR в PostgreSQL
+// тоже считает символы, так что проверка в коде и ограничение базы совпадают.
+const maxMedicineLength = 255
+
+// MedicineTooLongError — название лекарства длиннее Max символов
+type MedicineTooLongError struct {
+	Length int
+	Max    int
+}
+
+func (e *MedicineTooLongError) Error() string {
+	return fmt.Sprintf("medicine name is %d characters long, max %d", e.Length, e.Max)
+}
+
+// checkMedicine проверяет длину названия лекарства в символах
+func checkMedicine(medicine string) error {
+	if n := utf8.RuneCountInString(medicine); n > maxMedicineLength {
+		return &MedicineTooLongError{Length: n, Max: maxMedicineLength}
+	}
+	return nil
+}
+
+// statementCacheCapacity — сколько подготовленных выражений держит одно соединение.
+// Различных запросов в боте около сотни, так что горячие не вытесняются.
+const statementCacheCapacity = 512
+
+// ErrReminderNotFound — напоминание не существует или принадлежит другому пользователю
+var ErrReminderNotFound = errors.New("reminder not found")
+
+// CourseTooShortError — новая длина курса меньше числа уже принятых доз
+type CourseTooShortError struct {
+	DosesTaken int
+}
+
+func (e *CourseTooShortError) Error() string {
+	return fmt.Sprintf("course is shorter than %d doses already taken", e.DosesTaken)
 }
 
-func NewStorage(databaseURL string) (*Storage, error) {
-	pool, err := pgxpool.New(context.Background(), databaseURL)
+// NewStorage подключается к PostgreSQL.
+//
+// Запросы выполняются с кэшем подготовленных выражений (режим pgx по умолчанию,
+// здесь он задан явно): повторный запрос с тем же текстом SQL идёт в один
+// round-trip без повторного разбора. Поэтому текст горячих запросов собирается
+// один раз, а не при каждом вызове. За PgBouncer в режиме transaction
+// кэш нужно отключить параметром DATABASE_URL default_query_exec_mode=exec.
+//
+// maxCourseDays ограничивает длину курса в AddReminder и UpdateCourseDays;
+// то же ограничение задаётся CHECK-ограничением таблицы reminders.
+func NewStorage(databaseURL string, maxCourseDays int) (*Storage, error) {
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse database URL: %w", err)
+	}
+	if !strings.Contains(databaseURL, "default_query_exec_mode") {
+		config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+	}
+	if !strings.Contains(databaseURL, "statement_cache_capacity") {
+		config.ConnConfig.StatementCacheCapacity = statementCacheCapacity
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	log.Printf("Database query mode: %s, statement cache: %d",
+		config.ConnConfig.DefaultQueryExecMode, config.ConnConfig.StatementCacheCapacity)
 
 	if err := pool.Ping(context.Background()); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	storage := &Storage{pool: pool}
+	storage := &Storage{pool: pool, maxCourseDays: maxCourseDays}
 	if err := storage.createTables(); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %w", err)
 	}
+	if err := storage.setCourseDaysCheck(); err != nil {
+		return nil, fmt.Errorf("failed to set course_days check: %w", err)
+	}
+	if err := storage.setMedicineLength(); err != nil {
+		return nil, fmt.Errorf("failed to set medicine length: %w", err)
+	}
 
 	log.Println("Connected to PostgreSQL")
 	return storage, nil
@@ -55,28 +151,280 @@ func (s *Storage) createTables() error {
 
 		CREATE INDEX IF NOT EXISTS idx_reminders_chat_id ON reminders(chat_id);
 		CREATE INDEX IF NOT EXISTS idx_reminders_time ON reminders(hour, minute);
+
+		-- Время пробуждения/сна (минуты от полуночи) и привязка напоминаний к ним
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS wake_time INT;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS sleep_time INT;
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS anchor VARCHAR(10) NOT NULL DEFAULT '';
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS anchor_offset INT NOT NULL DEFAULT 0;
+
+		-- Время последнего подтверждённого приёма (NULL — ещё не принимал)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS last_taken_at TIMESTAMPTZ;
+
+		-- Отпуск: напоминания не отправляются с vacation_from по vacation_until включительно
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS vacation_from DATE;
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS vacation_until DATE;
+
+		-- Журнал приёмов: каждое отправленное напоминание и его подтверждение.
+		-- Название лекарства дублируется, чтобы история пережила удаление напоминания.
+		CREATE TABLE IF NOT EXISTS dose_log (
+			id SERIAL PRIMARY KEY,
+			reminder_id INT REFERENCES reminders(id) ON DELETE SET NULL,
+			chat_id BIGINT REFERENCES users(chat_id) ON DELETE CASCADE,
+			medicine VARCHAR(255) NOT NULL,
+			scheduled_at TIMESTAMPTZ NOT NULL,
+			taken_at TIMESTAMPTZ,
+			status VARCHAR(16) NOT NULL DEFAULT 'scheduled',
+			UNIQUE (reminder_id, scheduled_at)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_dose_log_chat_id ON dose_log(chat_id, scheduled_at);
+
+		-- Язык интерфейса Telegram (для локализации рассылок планировщика)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS language VARCHAR(8);
+
+		-- Начало курса — первый запланированный приём (старые записи — момент создания)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS starts_at TIMESTAMPTZ;
+		UPDATE reminders SET starts_at = created_at WHERE starts_at IS NULL;
+
+		-- Основная длительность "отложить" для напоминания (0 — общая настройка)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS snooze_minutes INT NOT NULL DEFAULT 0;
+
+		-- Отложенные напоминания и незавершённые диалоги /add, сохранённые при остановке.
+		-- При старте загружаются и удаляются.
+		CREATE TABLE IF NOT EXISTS snoozes (
+			reminder_id INT PRIMARY KEY REFERENCES reminders(id) ON DELETE CASCADE,
+			chat_id BIGINT NOT NULL,
+			language VARCHAR(8) NOT NULL DEFAULT '',
+			slot TIMESTAMPTZ NOT NULL,
+			fire_at TIMESTAMPTZ NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS pending_dialogs (
+			chat_id BIGINT PRIMARY KEY,
+			state INT NOT NULL,
+			medicine VARCHAR(255) NOT NULL DEFAULT '',
+			hour INT NOT NULL DEFAULT 0,
+			minute INT NOT NULL DEFAULT 0,
+			anchor VARCHAR(10) NOT NULL DEFAULT '',
+			anchor_offset INT NOT NULL DEFAULT 0,
+			msg_id INT NOT NULL DEFAULT 0
+		);
+
+		-- Рассылки /notify и результат доставки каждому пользователю (для /resend)
+		CREATE TABLE IF NOT EXISTS broadcasts (
+			id SERIAL PRIMARY KEY,
+			text TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS broadcast_deliveries (
+			broadcast_id INT REFERENCES broadcasts(id) ON DELETE CASCADE,
+			chat_id BIGINT NOT NULL,
+			status VARCHAR(16) NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			attempts INT NOT NULL DEFAULT 1,
+			attempted_at TIMESTAMPTZ DEFAULT NOW(),
+			PRIMARY KEY (broadcast_id, chat_id)
+		);
+
+		-- Webhook для уведомлений о пропущенных дозах (например, для опекуна)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS webhook_url TEXT;
+
+		-- Пауза отдельного напоминания (в отличие от /stop, который отключает все)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT false;
+
+		-- Дата разового напоминания (NULL — ежедневное)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS fire_date DATE;
+
+		-- Последние известные username и имя из Telegram (обновляются при каждом обращении)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS username VARCHAR(64);
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS first_name VARCHAR(255);
+
+		-- Часовой пояс пользователя в формате IANA (NULL — ещё не выбран, действует пояс по умолчанию)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64);
+
+		-- Группа лекарств, которые принимаются вместе (NULL — без группы)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS group_id INT;
+
+		-- Часовой пояс отдельного напоминания ('' — пояс пользователя)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '';
+
+		-- Напоминание, настройку которого ждёт диалог (0 — диалог /add)
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS reminder_id INT NOT NULL DEFAULT 0;
+
+		-- Откуда создано напоминание: chat — диалог /add, webapp — Web App
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS source VARCHAR(16) NOT NULL DEFAULT 'chat';
+
+		-- Заметка к приёму: пользователь отвечает на сообщение с подтверждением
+		ALTER TABLE dose_log ADD COLUMN IF NOT EXISTS confirm_message_id INT;
+		ALTER TABLE dose_log ADD COLUMN IF NOT EXISTS note TEXT;
+
+		-- Предупреждение о скором окончании курса уже отправлено
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS end_notice_sent BOOLEAN NOT NULL DEFAULT FALSE;
+
+		-- Донаты. charge_id уникален: повторная доставка того же платежа не учитывается дважды
+		CREATE TABLE IF NOT EXISTS donations (
+			id SERIAL PRIMARY KEY,
+			chat_id BIGINT NOT NULL,
+			amount INT NOT NULL,
+			currency VARCHAR(8) NOT NULL,
+			payload VARCHAR(128) NOT NULL DEFAULT '',
+			charge_id VARCHAR(255) NOT NULL UNIQUE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Возврат доната через refundStarPayment
+		ALTER TABLE donations ADD COLUMN IF NOT EXISTS refunded_at TIMESTAMPTZ;
+
+		-- Важное напоминание: о неподтверждённой предыдущей дозе напоминаем отдельно
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS important BOOLEAN NOT NULL DEFAULT FALSE;
+
+		-- Без подтверждения: напоминание приходит без кнопок и засчитывается автоматически
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS require_confirm BOOLEAN NOT NULL DEFAULT TRUE;
+
+		-- Журнал действий администраторов: кто, что и когда сделал
+		CREATE TABLE IF NOT EXISTS admin_actions (
+			id SERIAL PRIMARY KEY,
+			admin_id BIGINT NOT NULL,
+			action VARCHAR(32) NOT NULL,
+			details TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_admin_actions_created_at ON admin_actions(created_at);
+
+		-- Причины отключения напоминаний через /stop (для аналитики)
+		CREATE TABLE IF NOT EXISTS stop_reasons (
+			id SERIAL PRIMARY KEY,
+			chat_id BIGINT NOT NULL,
+			reason VARCHAR(16) NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Предупреждение за несколько минут до напоминания (0 — выключено)
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS pre_alert_minutes INT NOT NULL DEFAULT 0;
+
+		-- Как поздравлять с завершением курса: emoji, plain или off
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS celebration VARCHAR(8) NOT NULL DEFAULT 'emoji';
+
+		-- Как считается курс: doses — по подтверждённым приёмам (как раньше), days — по календарю
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS course_type VARCHAR(8) NOT NULL DEFAULT 'doses';
+
+		-- Завершённые курсы: напоминание удалено, но запоздалое "Принял" на нём
+		-- отличается от нажатия на чужое или удалённое вручную напоминание
+		CREATE TABLE IF NOT EXISTS completed_courses (
+			reminder_id INT PRIMARY KEY,
+			chat_id BIGINT NOT NULL REFERENCES users(chat_id) ON DELETE CASCADE,
+			medicine VARCHAR(255) NOT NULL,
+			completed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+
+		-- Шаг минут при выборе времени: 5, 10, 15 (как раньше) или 30
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS minute_step INT NOT NULL DEFAULT 15;
+
+		-- Как присылать напоминания: dose — по одному (как раньше), halves — утренние и вечерние одним сообщением
+		ALTER TABLE users ADD COLUMN IF NOT EXISTS bundling VARCHAR(8) NOT NULL DEFAULT 'dose';
+
+		-- Пульс ведущего планировщика: кто рассылает напоминания и докуда дошёл.
+		-- Одна строка; ведущий выбирается advisory-блокировкой (TryLockScheduler)
+		CREATE TABLE IF NOT EXISTS scheduler_heartbeat (
+			id INT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+			instance VARCHAR(128) NOT NULL,
+			leader_since TIMESTAMPTZ NOT NULL,
+			beat_at TIMESTAMPTZ NOT NULL,
+			last_slot TIMESTAMPTZ
+		);
+
+		-- Завершённые курсы остаются в reminders с временем завершения — это история /history.
+		-- В completed_courses остаются только курсы, завершённые раньше: их напоминания удалены
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS completed_at TIMESTAMPTZ;
+
+		-- Диалог /add сохраняется после каждого шага: начало диалога (для устаревания) и выбор курса
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS started_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS course_days INT NOT NULL DEFAULT 0;
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS course_type VARCHAR(8) NOT NULL DEFAULT '';
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS copy_course BOOLEAN NOT NULL DEFAULT FALSE;
+
+		-- Приём каждые N часов: интервал в минутах от hour:minute, 0 — раз в день
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS interval_minutes INT NOT NULL DEFAULT 0;
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS interval_minutes INT NOT NULL DEFAULT 0;
+
+		-- Дни недели приёма: бит 0 — понедельник … бит 6 — воскресенье, 127 — каждый день
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS weekday_mask SMALLINT NOT NULL DEFAULT 127;
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS weekday_mask SMALLINT NOT NULL DEFAULT 0;
+
+		-- Сколько раз повторено неподтверждённое напоминание (NUDGE_MINUTES)
+		ALTER TABLE dose_log ADD COLUMN IF NOT EXISTS nudges INT NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS idx_dose_log_scheduled ON dose_log(scheduled_at) WHERE status = 'scheduled';
+
+		-- Дозировка: сколько принимать за раз ("" — не указана)
+		ALTER TABLE reminders ADD COLUMN IF NOT EXISTS dose_amount VARCHAR(64) NOT NULL DEFAULT '';
+		ALTER TABLE pending_dialogs ADD COLUMN IF NOT EXISTS dose_amount VARCHAR(64) NOT NULL DEFAULT '';
 	`)
 
 	return err
 }
 
+// setCourseDaysCheck пересоздаёт CHECK-ограничение длины курса под текущий
+// maxCourseDays. Ограничение NOT VALID: старые записи не проверяются,
+// чтобы уменьшение MAX_COURSE_DAYS не мешало запуску.
+func (s *Storage) setCourseDaysCheck() error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`
+		ALTER TABLE reminders DROP CONSTRAINT IF EXISTS reminders_course_days_range;
+		ALTER TABLE reminders ADD CONSTRAINT reminders_course_days_range
+			CHECK (course_days >= 0 AND course_days <= %d) NOT VALID;
+	`, s.maxCourseDays))
+	return err
+}
+
+// setMedicineLength приводит колонки medicine к VARCHAR(maxMedicineLength).
+// Для неизменной или увеличенной длины таблица не переписывается; уменьшение
+// ниже длины уже сохранённых названий остановит запуск с ошибкой базы.
+func (s *Storage) setMedicineLength() error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, fmt.Sprintf(`
+		ALTER TABLE reminders ALTER COLUMN medicine TYPE VARCHAR(%[1]d);
+		ALTER TABLE dose_log ALTER COLUMN medicine TYPE VARCHAR(%[1]d);
+		ALTER TABLE pending_dialogs ALTER COLUMN medicine TYPE VARCHAR(%[1]d);
+		ALTER TABLE completed_courses ALTER COLUMN medicine TYPE VARCHAR(%[1]d);
+	`, maxMedicineLength))
+	return err
+}
+
+// MaxCourseDays возвращает наибольшую допустимую длину курса
+func (s *Storage) MaxCourseDays() int {
+	return s.maxCourseDays
+}
+
+// checkCourseDays проверяет длину курса: 0 (бесконечно) .. maxCourseDays
+func (s *Storage) checkCourseDays(courseDays int) error {
+	if courseDays < 0 || courseDays > s.maxCourseDays {
+		return &CourseDaysRangeError{CourseDays: courseDays, Max: s.maxCourseDays}
+	}
+	return nil
+}
+
 func (s *Storage) Close() {
 	s.pool.Close()
 }
 
-// GetOrCreateUser возвращает пользователя, создаёт если не существует
-func (s *Storage) GetOrCreateUser(chatID int64) (*User, error) {
+// GetOrCreateUser возвращает пользователя, создаёт если не существует.
+// created — пользователь создан этим вызовом.
+func (s *Storage) GetOrCreateUser(chatID int64) (user *User, created bool, err error) {
 	ctx := context.Background()
 
-	_, err := s.pool.Exec(ctx, `
+	tag, err := s.pool.Exec(ctx, `
 		INSERT INTO users (chat_id, active) VALUES ($1, true)
 		ON CONFLICT (chat_id) DO NOTHING
 	`, chatID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	return s.GetUser(chatID)
+	user, err = s.GetUser(chatID)
+	return user, tag.RowsAffected() > 0, err
 }
 
 // GetUser возвращает пользователя по chat_id
@@ -84,9 +432,14 @@ func (s *Storage) GetUser(chatID int64) (*User, error) {
 	ctx := context.Background()
 
 	var active bool
+	var wakeTime, sleepTime *int
+	var vacationFrom, vacationUntil *time.Time
+	var webhookURL, username, firstName string
 	err := s.pool.QueryRow(ctx, `
-		SELECT active FROM users WHERE chat_id = $1
-	`, chatID).Scan(&active)
+		SELECT active, wake_time, sleep_time, vacation_from, vacation_until, COALESCE(webhook_url, ''),
+			COALESCE(username, ''), COALESCE(first_name, '')
+		FROM users WHERE chat_id = $1
+	`, chatID).Scan(&active, &wakeTime, &sleepTime, &vacationFrom, &vacationUntil, &webhookURL, &username, &firstName)
 
 	if err == pgx.ErrNoRows {
 		return nil, nil
@@ -101,9 +454,16 @@ func (s *Storage) GetUser(chatID int64) (*User, error) {
 	}
 
 	return &User{
-		ChatID:    chatID,
-		Active:    active,
-		Reminders: reminders,
+		ChatID:        chatID,
+		Active:        active,
+		Reminders:     reminders,
+		WakeTime:      wakeTime,
+		SleepTime:     sleepTime,
+		VacationFrom:  vacationFrom,
+		VacationUntil: vacationUntil,
+		WebhookURL:    webhookURL,
+		Username:      username,
+		FirstName:     firstName,
 	}, nil
 }
 
@@ -116,147 +476,2231 @@ func (s *Storage) SetUserActive(chatID int64, active bool) error {
 	return err
 }
 
-// GetReminders возвращает все напоминания пользователя
-func (s *Storage) GetReminders(chatID int64) ([]Reminder, error) {
+// UpdateUserProfile сохраняет последние известные username, имя и язык пользователя.
+// Пустой language не затирает сохранённый. Запись обновляется, только если что-то изменилось.
+func (s *Storage) UpdateUserProfile(chatID int64, username, firstName, language string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users
+		SET username = NULLIF($2, ''), first_name = NULLIF($3, ''), language = COALESCE(NULLIF($4, ''), language)
+		WHERE chat_id = $1
+		  AND (username IS DISTINCT FROM NULLIF($2, '')
+		    OR first_name IS DISTINCT FROM NULLIF($3, '')
+		    OR ($4 <> '' AND language IS DISTINCT FROM $4))
+	`, chatID, username, firstName, language)
+	return err
+}
+
+// GetUserLanguages возвращает языки указанных пользователей одним запросом.
+// Пользователи без сохранённого языка в результат не попадают.
+func (s *Storage) GetUserLanguages(chatIDs []int64) (map[int64]string, error) {
 	ctx := context.Background()
 
 	rows, err := s.pool.Query(ctx, `
-		SELECT id, medicine, hour, minute, course_days, doses_taken
-		FROM reminders WHERE chat_id = $1
-		ORDER BY hour, minute
-	`, chatID)
+		SELECT chat_id, language FROM users
+		WHERE chat_id = ANY($1) AND language IS NOT NULL
+	`, chatIDs)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var reminders []Reminder
+	result := make(map[int64]string)
 	for rows.Next() {
-		var r Reminder
-		if err := rows.Scan(&r.ID, &r.Medicine, &r.Hour, &r.Minute, &r.CourseDays, &r.DosesTaken); err != nil {
+		var chatID int64
+		var language string
+		if err := rows.Scan(&chatID, &language); err != nil {
 			return nil, err
 		}
-		reminders = append(reminders, r)
+		result[chatID] = language
 	}
 
-	return reminders, rows.Err()
+	return result, rows.Err()
+}
+
+// SetWebhookURL сохраняет webhook для уведомлений о пропущенных дозах ("" — отключить)
+func (s *Storage) SetWebhookURL(chatID int64, webhookURL string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET webhook_url = NULLIF($1, '') WHERE chat_id = $2
+	`, webhookURL, chatID)
+	return err
 }
 
-// AddReminder добавляет напоминание и возвращает его ID
-func (s *Storage) AddReminder(chatID int64, medicine string, hour, minute, courseDays int) (int, error) {
+// GetUserTimezone возвращает часовой пояс пользователя ("" — не выбран)
+func (s *Storage) GetUserTimezone(chatID int64) (string, error) {
 	ctx := context.Background()
 
-	var id int
+	var timezone string
 	err := s.pool.QueryRow(ctx, `
-		INSERT INTO reminders (chat_id, medicine, hour, minute, course_days)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id
-	`, chatID, medicine, hour, minute, courseDays).Scan(&id)
+		SELECT COALESCE(timezone, '') FROM users WHERE chat_id = $1
+	`, chatID).Scan(&timezone)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return timezone, err
+}
 
-	return id, err
+// GetPreAlertMinutes возвращает, за сколько минут предупреждать о напоминании (0 — не предупреждать)
+func (s *Storage) GetPreAlertMinutes(chatID int64) (int, error) {
+	ctx := context.Background()
+
+	var minutes int
+	err := s.pool.QueryRow(ctx, `
+		SELECT pre_alert_minutes FROM users WHERE chat_id = $1
+	`, chatID).Scan(&minutes)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	}
+	return minutes, err
 }
 
-// DeleteReminder удаляет напоминание
-func (s *Storage) DeleteReminder(chatID int64, reminderID int) error {
+// SetPreAlertMinutes сохраняет, за сколько минут предупреждать о напоминании (0 — выключить)
+func (s *Storage) SetPreAlertMinutes(chatID int64, minutes int) error {
 	ctx := context.Background()
 	_, err := s.pool.Exec(ctx, `
-		DELETE FROM reminders WHERE id = $1 AND chat_id = $2
-	`, reminderID, chatID)
+		UPDATE users SET pre_alert_minutes = $1 WHERE chat_id = $2
+	`, minutes, chatID)
+	return err
+}
+
+// GetCelebration возвращает, как поздравлять пользователя с завершением курса
+// ("" — пользователь ещё не сохранён)
+func (s *Storage) GetCelebration(chatID int64) (string, error) {
+	ctx := context.Background()
+
+	var style string
+	err := s.pool.QueryRow(ctx, `
+		SELECT celebration FROM users WHERE chat_id = $1
+	`, chatID).Scan(&style)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return style, err
+}
+
+// SetCelebration сохраняет, как поздравлять пользователя с завершением курса
+func (s *Storage) SetCelebration(chatID int64, style string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET celebration = $1 WHERE chat_id = $2
+	`, style, chatID)
+	return err
+}
+
+// GetBundling возвращает, как пользователь получает напоминания ("" — пользователя нет)
+func (s *Storage) GetBundling(chatID int64) (string, error) {
+	ctx := context.Background()
+
+	var bundling string
+	err := s.pool.QueryRow(ctx, `
+		SELECT bundling FROM users WHERE chat_id = $1
+	`, chatID).Scan(&bundling)
+	if err == pgx.ErrNoRows {
+		return "", nil
+	}
+	return bundling, err
+}
+
+// SetBundling сохраняет, как пользователь получает напоминания
+func (s *Storage) SetBundling(chatID int64, bundling string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET bundling = $1 WHERE chat_id = $2
+	`, bundling, chatID)
 	return err
 }
 
-// GetRemindersForTime возвращает напоминания для указанного времени
-func (s *Storage) GetRemindersForTime(hour, minute int) (map[int64][]Reminder, error) {
+// GetBundlingUsers возвращает, кто из указанных пользователей получает
+// напоминания пачками (bundling не dose), одним запросом
+func (s *Storage) GetBundlingUsers(chatIDs []int64) (map[int64]string, error) {
 	ctx := context.Background()
 
 	rows, err := s.pool.Query(ctx, `
-		SELECT r.chat_id, r.id, r.medicine, r.hour, r.minute, r.course_days, r.doses_taken
-		FROM reminders r
-		JOIN users u ON r.chat_id = u.chat_id
-		WHERE r.hour = $1 AND r.minute = $2
-		  AND u.active = true
-		  AND (r.course_days = 0 OR r.doses_taken < r.course_days)
-	`, hour, minute)
+		SELECT chat_id, bundling FROM users
+		WHERE chat_id = ANY($1) AND bundling <> 'dose'
+	`, chatIDs)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	result := make(map[int64][]Reminder)
+	result := make(map[int64]string)
 	for rows.Next() {
 		var chatID int64
-		var r Reminder
-		if err := rows.Scan(&chatID, &r.ID, &r.Medicine, &r.Hour, &r.Minute, &r.CourseDays, &r.DosesTaken); err != nil {
+		var bundling string
+		if err := rows.Scan(&chatID, &bundling); err != nil {
 			return nil, err
 		}
-		result[chatID] = append(result[chatID], r)
+		result[chatID] = bundling
 	}
 
 	return result, rows.Err()
 }
 
-// IncrementDoseTaken увеличивает счётчик и возвращает информацию о напоминании
-func (s *Storage) IncrementDoseTaken(chatID int64, reminderID int) (medicineName string, newCount int, total int, completed bool, err error) {
+// GetMinuteStep возвращает шаг минут пользователя (0, если пользователя нет)
+func (s *Storage) GetMinuteStep(chatID int64) (int, error) {
 	ctx := context.Background()
 
-	err = s.pool.QueryRow(ctx, `
-		UPDATE reminders
-		SET doses_taken = doses_taken + 1
-		WHERE id = $1 AND chat_id = $2
-		RETURNING medicine, doses_taken, course_days
-	`, reminderID, chatID).Scan(&medicineName, &newCount, &total)
-
+	var step int
+	err := s.pool.QueryRow(ctx, `
+		SELECT minute_step FROM users WHERE chat_id = $1
+	`, chatID).Scan(&step)
 	if err == pgx.ErrNoRows {
-		return "", 0, 0, false, nil
+		return 0, nil
 	}
+	return step, err
+}
+
+// SetMinuteStep сохраняет шаг минут, с которым пользователь выбирает время
+func (s *Storage) SetMinuteStep(chatID int64, step int) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET minute_step = $1 WHERE chat_id = $2
+	`, step, chatID)
+	return err
+}
+
+// GetFinestMinuteStep возвращает, в какие минуты планировщику нужно проверять
+// напоминания: наибольший делитель часа, шагов минут всех пользователей и минут
+// всех напоминаний. Напоминания, созданные до смены шага на более крупный,
+// по-прежнему приходят вовремя.
+func (s *Storage) GetFinestMinuteStep() (int, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT minute_step FROM users
+		UNION
+		SELECT minute FROM reminders WHERE completed_at IS NULL
+	`)
 	if err != nil {
-		return "", 0, 0, false, err
+		return 0, err
 	}
+	defer rows.Close()
 
-	completed = total > 0 && newCount >= total
-	if completed {
-		s.DeleteReminder(chatID, reminderID)
+	step := 60
+	for rows.Next() {
+		var m int
+		if err := rows.Scan(&m); err != nil {
+			return 0, err
+		}
+		for m != 0 {
+			step, m = m, step%m
+		}
 	}
+	return step, rows.Err()
+}
 
-	return medicineName, newCount, total, completed, nil
+// SetUserTimezone сохраняет часовой пояс пользователя ("" — сбросить на пояс по умолчанию).
+// Пояс, неизвестный PostgreSQL, не сохраняется: иначе он сломал бы выборку напоминаний для всех.
+func (s *Storage) SetUserTimezone(chatID int64, timezone string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET timezone = NULLIF($1, '')
+		WHERE chat_id = $2 AND (NOW() AT TIME ZONE COALESCE(NULLIF($1, ''), 'UTC')) IS NOT NULL
+	`, timezone, chatID)
+	return err
 }
 
-// GetStats возвращает статистику для админа
-func (s *Storage) GetStats() (totalUsers, activeUsers, totalReminders, finiteCourses, infiniteCourses, totalDosesTaken, totalDosesPlanned int, err error) {
+// SetVacation задаёт период отпуска пользователя (даты включительно).
+// nil в обоих аргументах отменяет отпуск.
+func (s *Storage) SetVacation(chatID int64, from, until *time.Time) error {
 	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE users SET vacation_from = $1, vacation_until = $2 WHERE chat_id = $3
+	`, from, until, chatID)
+	return err
+}
 
-	err = s.pool.QueryRow(ctx, `
-		SELECT
-			(SELECT COUNT(*) FROM users),
-			(SELECT COUNT(*) FROM users WHERE active = true),
-			(SELECT COUNT(*) FROM reminders),
-			(SELECT COUNT(*) FROM reminders WHERE course_days > 0),
-			(SELECT COUNT(*) FROM reminders WHERE course_days = 0),
-			(SELECT COALESCE(SUM(doses_taken), 0) FROM reminders),
-			(SELECT COALESCE(SUM(course_days), 0) FROM reminders WHERE course_days > 0)
-	`).Scan(&totalUsers, &activeUsers, &totalReminders, &finiteCourses, &infiniteCourses, &totalDosesTaken, &totalDosesPlanned)
+// reminderFields — колонки reminders, из которых собирается Reminder.
+// Порядок совпадает с reminderScanArgs.
+var reminderFields = []string{
+	"id", "medicine", "hour", "minute", "course_days", "doses_taken",
+	"anchor", "anchor_offset", "last_taken_at", "starts_at", "snooze_minutes", "paused",
+	"fire_date", "group_id", "timezone", "source", "important", "require_confirm",
+	"course_type", "interval_minutes", "weekday_mask", "dose_amount",
+}
 
-	return
+// Условия отбора для запросов с алиасами u (users) и r (reminders).
+// "Активность" бывает двух видов, и они не взаимозаменяемы:
+//   - userActiveCond — пользователь не отключил все напоминания через /stop (users.active);
+//   - reminderRunnableCond — конкретное напоминание не в истории (completed_at), не на паузе
+//     и его курс не завершён: курс по приёмам — не все дозы подтверждены, курс по дням —
+//     не прошли все дни.
+//
+// Напоминание отправляется, только если выполнены оба условия.
+const (
+	userActiveCond       = "u.active = true"
+	reminderRunnableCond = "r.completed_at IS NULL AND NOT r.paused AND (r.course_days = 0 OR " +
+		"(r.course_type = '" + CourseByDays + "' AND r.starts_at + r.course_days * INTERVAL '1 day' > NOW()) OR " +
+		"(r.course_type <> '" + CourseByDays + "' AND r.doses_taken < r.course_days))"
+)
+
+// reminderColumns возвращает список колонок напоминания для SELECT с указанным алиасом таблицы
+func reminderColumns(alias string) string {
+	cols := make([]string, len(reminderFields))
+	for i, f := range reminderFields {
+		cols[i] = alias + "." + f
+	}
+	return strings.Join(cols, ", ")
+}
+
+// reminderScanArgs возвращает указатели на поля напоминания в порядке reminderFields
+func reminderScanArgs(r *Reminder) []any {
+	return []any{
+		&r.ID, &r.Medicine, &r.Hour, &r.Minute, &r.CourseDays, &r.DosesTaken,
+		&r.Anchor, &r.AnchorOffset, &r.LastTakenAt, &r.StartsAt, &r.SnoozeMinutes, &r.Paused,
+		&r.FireDate, &r.GroupID, &r.Timezone, &r.Source, &r.Important, &r.RequireConfirm,
+		&r.CourseType, &r.IntervalMinutes, &r.WeekdayMask, &r.DoseAmount,
+	}
 }
 
-// GetAllUsers возвращает все chat_id пользователей
-func (s *Storage) GetAllUsers() ([]int64, error) {
+// GetReminders возвращает все напоминания пользователя, кроме завершённых курсов
+// (они в истории — GetCompletedCourses)
+func (s *Storage) GetReminders(chatID int64) ([]Reminder, error) {
 	ctx := context.Background()
 
-	rows, err := s.pool.Query(ctx, `SELECT chat_id FROM users`)
+	rows, err := s.pool.Query(ctx, `
+		SELECT `+reminderColumns("r")+`
+		FROM reminders r WHERE r.chat_id = $1 AND r.completed_at IS NULL
+		ORDER BY r.hour, r.minute
+	`, chatID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var chatIDs []int64
+	var reminders []Reminder
 	for rows.Next() {
-		var id int64
-		if err := rows.Scan(&id); err != nil {
+		var r Reminder
+		if err := rows.Scan(reminderScanArgs(&r)...); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+
+	return reminders, rows.Err()
+}
+
+// GetReminder возвращает напоминание пользователя по ID (nil — не найдено или курс завершён)
+func (s *Storage) GetReminder(chatID int64, reminderID int) (*Reminder, error) {
+	ctx := context.Background()
+
+	var r Reminder
+	err := s.pool.QueryRow(ctx, `
+		SELECT `+reminderColumns("r")+`
+		FROM reminders r WHERE r.id = $1 AND r.chat_id = $2 AND r.completed_at IS NULL
+	`, reminderID, chatID).Scan(reminderScanArgs(&r)...)
+
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// AddReminder добавляет напоминание и возвращает его ID.
+// source — откуда создано напоминание (ReminderSourceChat, ReminderSourceWebApp, ReminderSourceImport).
+// Длина курса вне 0..MaxCourseDays возвращает *CourseDaysRangeError,
+// название длиннее maxMedicineLength символов — *MedicineTooLongError.
+func (s *Storage) AddReminder(chatID int64, r Reminder, source string) (int, error) {
+	return s.addReminder(context.Background(), s.pool, chatID, r, source)
+}
+
+// AddReminders добавляет несколько напоминаний в одной транзакции: при ошибке
+// в любом из них не добавляется ни одно. Возвращает ID в порядке reminders.
+// Ошибки проверки — как у AddReminder.
+func (s *Storage) AddReminders(chatID int64, reminders []Reminder, source string) ([]int, error) {
+	ctx := context.Background()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	ids := make([]int, 0, len(reminders))
+	for _, r := range reminders {
+		id, err := s.addReminder(ctx, tx, chatID, r, source)
+		if err != nil {
 			return nil, err
 		}
-		chatIDs = append(chatIDs, id)
+		ids = append(ids, id)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// queryRower — общее у пула и транзакции: addReminder работает с обоими
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// addReminder проверяет и вставляет напоминание через q — пул или транзакцию
+func (s *Storage) addReminder(ctx context.Context, q queryRower, chatID int64, r Reminder, source string) (int, error) {
+	if err := s.checkCourseDays(r.CourseDays); err != nil {
+		return 0, err
+	}
+	if err := checkMedicine(r.Medicine); err != nil {
+		return 0, err
+	}
+
+	courseType := r.CourseType
+	if courseType == "" {
+		courseType = CourseByDoses
+	}
+
+	var id int
+	err := q.QueryRow(ctx, `
+		INSERT INTO reminders (chat_id, medicine, hour, minute, course_days, anchor, anchor_offset, starts_at, fire_date, source, course_type,
+		                       interval_minutes, weekday_mask, dose_amount)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id
+	`, chatID, r.Medicine, r.Hour, r.Minute, r.CourseDays, r.Anchor, r.AnchorOffset, r.StartsAt, r.FireDate, source, courseType,
+		r.IntervalMinutes, r.weekdays(), r.DoseAmount).Scan(&id)
+
+	return id, err
+}
+
+// SetRoutineTime сохраняет время пробуждения или сна (минуты от полуночи)
+// и пересчитывает время всех привязанных к нему напоминаний.
+// Возвращает количество пересчитанных напоминаний.
+func (s *Storage) SetRoutineTime(chatID int64, anchor string, minutes int) (int, error) {
+	ctx := context.Background()
+
+	column := "wake_time"
+	if anchor == AnchorSleep {
+		column = "sleep_time"
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET `+column+` = $1 WHERE chat_id = $2`, minutes, chatID); err != nil {
+		return 0, err
+	}
+
+	// Смещение может перейти через полночь — нормализуем в диапазон 0..1439
+	tag, err := tx.Exec(ctx, `
+		UPDATE reminders
+		SET hour = ((($1 + anchor_offset) % 1440 + 1440) % 1440) / 60,
+		    minute = ((($1 + anchor_offset) % 1440 + 1440) % 1440) % 60
+		WHERE chat_id = $2 AND anchor = $3 AND completed_at IS NULL
+	`, minutes, chatID, anchor)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), tx.Commit(ctx)
+}
+
+// ShiftReminders сдвигает время всех напоминаний с фиксированным временем на minutes минут.
+// Время переходит через полночь по кругу (23:30 + 1 ч → 00:30); дата разового
+// напоминания при этом сдвигается на соседний день. Напоминания, привязанные
+// к распорядку дня, не меняются — их время задаётся через /wake и /sleep.
+// Возвращает количество сдвинутых напоминаний.
+func (s *Storage) ShiftReminders(chatID int64, minutes int) (int, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE reminders
+		SET hour = (((hour * 60 + minute + $2) % 1440 + 1440) % 1440) / 60,
+		    minute = (((hour * 60 + minute + $2) % 1440 + 1440) % 1440) % 60,
+		    fire_date = fire_date + floor((hour * 60 + minute + $2) / 1440.0)::int,
+		    starts_at = starts_at + make_interval(mins => $2)
+		WHERE chat_id = $1 AND anchor = '' AND completed_at IS NULL
+	`, chatID, minutes)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// UpdateReminderTime переносит напоминание на другое время суток, снимая
+// привязку к распорядку. Принятые дозы и курс сохраняются; ещё не начавшийся
+// курс сдвигается вместе со временем. Возвращает ErrReminderNotFound,
+// если у пользователя нет напоминания с таким ID.
+func (s *Storage) UpdateReminderTime(chatID int64, reminderID, hour, minute int) error {
+	return s.updateReminderTime(chatID, reminderID, hour, minute, "", 0)
+}
+
+// UpdateReminderAnchor переносит напоминание на время от пробуждения или сна:
+// hour и minute — уже посчитанное время суток для anchor и offset
+func (s *Storage) UpdateReminderAnchor(chatID int64, reminderID int, anchor string, offset, hour, minute int) error {
+	return s.updateReminderTime(chatID, reminderID, hour, minute, anchor, offset)
+}
+
+func (s *Storage) updateReminderTime(chatID int64, reminderID, hour, minute int, anchor string, offset int) error {
+	return s.execOwnReminder(chatID, reminderID, `
+		UPDATE reminders
+		SET hour = $3, minute = $4, anchor = $5, anchor_offset = $6,
+		    starts_at = CASE WHEN starts_at > NOW()
+		        THEN starts_at + make_interval(mins => $3 * 60 + $4 - (hour * 60 + minute))
+		        ELSE starts_at END
+	`, "", hour, minute, anchor, offset)
+}
+
+// execOwnReminder выполняет изменение одного незавершённого напоминания
+// пользователя. Условие владения (id = $1, chat_id = $2) дописывает сам —
+// его нельзя забыть в отдельном запросе. stmt — запрос без WHERE с параметрами
+// args начиная с $3, cond — дополнительное условие ("" — без него).
+// Возвращает ErrReminderNotFound, если у пользователя нет такого напоминания.
+func (s *Storage) execOwnReminder(chatID int64, reminderID int, stmt, cond string, args ...any) error {
+	where := " WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL"
+	if cond != "" {
+		where += " AND " + cond
+	}
+
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, stmt+where, append([]any{reminderID, chatID}, args...)...)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrReminderNotFound
+	}
+	return nil
+}
+
+// DeleteReminder удаляет напоминание. Возвращает ErrReminderNotFound,
+// если у пользователя нет такого напоминания или его курс уже в истории.
+func (s *Storage) DeleteReminder(chatID int64, reminderID int) error {
+	return s.execOwnReminder(chatID, reminderID, `DELETE FROM reminders`, "")
+}
+
+// CompleteReminder переносит напоминание с завершённым курсом в историю: оно
+// больше не приходит и не показывается в /list, но остаётся с принятыми дозами
+// для /history. Запоздалое подтверждение приёма вернёт ErrCourseCompleted.
+// Возвращает ErrReminderNotFound, если у пользователя нет незавершённого напоминания с таким ID.
+func (s *Storage) CompleteReminder(chatID int64, reminderID int) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET completed_at = NOW()`, "")
+}
+
+// CompletedCourse — завершённый курс из истории
+type CompletedCourse struct {
+	Medicine    string
+	CourseDays  int
+	CourseType  string
+	DosesTaken  int
+	StartsAt    *time.Time // nil — курс завершён до появления истории, от него остались название и дата
+	CompletedAt time.Time
+}
+
+// GetCompletedCourses возвращает не больше limit завершённых курсов пользователя,
+// последние первыми. Курсы, завершённые до появления истории, берутся из completed_courses.
+func (s *Storage) GetCompletedCourses(chatID int64, limit int) ([]CompletedCourse, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT medicine, course_days, course_type, doses_taken, starts_at, completed_at
+		FROM reminders WHERE chat_id = $1 AND completed_at IS NOT NULL
+		UNION ALL
+		SELECT medicine, 0, '', 0, NULL, completed_at
+		FROM completed_courses WHERE chat_id = $1
+		ORDER BY completed_at DESC
+		LIMIT $2
+	`, chatID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var courses []CompletedCourse
+	for rows.Next() {
+		var c CompletedCourse
+		if err := rows.Scan(&c.Medicine, &c.CourseDays, &c.CourseType, &c.DosesTaken, &c.StartsAt, &c.CompletedAt); err != nil {
+			return nil, err
+		}
+		courses = append(courses, c)
+	}
+
+	return courses, rows.Err()
+}
+
+// SetReminderSnooze задаёт основную длительность "отложить" для напоминания.
+// 0 возвращает общую настройку.
+func (s *Storage) SetReminderSnooze(chatID int64, reminderID int, minutes int) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET snooze_minutes = $3`, "", minutes)
+}
+
+// SetReminderPaused ставит напоминание на паузу или снимает с неё
+func (s *Storage) SetReminderPaused(chatID int64, reminderID int, paused bool) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET paused = $3`, "", paused)
+}
+
+// SetReminderImportant включает или выключает напоминание о пропущенной дозе
+func (s *Storage) SetReminderImportant(chatID int64, reminderID int, important bool) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET important = $3`, "", important)
+}
+
+// SetReminderRequireConfirm включает или выключает кнопку "Принял" у напоминания
+func (s *Storage) SetReminderRequireConfirm(chatID int64, reminderID int, require bool) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET require_confirm = $3`, "", require)
+}
+
+// UpdateCourseDays меняет длину курса (0 — бесконечный).
+// Курс по приёмам короче уже принятых доз не сохраняется — возвращается *CourseTooShortError.
+// Если новая длина равна числу принятых доз (для курса по дням — все дни уже прошли),
+// курс сразу завершён: completed = true, а завершить его (итоги и перенос
+// в историю — CompleteReminder) должен вызывающий.
+// Длина вне 0..MaxCourseDays возвращает *CourseDaysRangeError.
+func (s *Storage) UpdateCourseDays(chatID int64, reminderID int, courseDays int) (completed bool, err error) {
+	ctx := context.Background()
+
+	if err := s.checkCourseDays(courseDays); err != nil {
+		return false, err
+	}
+
+	// progress — пройденная часть курса: для курса по дням $1, если все дни прошли
+	var progress int
+	err = s.pool.QueryRow(ctx, `
+		UPDATE reminders SET course_days = $1
+		WHERE id = $2 AND chat_id = $3 AND completed_at IS NULL AND ($1 = 0 OR course_type = $4 OR doses_taken <= $1)
+		RETURNING CASE
+			WHEN $1 = 0 THEN 0
+			WHEN course_type = $4 THEN CASE WHEN starts_at + $1 * INTERVAL '1 day' <= NOW() THEN $1 ELSE 0 END
+			ELSE doses_taken
+		END
+	`, courseDays, reminderID, chatID, CourseByDays).Scan(&progress)
+	if err == pgx.ErrNoRows {
+		// Либо напоминания нет, либо курс получился бы короче принятого
+		var dosesTaken int
+		err = s.pool.QueryRow(ctx, `
+			SELECT doses_taken FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL
+		`, reminderID, chatID).Scan(&dosesTaken)
+		if err == pgx.ErrNoRows {
+			return false, ErrReminderNotFound
+		}
+		if err != nil {
+			return false, err
+		}
+		return false, &CourseTooShortError{DosesTaken: dosesTaken}
+	}
+	if err != nil {
+		return false, err
+	}
+	return courseDays > 0 && progress >= courseDays, nil
+}
+
+// LinkReminders объединяет два напоминания в группу "принимать вместе".
+// Если одно из них уже в группе, второе присоединяется к ней;
+// если оба в разных группах, группы сливаются.
+func (s *Storage) LinkReminders(chatID int64, reminderID, otherID int) error {
+	ctx := context.Background()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, group_id FROM reminders
+		WHERE chat_id = $1 AND id = ANY($2) AND completed_at IS NULL
+		FOR UPDATE
+	`, chatID, []int{reminderID, otherID})
+	if err != nil {
+		return err
+	}
+	groups := make(map[int]*int)
+	for rows.Next() {
+		var id int
+		var groupID *int
+		if err := rows.Scan(&id, &groupID); err != nil {
+			rows.Close()
+			return err
+		}
+		groups[id] = groupID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(groups) != 2 {
+		return ErrReminderNotFound
+	}
+
+	// ID группы — ID одного из её напоминаний; существующая группа сохраняет свой
+	target := min(reminderID, otherID)
+	var merge []int
+	for _, groupID := range groups {
+		if groupID != nil {
+			merge = append(merge, *groupID)
+			target = *groupID
+		}
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE reminders SET group_id = $1
+		WHERE chat_id = $2 AND (id = ANY($3) OR group_id = ANY($4))
+	`, target, chatID, []int{reminderID, otherID}, merge)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UnlinkReminder убирает напоминание из группы. Если в группе остаётся
+// одно напоминание, группа распускается.
+func (s *Storage) UnlinkReminder(chatID int64, reminderID int) error {
+	ctx := context.Background()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var groupID *int
+	err = tx.QueryRow(ctx, `
+		UPDATE reminders r SET group_id = NULL
+		FROM (SELECT group_id FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL FOR UPDATE) old
+		WHERE r.id = $1 AND r.chat_id = $2
+		RETURNING old.group_id
+	`, reminderID, chatID).Scan(&groupID)
+	if err == pgx.ErrNoRows {
+		return ErrReminderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if groupID != nil {
+		_, err = tx.Exec(ctx, `
+			UPDATE reminders SET group_id = NULL
+			WHERE chat_id = $1 AND group_id = $2
+			  AND (SELECT COUNT(*) FROM reminders WHERE chat_id = $1 AND group_id = $2) = 1
+		`, chatID, *groupID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// SetReminderTimezone задаёт часовой пояс напоминания ("" — как у пользователя).
+// Пояс, неизвестный PostgreSQL, не сохраняется — как и в SetUserTimezone.
+func (s *Storage) SetReminderTimezone(chatID int64, reminderID int, timezone string) error {
+	return s.execOwnReminder(chatID, reminderID, `UPDATE reminders SET timezone = $3`,
+		`(NOW() AT TIME ZONE COALESCE(NULLIF($3, ''), 'UTC')) IS NOT NULL`, timezone)
+}
+
+// GetFinishedDayCourses возвращает напоминания с курсом по дням, последний день
+// которых закончился раньше before, — их последнюю дозу уже нельзя подтвердить
+func (s *Storage) GetFinishedDayCourses(before time.Time) (map[int64][]Reminder, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT r.chat_id, `+reminderColumns("r")+`
+		FROM reminders r
+		WHERE r.course_type = $1 AND r.course_days > 0 AND r.completed_at IS NULL
+		  AND r.starts_at + r.course_days * INTERVAL '1 day' <= $2
+	`, CourseByDays, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64][]Reminder)
+	for rows.Next() {
+		var chatID int64
+		var r Reminder
+		if err := rows.Scan(append([]any{&chatID}, reminderScanArgs(&r)...)...); err != nil {
+			return nil, err
+		}
+		result[chatID] = append(result[chatID], r)
+	}
+	return result, rows.Err()
+}
+
+// DeleteExpiredOneOffs удаляет разовые напоминания с датой раньше before
+// (записи о приёме остаются в dose_log) и возвращает их количество.
+// Завершённые курсы остаются в истории.
+func (s *Storage) DeleteExpiredOneOffs(before time.Time) (int, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM reminders WHERE fire_date IS NOT NULL AND fire_date < $1::date AND completed_at IS NULL
+	`, before.Format("2006-01-02"))
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// integrityCheck — проверка согласованности данных: запрос, считающий
+// проблемные строки, и запрос, исправляющий их ("" — только сообщать)
+type integrityCheck struct {
+	Kind        string
+	Description string
+	countSQL    string
+	repairSQL   string
+}
+
+// integrityChecks — что проверяет CheckIntegrity. Исправляются только строки,
+// которые и так ни на что не влияют или не могут сработать; историю приёмов
+// и незавершённые курсы исправление не трогает — о них только сообщается.
+var integrityChecks = []integrityCheck{
+	{
+		// Выборка планировщика соединяет напоминания с users и молча пропускает такие
+		Kind:        "orphan_reminders",
+		Description: "напоминания без пользователя",
+		countSQL:    `SELECT COUNT(*) FROM reminders r WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.chat_id = r.chat_id)`,
+		repairSQL:   `DELETE FROM reminders r WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.chat_id = r.chat_id)`,
+	},
+	{
+		Kind:        "orphan_snoozes",
+		Description: "отложенные напоминания чужого или удалённого пользователя",
+		countSQL: `SELECT COUNT(*) FROM snoozes sn WHERE NOT EXISTS (
+			SELECT 1 FROM reminders r JOIN users u ON u.chat_id = r.chat_id
+			WHERE r.id = sn.reminder_id AND r.chat_id = sn.chat_id)`,
+		repairSQL: `DELETE FROM snoozes sn WHERE NOT EXISTS (
+			SELECT 1 FROM reminders r JOIN users u ON u.chat_id = r.chat_id
+			WHERE r.id = sn.reminder_id AND r.chat_id = sn.chat_id)`,
+	},
+	{
+		Kind:        "orphan_dialogs",
+		Description: "сохранённые диалоги удалённого пользователя или напоминания",
+		countSQL: `SELECT COUNT(*) FROM pending_dialogs d
+			WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.chat_id = d.chat_id)
+			   OR (d.reminder_id <> 0 AND NOT EXISTS (SELECT 1 FROM reminders r WHERE r.id = d.reminder_id AND r.chat_id = d.chat_id))`,
+		repairSQL: `DELETE FROM pending_dialogs d
+			WHERE NOT EXISTS (SELECT 1 FROM users u WHERE u.chat_id = d.chat_id)
+			   OR (d.reminder_id <> 0 AND NOT EXISTS (SELECT 1 FROM reminders r WHERE r.id = d.reminder_id AND r.chat_id = d.chat_id))`,
+	},
+	{
+		Kind:        "completed_alive",
+		Description: "курсы, завершённые до появления истории, напоминание которых не удалено",
+		countSQL:    `SELECT COUNT(*) FROM completed_courses c WHERE EXISTS (SELECT 1 FROM reminders r WHERE r.id = c.reminder_id)`,
+		repairSQL:   `DELETE FROM completed_courses c WHERE EXISTS (SELECT 1 FROM reminders r WHERE r.id = c.reminder_id)`,
+	},
+	{
+		// Время напоминания остаётся прежним, пропадает только привязка
+		Kind:        "anchor_without_routine",
+		Description: "напоминания, привязанные к не заданному времени пробуждения или сна",
+		countSQL: `SELECT COUNT(*) FROM reminders r JOIN users u ON u.chat_id = r.chat_id
+			WHERE (r.anchor = '` + AnchorWake + `' AND u.wake_time IS NULL) OR (r.anchor = '` + AnchorSleep + `' AND u.sleep_time IS NULL)`,
+		repairSQL: `UPDATE reminders r SET anchor = '', anchor_offset = 0 FROM users u
+			WHERE u.chat_id = r.chat_id
+			  AND ((r.anchor = '` + AnchorWake + `' AND u.wake_time IS NULL) OR (r.anchor = '` + AnchorSleep + `' AND u.sleep_time IS NULL))`,
+	},
+	{
+		Kind:        "dose_log_owner",
+		Description: "записи журнала приёмов, владелец которых не совпадает с владельцем напоминания",
+		countSQL: `SELECT COUNT(*) FROM dose_log d JOIN reminders r ON r.id = d.reminder_id
+			WHERE d.chat_id IS DISTINCT FROM r.chat_id`,
+	},
+	{
+		// Курс завершается при подтверждении последней дозы; такие напоминания молча не отправляются
+		Kind:        "finished_not_completed",
+		Description: "курсы по приёмам, все дозы которых приняты, но курс не завершён",
+		countSQL: `SELECT COUNT(*) FROM reminders r
+			WHERE r.course_days > 0 AND r.course_type <> '` + CourseByDays + `' AND r.doses_taken >= r.course_days
+			  AND r.completed_at IS NULL`,
+	},
+}
+
+// IntegrityIssue — найденное расхождение в данных
+type IntegrityIssue struct {
+	Kind        string
+	Description string
+	Count       int
+	Repaired    int  // сколько строк исправлено
+	Repairable  bool // CheckIntegrity(true) может исправить
+}
+
+// CheckIntegrity ищет строки, которые не согласованы с остальными данными
+// (напоминания без пользователя, чужие отложенные напоминания и т.п.), и
+// возвращает только найденные расхождения. repair — заодно исправить те,
+// у которых есть безопасное исправление.
+func (s *Storage) CheckIntegrity(repair bool) ([]IntegrityIssue, error) {
+	ctx := context.Background()
+
+	var issues []IntegrityIssue
+	for _, c := range integrityChecks {
+		var count int
+		if err := s.pool.QueryRow(ctx, c.countSQL).Scan(&count); err != nil {
+			return nil, fmt.Errorf("%s: %w", c.Kind, err)
+		}
+		if count == 0 {
+			continue
+		}
+
+		issue := IntegrityIssue{Kind: c.Kind, Description: c.Description, Count: count, Repairable: c.repairSQL != ""}
+		if repair && issue.Repairable {
+			tag, err := s.pool.Exec(ctx, c.repairSQL)
+			if err != nil {
+				return nil, fmt.Errorf("repair %s: %w", c.Kind, err)
+			}
+			issue.Repaired = int(tag.RowsAffected())
+		}
+		issues = append(issues, issue)
+	}
+	return issues, nil
+}
+
+// schedulerLockClass — первая половина ключа advisory-блокировки планировщика;
+// вторая — хэш схемы, чтобы боты в разных схемах одной базы не мешали друг другу
+const schedulerLockClass int32 = 0x4d454453 // "MEDS"
+
+// SchedulerLock — удерживаемая блокировка ведущего планировщика. Блокировка
+// сессионная: живёт, пока открыто отдельное соединение, и освобождается
+// PostgreSQL сама, если процесс упал или соединение оборвалось.
+type SchedulerLock struct {
+	conn *pgxpool.Conn
+}
+
+// TryLockScheduler пытается стать ведущим планировщиком, не дожидаясь блокировки.
+// nil без ошибки — блокировку держит другой экземпляр.
+func (s *Storage) TryLockScheduler(ctx context.Context) (*SchedulerLock, error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `
+		SELECT pg_try_advisory_lock($1, hashtext(current_schema()))
+	`, schedulerLockClass).Scan(&locked); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	if !locked {
+		conn.Release()
+		return nil, nil
+	}
+	return &SchedulerLock{conn: conn}, nil
+}
+
+// Check проверяет, что соединение с блокировкой живо, — значит, блокировка ещё наша
+func (l *SchedulerLock) Check(ctx context.Context) error {
+	return l.conn.Ping(ctx)
+}
+
+// Release снимает блокировку и возвращает соединение в пул.
+// Если снять не удалось, соединение закрывается — вместе с ним уходит и блокировка.
+func (l *SchedulerLock) Release(ctx context.Context) {
+	if _, err := l.conn.Exec(ctx, `
+		SELECT pg_advisory_unlock($1, hashtext(current_schema()))
+	`, schedulerLockClass); err != nil {
+		log.Printf("Failed to release scheduler lock, closing connection: %v", err)
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+}
+
+// SchedulerHeartbeat — последний пульс ведущего планировщика
+type SchedulerHeartbeat struct {
+	Instance    string
+	LeaderSince time.Time
+	BeatAt      time.Time
+	LastSlot    *time.Time // последний проверенный слот (nil — ещё не было)
+}
+
+// SaveSchedulerHeartbeat записывает пульс ведущего. lastSlot nil — слот не меняется.
+func (s *Storage) SaveSchedulerHeartbeat(hb SchedulerHeartbeat) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO scheduler_heartbeat (id, instance, leader_since, beat_at, last_slot)
+		VALUES (1, $1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET
+			instance = EXCLUDED.instance,
+			leader_since = EXCLUDED.leader_since,
+			beat_at = EXCLUDED.beat_at,
+			last_slot = COALESCE(EXCLUDED.last_slot, scheduler_heartbeat.last_slot)
+	`, hb.Instance, hb.LeaderSince, hb.BeatAt, hb.LastSlot)
+	return err
+}
+
+// GetSchedulerHeartbeat возвращает последний пульс ведущего (nil — ведущего ещё не было)
+func (s *Storage) GetSchedulerHeartbeat() (*SchedulerHeartbeat, error) {
+	ctx := context.Background()
+
+	var hb SchedulerHeartbeat
+	err := s.pool.QueryRow(ctx, `
+		SELECT instance, leader_since, beat_at, last_slot FROM scheduler_heartbeat WHERE id = 1
+	`).Scan(&hb.Instance, &hb.LeaderSince, &hb.BeatAt, &hb.LastSlot)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &hb, nil
+}
+
+// DeleteAllReminders удаляет все напоминания пользователя и возвращает их количество.
+// История завершённых курсов остаётся.
+func (s *Storage) DeleteAllReminders(chatID int64) (int, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		DELETE FROM reminders WHERE chat_id = $1 AND completed_at IS NULL
+	`, chatID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// CountReminders возвращает количество напоминаний пользователя без завершённых курсов
+func (s *Storage) CountReminders(chatID int64) (int, error) {
+	ctx := context.Background()
+
+	var count int
+	err := s.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM reminders WHERE chat_id = $1 AND completed_at IS NULL
+	`, chatID).Scan(&count)
+
+	return count, err
+}
+
+// reminderAtCond — напоминание приходится на местное время lt.t: обычное — ровно
+// в hour:minute, приём каждые N часов — в любое время, отстоящее от hour:minute
+// на кратное интервалу число минут, но не раньше первого приёма; в любом случае —
+// только в выбранные дни недели (weekday_mask, бит 0 — понедельник). Интервалы делят
+// сутки нацело (intervalOptions), поэтому сдвиг считается в пределах суток.
+// Время приёма вычисляется из hour:minute, а не из сохранённого времени последней
+// отправки: расписание не уплывает после простоя, "Отложить" или смены ведущего,
+// а досылка, предупреждения, пачки, /week и проверка совпадений считают приёмы
+// любого дня без состояния. instant — тот же момент как timestamptz для сравнения
+// со starts_at.
+func reminderAtCond(instant string) string {
+	return `(CASE WHEN r.interval_minutes > 0 THEN
+			MOD(MOD(EXTRACT(HOUR FROM lt.t)::int * 60 + EXTRACT(MINUTE FROM lt.t)::int - (r.hour * 60 + r.minute),
+				r.interval_minutes) + r.interval_minutes, r.interval_minutes) = 0
+			AND r.starts_at <= ` + instant + `
+		ELSE r.hour = EXTRACT(HOUR FROM lt.t) AND r.minute = EXTRACT(MINUTE FROM lt.t) END)
+		AND (r.weekday_mask >> (EXTRACT(ISODOW FROM lt.t)::int - 1)) & 1 = 1`
+}
+
+// remindersForTimeSQL — запрос планировщика; выполняется каждый слот,
+// поэтому собирается один раз и попадает в кэш подготовленных выражений.
+// Напоминания без пользователя JOIN отбрасывает — их находит и удаляет
+// периодическая проверка CheckIntegrity (orphan_reminders).
+var remindersForTimeSQL = `
+		SELECT r.chat_id, ` + reminderColumns("r") + `
+		FROM reminders r
+		JOIN users u ON r.chat_id = u.chat_id
+		CROSS JOIN LATERAL (
+			SELECT $1::timestamptz AT TIME ZONE COALESCE(NULLIF(r.timezone, ''), u.timezone, $2) AS t
+		) lt
+		WHERE ` + reminderAtCond("$1::timestamptz") + `
+		  AND ` + userActiveCond + `
+		  AND ` + reminderRunnableCond + `
+		  AND (r.fire_date IS NULL OR r.fire_date = lt.t::date)
+		  AND NOT (u.vacation_from IS NOT NULL AND lt.t::date BETWEEN u.vacation_from AND u.vacation_until)
+	`
+
+// preAlertsForTimeSQL — напоминания, которые наступят через pre_alert_minutes владельца.
+// Условия те же, что у remindersForTimeSQL, но для момента предупреждения;
+// предупреждение не отправляется, если его время попадает на сон (sleep_time..wake_time)
+// или напоминание уже отправлено заранее в утренней или вечерней пачке.
+var preAlertsForTimeSQL = `
+		SELECT r.chat_id, u.pre_alert_minutes, ` + reminderColumns("r") + `
+		FROM reminders r
+		JOIN users u ON r.chat_id = u.chat_id
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(NULLIF(r.timezone, ''), u.timezone, $2) AS tz
+		) z
+		CROSS JOIN LATERAL (
+			SELECT ($1::timestamptz + u.pre_alert_minutes * INTERVAL '1 minute') AT TIME ZONE z.tz AS t,
+				EXTRACT(HOUR FROM $1::timestamptz AT TIME ZONE z.tz) * 60 + EXTRACT(MINUTE FROM $1::timestamptz AT TIME ZONE z.tz) AS alert_minute
+		) lt
+		WHERE u.pre_alert_minutes > 0
+		  AND ` + reminderAtCond("$1::timestamptz + u.pre_alert_minutes * INTERVAL '1 minute'") + `
+		  AND ` + userActiveCond + `
+		  AND ` + reminderRunnableCond + `
+		  AND (r.fire_date IS NULL OR r.fire_date = lt.t::date)
+		  AND NOT (u.vacation_from IS NOT NULL AND lt.t::date BETWEEN u.vacation_from AND u.vacation_until)
+		  AND NOT (u.sleep_time IS NOT NULL AND u.wake_time IS NOT NULL AND CASE
+				WHEN u.sleep_time <= u.wake_time THEN lt.alert_minute >= u.sleep_time AND lt.alert_minute < u.wake_time
+				ELSE lt.alert_minute >= u.sleep_time OR lt.alert_minute < u.wake_time
+			END)
+		  AND NOT EXISTS (
+				SELECT 1 FROM dose_log d
+				WHERE d.reminder_id = r.id AND d.scheduled_at = $1::timestamptz + u.pre_alert_minutes * INTERVAL '1 minute'
+			)
+	`
+
+// PreAlert — предупреждения одного пользователя о скорых напоминаниях
+type PreAlert struct {
+	Minutes   int // За сколько минут до напоминания
+	Reminders []Reminder
+}
+
+// GetPreAlertsForTime возвращает напоминания, о которых пора предупредить в момент now:
+// их время наступит через pre_alert_minutes владельца
+func (s *Storage) GetPreAlertsForTime(now time.Time) (map[int64]*PreAlert, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, preAlertsForTimeSQL, now, defaultTimezone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64]*PreAlert)
+	for rows.Next() {
+		var chatID int64
+		var minutes int
+		var r Reminder
+		if err := rows.Scan(append([]any{&chatID, &minutes}, reminderScanArgs(&r)...)...); err != nil {
+			return nil, err
+		}
+		if result[chatID] == nil {
+			result[chatID] = &PreAlert{Minutes: minutes}
+		}
+		result[chatID].Reminders = append(result[chatID].Reminders, r)
+	}
+
+	return result, rows.Err()
+}
+
+// GetRemindersForTime возвращает напоминания, время которых наступило в момент now
+// по их часовому поясу (свой пояс напоминания, иначе пояс владельца, иначе пояс
+// по умолчанию): только активных пользователей и только не приостановленные и не завершённые.
+// Разовые напоминания возвращаются только в свою дату.
+// Пользователи в отпуске на эту дату пропускаются.
+func (s *Storage) GetRemindersForTime(now time.Time) (map[int64][]Reminder, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, remindersForTimeSQL, now, defaultTimezone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int64][]Reminder)
+	for rows.Next() {
+		var chatID int64
+		var r Reminder
+		if err := rows.Scan(append([]any{&chatID}, reminderScanArgs(&r)...)...); err != nil {
+			return nil, err
+		}
+		result[chatID] = append(result[chatID], r)
+	}
+
+	return result, rows.Err()
+}
+
+// Статусы записей в dose_log
+const (
+	DoseScheduled = "scheduled"
+	DoseTaken     = "taken"
+	DoseMissed    = "missed"
+	DoseNotified  = "notified" // напоминание без подтверждения; не входит в статистику соблюдения
+	DoseSkipped   = "skipped"  // пропущено намеренно ("Пропустить сегодня"); не считается пропуском
+)
+
+// ErrDoseAlreadyTaken — приём за этот слот уже подтверждён (повторное нажатие кнопки)
+var ErrDoseAlreadyTaken = errors.New("dose already taken")
+
+// ErrCourseCompleted — курс напоминания завершён и напоминание в истории (CompleteReminder)
+var ErrCourseCompleted = errors.New("course already completed")
+
+// MarkDoseScheduled записывает в журнал отправленное напоминание.
+// Возвращает false, если слот уже есть в журнале — напоминание за него уже отправлялось
+// (например, до перезапуска бота), и повторять отправку не нужно.
+func (s *Storage) MarkDoseScheduled(chatID int64, reminderID int, medicine string, scheduledAt time.Time) (bool, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO dose_log (reminder_id, chat_id, medicine, scheduled_at, status)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (reminder_id, scheduled_at) DO NOTHING
+	`, reminderID, chatID, medicine, scheduledAt, DoseScheduled)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// MarkDoseTaken отмечает в журнале дозу слота scheduledAt со статусом status
+// (DoseTaken или DoseNotified) в транзакции tx; запись создаётся, если слот
+// ещё не записан MarkDoseScheduled. Строка журнала блокируется до конца
+// транзакции, поэтому два одновременных нажатия не засчитаются дважды: второе
+// увидит статус "taken". Возвращает false, если напоминания нет (чужое,
+// удалённое или в истории) или доза уже отмечена с этим статусом.
+// Счётчик напоминания не меняет — это делает IncrementDoseTaken.
+func (s *Storage) MarkDoseTaken(ctx context.Context, tx pgx.Tx, chatID int64, reminderID int, scheduledAt time.Time, status string) (bool, error) {
+	var logID int
+	err := tx.QueryRow(ctx, `
+		INSERT INTO dose_log (reminder_id, chat_id, medicine, scheduled_at, taken_at, status)
+		SELECT id, chat_id, medicine, $3, NOW(), $4
+		FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL
+		ON CONFLICT (reminder_id, scheduled_at) DO UPDATE
+			SET status = EXCLUDED.status, taken_at = EXCLUDED.taken_at
+			WHERE dose_log.status <> EXCLUDED.status
+		RETURNING id
+	`, reminderID, chatID, scheduledAt, status).Scan(&logID)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// IncrementDoseTaken отмечает приём в журнале со статусом status (DoseTaken или DoseNotified,
+// см. MarkDoseTaken) и увеличивает счётчик в одной транзакции.
+// Если запись о слоте не найдена (кнопка старого формата или напоминание отправлено
+// до появления журнала), создаёт её сразу с этим статусом.
+// Повторное подтверждение того же слота возвращает ErrDoseAlreadyTaken и счётчик не меняет.
+// Для кнопок старого формата слот неизвестен: вызывающий передаёт текущее время по
+// своим часам, и проверка повтора невозможна.
+// Напоминание с завершённым курсом возвращает ErrCourseCompleted, чужое или удалённое —
+// ErrReminderNotFound.
+func (s *Storage) IncrementDoseTaken(chatID int64, reminderID int, scheduledAt time.Time, status string) (medicineName string, newCount int, total int, completed bool, err error) {
+	ctx := context.Background()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return "", 0, 0, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	marked, err := s.MarkDoseTaken(ctx, tx, chatID, reminderID, scheduledAt, status)
+	if err != nil {
+		return "", 0, 0, false, err
+	}
+	if !marked {
+		// Либо напоминания нет (курс завершён или его удалили), либо слот уже подтверждён
+		var exists, completed bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL),
+				EXISTS (SELECT 1 FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NOT NULL)
+					OR EXISTS (SELECT 1 FROM completed_courses WHERE reminder_id = $1 AND chat_id = $2)
+		`, reminderID, chatID).Scan(&exists, &completed); err != nil {
+			return "", 0, 0, false, err
+		}
+		switch {
+		case completed:
+			return "", 0, 0, false, ErrCourseCompleted
+		case !exists:
+			return "", 0, 0, false, ErrReminderNotFound
+		}
+		return "", 0, 0, false, ErrDoseAlreadyTaken
+	}
+
+	var courseType string
+	var startsAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE reminders
+		SET doses_taken = doses_taken + 1, last_taken_at = NOW()
+		WHERE id = $1 AND chat_id = $2
+		RETURNING medicine, doses_taken, course_days, course_type, starts_at
+	`, reminderID, chatID).Scan(&medicineName, &newCount, &total, &courseType, &startsAt)
+	if err != nil {
+		return "", 0, 0, false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", 0, 0, false, err
+	}
+
+	// Курс по дням завершает доза последнего дня, курс по приёмам — последняя доза
+	if courseType == CourseByDays {
+		completed = total > 0 && courseDay(startsAt, scheduledAt) >= total
+	} else {
+		completed = total > 0 && newCount >= total
+	}
+	return medicineName, newCount, total, completed, nil
+}
+
+// SetDoseConfirmMessage запоминает сообщение с подтверждением приёма,
+// чтобы ответ на него можно было сохранить как заметку к дозе
+func (s *Storage) SetDoseConfirmMessage(chatID int64, reminderID int, scheduledAt time.Time, messageID int) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE dose_log SET confirm_message_id = $4
+		WHERE chat_id = $1 AND reminder_id = $2 AND scheduled_at = $3
+	`, chatID, reminderID, scheduledAt, messageID)
+	return err
+}
+
+// SetDoseNote сохраняет заметку к принятой дозе, подтверждение которой пришло
+// в сообщении messageID не раньше since. Возвращает название лекарства
+// и false, если такой дозы нет.
+func (s *Storage) SetDoseNote(chatID int64, messageID int, note string, since time.Time) (string, bool, error) {
+	ctx := context.Background()
+
+	var medicine string
+	err := s.pool.QueryRow(ctx, `
+		UPDATE dose_log SET note = $3
+		WHERE chat_id = $1 AND confirm_message_id = $2 AND status = $4 AND taken_at >= $5
+		RETURNING medicine
+	`, chatID, messageID, note, DoseTaken, since).Scan(&medicine)
+	if err == pgx.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return medicine, true, nil
+}
+
+// DoseNote — заметка пользователя к принятой дозе
+type DoseNote struct {
+	Medicine    string    `json:"medicine"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	Note        string    `json:"note"`
+}
+
+// GetTakenDoseCounts возвращает, сколько приёмов каждого напоминания с запланированным
+// временем в [from, to) подтверждено. Дозы без подтверждения (DoseNotified) не входят.
+func (s *Storage) GetTakenDoseCounts(chatID int64, from, to time.Time) (map[int]int, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT reminder_id, COUNT(*)
+		FROM dose_log
+		WHERE chat_id = $1 AND reminder_id IS NOT NULL AND status = $2
+			AND scheduled_at >= $3 AND scheduled_at < $4
+		GROUP BY reminder_id
+	`, chatID, DoseTaken, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taken := make(map[int]int)
+	for rows.Next() {
+		var id, count int
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		taken[id] = count
+	}
+
+	return taken, rows.Err()
+}
+
+// GetDoseNotes возвращает заметки к дозам, запланированным начиная с since, по времени
+func (s *Storage) GetDoseNotes(chatID int64, since time.Time) ([]DoseNote, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT medicine, scheduled_at, note
+		FROM dose_log
+		WHERE chat_id = $1 AND scheduled_at >= $2 AND note IS NOT NULL AND note <> ''
+		ORDER BY scheduled_at
+	`, chatID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notes := []DoseNote{}
+	for rows.Next() {
+		var n DoseNote
+		if err := rows.Scan(&n.Medicine, &n.ScheduledAt, &n.Note); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+
+	return notes, rows.Err()
+}
+
+// MarkCourseEndNoticeSent отмечает, что предупреждение о конце курса отправлено.
+// Возвращает false, если оно уже было отправлено раньше.
+func (s *Storage) MarkCourseEndNoticeSent(chatID int64, reminderID int) (bool, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		UPDATE reminders SET end_notice_sent = TRUE
+		WHERE id = $1 AND chat_id = $2 AND NOT end_notice_sent
+	`, reminderID, chatID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordDonation сохраняет платёж. Возвращает false, если платёж с таким
+// telegram_payment_charge_id уже записан (Telegram доставил обновление повторно).
+func (s *Storage) RecordDonation(chatID int64, amount int, currency, payload, chargeID string) (bool, error) {
+	ctx := context.Background()
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO donations (chat_id, amount, currency, payload, charge_id)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (charge_id) DO NOTHING
+	`, chatID, amount, currency, payload, chargeID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Donation — записанный платёж
+type Donation struct {
+	ChatID     int64
+	Amount     int
+	Currency   string
+	ChargeID   string
+	CreatedAt  time.Time
+	RefundedAt *time.Time
+}
+
+// GetDonation возвращает платёж по telegram_payment_charge_id (nil — не найден)
+func (s *Storage) GetDonation(chargeID string) (*Donation, error) {
+	ctx := context.Background()
+
+	var d Donation
+	err := s.pool.QueryRow(ctx, `
+		SELECT chat_id, amount, currency, charge_id, created_at, refunded_at
+		FROM donations WHERE charge_id = $1
+	`, chargeID).Scan(&d.ChatID, &d.Amount, &d.Currency, &d.ChargeID, &d.CreatedAt, &d.RefundedAt)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// MarkDonationRefunded отмечает платёж возвращённым
+func (s *Storage) MarkDonationRefunded(chargeID string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE donations SET refunded_at = NOW() WHERE charge_id = $1 AND refunded_at IS NULL
+	`, chargeID)
+	return err
+}
+
+// GetUnconfirmedPreviousDose возвращает время предыдущей дозы того же лекарства,
+// запланированной в [since, before), если она не подтверждена.
+// nil — предыдущей дозы нет или она принята.
+func (s *Storage) GetUnconfirmedPreviousDose(chatID int64, medicine string, since, before time.Time) (*time.Time, error) {
+	ctx := context.Background()
+
+	var scheduledAt time.Time
+	var status string
+	err := s.pool.QueryRow(ctx, `
+		SELECT scheduled_at, status
+		FROM dose_log
+		WHERE chat_id = $1 AND medicine = $2 AND scheduled_at >= $3 AND scheduled_at < $4
+		ORDER BY scheduled_at DESC
+		LIMIT 1
+	`, chatID, medicine, since, before).Scan(&scheduledAt, &status)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status == DoseTaken || status == DoseNotified || status == DoseSkipped {
+		return nil, nil
+	}
+	return &scheduledAt, nil
+}
+
+// RecordStopReason сохраняет причину отключения напоминаний
+func (s *Storage) RecordStopReason(chatID int64, reason string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO stop_reasons (chat_id, reason) VALUES ($1, $2)
+	`, chatID, reason)
+	return err
+}
+
+// GetStopReasonCounts возвращает, сколько раз указывалась каждая причина отключения
+func (s *Storage) GetStopReasonCounts() (map[string]int, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT reason, COUNT(*) FROM stop_reasons GROUP BY reason
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var reason string
+		var n int
+		if err := rows.Scan(&reason, &n); err != nil {
+			return nil, err
+		}
+		counts[reason] = n
+	}
+
+	return counts, rows.Err()
+}
+
+// AdminAction — запись журнала действий администраторов
+type AdminAction struct {
+	AdminID   int64
+	Action    string
+	Details   string
+	CreatedAt time.Time
+}
+
+// LogAdminAction записывает действие администратора
+func (s *Storage) LogAdminAction(adminID int64, action, details string) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO admin_actions (admin_id, action, details) VALUES ($1, $2, $3)
+	`, adminID, action, details)
+	return err
+}
+
+// GetAdminActions возвращает последние limit действий администраторов,
+// от новых к старым. Пустой action — все действия.
+func (s *Storage) GetAdminActions(limit int, action string) ([]AdminAction, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT admin_id, action, details, created_at
+		FROM admin_actions
+		WHERE $2 = '' OR action = $2
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`, limit, action)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []AdminAction
+	for rows.Next() {
+		var a AdminAction
+		if err := rows.Scan(&a.AdminID, &a.Action, &a.Details, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+
+	return actions, rows.Err()
+}
+
+// SkipDose отмечает дозу как намеренно пропущенную. Счётчик курса не меняется.
+// Возвращает название лекарства; ErrReminderNotFound — напоминания нет,
+// ErrDoseAlreadyTaken — доза уже подтверждена или пропущена.
+func (s *Storage) SkipDose(chatID int64, reminderID int, scheduledAt time.Time) (string, error) {
+	ctx := context.Background()
+
+	var medicine string
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO dose_log (reminder_id, chat_id, medicine, scheduled_at, status)
+		SELECT id, chat_id, medicine, $3, $4
+		FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL
+		ON CONFLICT (reminder_id, scheduled_at) DO UPDATE
+			SET status = EXCLUDED.status
+			WHERE dose_log.status IN ($5, $6)
+		RETURNING medicine
+	`, reminderID, chatID, scheduledAt, DoseSkipped, DoseScheduled, DoseMissed).Scan(&medicine)
+	if err == pgx.ErrNoRows {
+		var exists bool
+		if err := s.pool.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM reminders WHERE id = $1 AND chat_id = $2 AND completed_at IS NULL)
+		`, reminderID, chatID).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", ErrReminderNotFound
+		}
+		return "", ErrDoseAlreadyTaken
+	}
+	return medicine, err
+}
+
+// DayHistory — статистика приёмов за один день
+type DayHistory struct {
+	Date    string `json:"date"` // YYYY-MM-DD в часовом поясе пользователя
+	Taken   int    `json:"taken"`
+	Missed  int    `json:"missed"`
+	Skipped int    `json:"skipped"` // пропущено намеренно
+}
+
+// GetDoseHistory возвращает по дням количество принятых и пропущенных доз начиная с since.
+// Пропущенной считается доза со статусом "missed" или неподтверждённая доза,
+// запланированная раньше missedBefore (более свежие ещё можно подтвердить).
+// Дни без записей в результат не попадают.
+func (s *Storage) GetDoseHistory(chatID int64, since, missedBefore time.Time, loc *time.Location) ([]DayHistory, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT to_char((scheduled_at AT TIME ZONE $4)::date, 'YYYY-MM-DD') AS day,
+			COUNT(*) FILTER (WHERE status = $5),
+			COUNT(*) FILTER (WHERE status = $7 OR (status = $6 AND scheduled_at < $3)),
+			COUNT(*) FILTER (WHERE status = $8)
+		FROM dose_log
+		WHERE chat_id = $1 AND scheduled_at >= $2
+		GROUP BY day
+		ORDER BY day
+	`, chatID, since, missedBefore, loc.String(), DoseTaken, DoseScheduled, DoseMissed, DoseSkipped)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []DayHistory{}
+	for rows.Next() {
+		var d DayHistory
+		if err := rows.Scan(&d.Date, &d.Taken, &d.Missed, &d.Skipped); err != nil {
+			return nil, err
+		}
+		history = append(history, d)
+	}
+
+	return history, rows.Err()
+}
+
+// MedicineAdherence — статистика приёмов одного лекарства за период
+type MedicineAdherence struct {
+	Medicine string
+	Taken    int
+	Missed   int
+	Skipped  int // пропущено намеренно, в соблюдении режима не учитывается
+	Pending  int // ещё можно подтвердить
+}
+
+// GetMedicineAdherence возвращает статистику приёмов по лекарствам начиная с since.
+// Агрегация выполняется в базе, поэтому объём результата не зависит от длины истории.
+func (s *Storage) GetMedicineAdherence(chatID int64, since, missedBefore time.Time) ([]MedicineAdherence, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT medicine,
+			COUNT(*) FILTER (WHERE status = $4),
+			COUNT(*) FILTER (WHERE status = $6 OR (status = $5 AND scheduled_at < $3)),
+			COUNT(*) FILTER (WHERE status = $7),
+			COUNT(*) FILTER (WHERE status = $5 AND scheduled_at >= $3)
+		FROM dose_log
+		WHERE chat_id = $1 AND scheduled_at >= $2
+		GROUP BY medicine
+		ORDER BY medicine
+	`, chatID, since, missedBefore, DoseTaken, DoseScheduled, DoseMissed, DoseSkipped)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []MedicineAdherence
+	for rows.Next() {
+		var m MedicineAdherence
+		if err := rows.Scan(&m.Medicine, &m.Taken, &m.Missed, &m.Skipped, &m.Pending); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+
+	return result, rows.Err()
+}
+
+// Adherence — сколько доз лекарства принято из отправленных за период
+type Adherence struct {
+	Taken     int
+	Scheduled int // принятые и пропущенные; намеренно пропущенные и те, что ещё можно подтвердить, не входят
+}
+
+// GetAdherence возвращает соблюдение режима по лекарствам начиная с since для /adherence.
+// Доза считается отправленной, когда её уже нельзя подтвердить (takenConfirmWindow)
+// или она принята, поэтому только что добавленное напоминание не выглядит как 0%.
+// Лекарств без отправленных доз в результате нет.
+func (s *Storage) GetAdherence(chatID int64, since time.Time) (map[string]Adherence, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT medicine,
+			COUNT(*) FILTER (WHERE status = $4),
+			COUNT(*) FILTER (WHERE status IN ($4, $6) OR (status = $5 AND scheduled_at < NOW() - $3 * INTERVAL '1 second'))
+		FROM dose_log
+		WHERE chat_id = $1 AND scheduled_at >= $2
+		GROUP BY medicine
+		HAVING COUNT(*) FILTER (WHERE status IN ($4, $6) OR (status = $5 AND scheduled_at < NOW() - $3 * INTERVAL '1 second')) > 0
+	`, chatID, since, int(takenConfirmWindow/time.Second), DoseTaken, DoseScheduled, DoseMissed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[string]Adherence)
+	for rows.Next() {
+		var medicine string
+		var a Adherence
+		if err := rows.Scan(&medicine, &a.Taken, &a.Scheduled); err != nil {
+			return nil, err
+		}
+		result[medicine] = a
+	}
+
+	return result, rows.Err()
+}
+
+// WeekAdherence — приёмы лекарства за одну неделю
+type WeekAdherence struct {
+	From   time.Time // начало недели
+	Taken  int
+	Missed int
+}
+
+// GetMedicineTrend возвращает приёмы лекарства по неделям за weeks недель до until,
+// от старой недели к новой. Неделя — ровно 7×24 часа, последняя заканчивается в until.
+// Пропущенные считаются как в GetMedicineAdherence; намеренно пропущенные не входят.
+// Недели без запланированных доз (перерыв в курсе) остаются с нулями.
+// Название сравнивается без учёта регистра: журнал хранит его на момент приёма.
+func (s *Storage) GetMedicineTrend(chatID int64, medicine string, weeks int, until, missedBefore time.Time) ([]WeekAdherence, error) {
+	ctx := context.Background()
+
+	trend := make([]WeekAdherence, weeks)
+	for i := range trend {
+		trend[i].From = until.Add(-time.Duration(weeks-i) * 7 * 24 * time.Hour)
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT FLOOR(EXTRACT(EPOCH FROM ($4 - scheduled_at)) / 604800)::int AS weeks_ago,
+			COUNT(*) FILTER (WHERE status = $6),
+			COUNT(*) FILTER (WHERE status = $8 OR (status = $7 AND scheduled_at < $5))
+		FROM dose_log
+		WHERE chat_id = $1 AND LOWER(medicine) = LOWER($2)
+			AND scheduled_at >= $3 AND scheduled_at < $4
+		GROUP BY weeks_ago
+	`, chatID, medicine, trend[0].From, until, missedBefore, DoseTaken, DoseScheduled, DoseMissed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var weeksAgo, taken, missed int
+		if err := rows.Scan(&weeksAgo, &taken, &missed); err != nil {
+			return nil, err
+		}
+		if weeksAgo < 0 || weeksAgo >= weeks {
+			continue
+		}
+		trend[weeks-1-weeksAgo].Taken = taken
+		trend[weeks-1-weeksAgo].Missed = missed
+	}
+
+	return trend, rows.Err()
+}
+
+// MissedDose — доза, которую не подтвердили вовремя
+type MissedDose struct {
+	ChatID      int64
+	Medicine    string
+	ScheduledAt time.Time
+	WebhookURL  string // webhook пользователя ("" — не настроен)
+}
+
+// FinalizeMissedDoses помечает пропущенными неподтверждённые дозы,
+// запланированные раньше before, и возвращает их
+func (s *Storage) FinalizeMissedDoses(before time.Time) ([]MissedDose, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH missed AS (
+			UPDATE dose_log SET status = $1
+			WHERE status = $2 AND scheduled_at < $3
+			RETURNING chat_id, medicine, scheduled_at
+		)
+		SELECT m.chat_id, m.medicine, m.scheduled_at, COALESCE(u.webhook_url, '')
+		FROM missed m
+		LEFT JOIN users u ON u.chat_id = m.chat_id
+	`, DoseMissed, DoseScheduled, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doses []MissedDose
+	for rows.Next() {
+		var d MissedDose
+		if err := rows.Scan(&d.ChatID, &d.Medicine, &d.ScheduledAt, &d.WebhookURL); err != nil {
+			return nil, err
+		}
+		doses = append(doses, d)
+	}
+
+	return doses, rows.Err()
+}
+
+// DueNudge — неподтверждённая доза, о которой пора напомнить ещё раз
+type DueNudge struct {
+	ChatID      int64
+	Language    string
+	ScheduledAt time.Time
+	Reminder    Reminder
+}
+
+// TakeDueNudges возвращает неподтверждённые дозы, которые пора повторить в момент now,
+// и сразу записывает повтор: k-й повтор положен через k×everyMinutes после
+// отправки, всего не больше maxNudges. После простоя уходит один повтор, а
+// пропущенные засчитываются — пользователь не получит несколько сообщений подряд.
+// Дозы приостановленных, завершённых напоминаний и отключившихся пользователей
+// не повторяются, как и напоминания без подтверждения.
+func (s *Storage) TakeDueNudges(now time.Time, everyMinutes, maxNudges int) ([]DueNudge, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		WITH due AS (
+			SELECT d.id, LEAST($3, FLOOR(EXTRACT(EPOCH FROM $1::timestamptz - d.scheduled_at) / ($2 * 60))::int) AS nudges
+			FROM dose_log d
+			JOIN reminders r ON r.id = d.reminder_id AND r.chat_id = d.chat_id
+			JOIN users u ON u.chat_id = d.chat_id
+			WHERE d.status = $4 AND d.nudges < $3
+			  AND d.scheduled_at > $1::timestamptz - ($3 + 1) * $2 * INTERVAL '1 minute'
+			  AND d.scheduled_at <= $1::timestamptz - (d.nudges + 1) * $2 * INTERVAL '1 minute'
+			  AND r.require_confirm
+			  AND `+userActiveCond+`
+			  AND `+reminderRunnableCond+`
+			FOR UPDATE OF d SKIP LOCKED
+		), nudged AS (
+			UPDATE dose_log d SET nudges = due.nudges
+			FROM due WHERE d.id = due.id
+			RETURNING d.chat_id, d.reminder_id, d.scheduled_at
+		)
+		SELECT n.chat_id, COALESCE(u.language, ''), n.scheduled_at, `+reminderColumns("r")+`
+		FROM nudged n
+		JOIN reminders r ON r.id = n.reminder_id
+		JOIN users u ON u.chat_id = n.chat_id
+	`, now, everyMinutes, maxNudges, DoseScheduled)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nudges []DueNudge
+	for rows.Next() {
+		var n DueNudge
+		if err := rows.Scan(append([]any{&n.ChatID, &n.Language, &n.ScheduledAt}, reminderScanArgs(&n.Reminder)...)...); err != nil {
+			return nil, err
+		}
+		nudges = append(nudges, n)
+	}
+
+	return nudges, rows.Err()
+}
+
+// StopNudges прекращает повторы дозы: пользователь отложил напоминание,
+// и оно придёт само в выбранное время
+func (s *Storage) StopNudges(chatID int64, reminderID int, scheduledAt time.Time) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `
+		UPDATE dose_log SET nudges = GREATEST(nudges, $4)
+		WHERE chat_id = $1 AND reminder_id = $2 AND scheduled_at = $3
+	`, chatID, reminderID, scheduledAt, maxNudges)
+	return err
+}
+
+// PendingDose — отправленное, но ещё не подтверждённое напоминание
+type PendingDose struct {
+	ReminderID  int
+	Medicine    string
+	ScheduledAt time.Time
+}
+
+// GetPendingDoses возвращает неподтверждённые дозы, запланированные после since,
+// от самых свежих к старым
+func (s *Storage) GetPendingDoses(chatID int64, since time.Time) ([]PendingDose, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT reminder_id, medicine, scheduled_at
+		FROM dose_log
+		WHERE chat_id = $1 AND status = $2 AND scheduled_at >= $3 AND reminder_id IS NOT NULL
+		ORDER BY scheduled_at DESC
+	`, chatID, DoseScheduled, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doses []PendingDose
+	for rows.Next() {
+		var d PendingDose
+		if err := rows.Scan(&d.ReminderID, &d.Medicine, &d.ScheduledAt); err != nil {
+			return nil, err
+		}
+		doses = append(doses, d)
+	}
+
+	return doses, rows.Err()
+}
+
+// GetLinkedPendingDoses возвращает неподтверждённые дозы напоминаний из группы reminderID
+// за тот же слот (само напоминание не входит)
+func (s *Storage) GetLinkedPendingDoses(chatID int64, reminderID int, slot time.Time) ([]PendingDose, error) {
+	ctx := context.Background()
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT d.reminder_id, d.medicine, d.scheduled_at
+		FROM dose_log d
+		JOIN reminders r ON r.id = d.reminder_id
+		JOIN reminders self ON self.id = $2 AND self.chat_id = $1
+		WHERE d.chat_id = $1 AND d.status = $3 AND d.scheduled_at = $4
+		  AND r.group_id = self.group_id AND r.id <> self.id
+		ORDER BY d.medicine
+	`, chatID, reminderID, DoseScheduled, slot)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var doses []PendingDose
+	for rows.Next() {
+		var d PendingDose
+		if err := rows.Scan(&d.ReminderID, &d.Medicine, &d.ScheduledAt); err != nil {
+			return nil, err
+		}
+		doses = append(doses, d)
+	}
+
+	return doses, rows.Err()
+}
+
+// CourseSummary — итоги завершённого курса
+type CourseSummary struct {
+	DosesTaken int       // Подтверждённых приёмов
+	StartedAt  time.Time // Первый запланированный приём
+	Scheduled  int       // Напоминаний по журналу, кроме отправленных без подтверждения
+	Taken      int       // Подтверждённых приёмов по журналу
+}
+
+// GetCourseSummary собирает итоги курса, в том числе уже завершённого.
+func (s *Storage) GetCourseSummary(chatID int64, reminderID int) (*CourseSummary, error) {
+	ctx := context.Background()
+
+	var sum CourseSummary
+	err := s.pool.QueryRow(ctx, `
+		SELECT r.doses_taken, r.starts_at,
+			(SELECT COUNT(*) FROM dose_log d WHERE d.reminder_id = r.id AND d.chat_id = r.chat_id AND d.status <> $4),
+			(SELECT COUNT(*) FROM dose_log d WHERE d.reminder_id = r.id AND d.chat_id = r.chat_id AND d.status = $3)
+		FROM reminders r
+		WHERE r.id = $1 AND r.chat_id = $2
+	`, reminderID, chatID, DoseTaken, DoseNotified).Scan(&sum.DosesTaken, &sum.StartedAt, &sum.Scheduled, &sum.Taken)
+
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+// Stats — статистика для админа
+type Stats struct {
+	TotalUsers  int
+	ActiveUsers int // пользователи, не отключившие напоминания через /stop
+
+	TotalReminders    int // без завершённых курсов
+	RunnableReminders int // будут отправлены: пользователь активен, напоминание не на паузе и не завершено
+	PausedReminders   int
+	FiniteCourses     int
+	InfiniteCourses   int
+	CompletedCourses  int // в истории, вместе с завершёнными до её появления
+
+	TotalDosesTaken   int
+	TotalDosesPlanned int
+	TotalDosesSkipped int // пропущено намеренно по журналу
+}
+
+// GetStats возвращает статистику для админа
+func (s *Storage) GetStats() (Stats, error) {
+	ctx := context.Background()
+
+	var st Stats
+	err := s.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users u WHERE `+userActiveCond+`),
+			(SELECT COUNT(*) FROM reminders WHERE completed_at IS NULL),
+			(SELECT COUNT(*) FROM reminders r JOIN users u ON u.chat_id = r.chat_id
+				WHERE `+userActiveCond+` AND `+reminderRunnableCond+`),
+			(SELECT COUNT(*) FROM reminders WHERE paused AND completed_at IS NULL),
+			(SELECT COUNT(*) FROM reminders WHERE course_days > 0 AND completed_at IS NULL),
+			(SELECT COUNT(*) FROM reminders WHERE course_days = 0 AND completed_at IS NULL),
+			(SELECT COUNT(*) FROM reminders WHERE completed_at IS NOT NULL) + (SELECT COUNT(*) FROM completed_courses),
+			(SELECT COALESCE(SUM(doses_taken), 0) FROM reminders),
+			(SELECT COALESCE(SUM(course_days), 0) FROM reminders WHERE course_days > 0),
+			(SELECT COUNT(*) FROM dose_log WHERE status = $1)
+	`, DoseSkipped).Scan(&st.TotalUsers, &st.ActiveUsers, &st.TotalReminders, &st.RunnableReminders, &st.PausedReminders,
+		&st.FiniteCourses, &st.InfiniteCourses, &st.CompletedCourses, &st.TotalDosesTaken, &st.TotalDosesPlanned, &st.TotalDosesSkipped)
+
+	return st, err
+}
+
+// UserFilter — условия отбора пользователей для рассылки.
+// Нулевое значение выбирает всех пользователей.
+type UserFilter struct {
+	ActiveOnly   bool       // только с включёнными напоминаниями
+	CreatedAfter *time.Time // только зарегистрированные после этого момента
+	HasReminders bool       // только с хотя бы одним напоминанием
+}
+
+// UserInfo — пользователь с данными для сегментации рассылок
+type UserInfo struct {
+	ChatID        int64
+	Username      string
+	FirstName     string
+	Active        bool
+	CreatedAt     time.Time
+	Language      string
+	ReminderCount int
+}
+
+// GetUsersFiltered возвращает пользователей, подходящих под фильтр, одним запросом
+func (s *Storage) GetUsersFiltered(f UserFilter) ([]UserInfo, error) {
+	ctx := context.Background()
+
+	var createdAfter *time.Time
+	if f.CreatedAfter != nil {
+		// created_at хранится без часового пояса в UTC
+		t := f.CreatedAfter.UTC()
+		createdAfter = &t
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT u.chat_id, COALESCE(u.username, ''), COALESCE(u.first_name, ''),
+			COALESCE(u.active, true), u.created_at, COALESCE(u.language, ''), COUNT(r.id)
+		FROM users u
+		LEFT JOIN reminders r ON r.chat_id = u.chat_id AND r.completed_at IS NULL
+		WHERE (NOT $1 OR `+userActiveCond+`)
+		  AND ($2::timestamp IS NULL OR u.created_at >= $2)
+		GROUP BY u.chat_id
+		HAVING (NOT $3 OR COUNT(r.id) > 0)
+		ORDER BY u.created_at
+	`, f.ActiveOnly, createdAfter, f.HasReminders)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserInfo
+	for rows.Next() {
+		var u UserInfo
+		if err := rows.Scan(&u.ChatID, &u.Username, &u.FirstName, &u.Active, &u.CreatedAt, &u.Language, &u.ReminderCount); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// SavedSnooze — отложенное напоминание, сохранённое при остановке бота
+type SavedSnooze struct {
+	ReminderID int
+	ChatID     int64
+	Language   string
+	Slot       time.Time // Слот исходного напоминания
+	FireAt     time.Time // Когда напоминание должно прийти повторно
+}
+
+// SaveSnoozes сохраняет отложенные напоминания одной транзакцией
+func (s *Storage) SaveSnoozes(ctx context.Context, snoozes []SavedSnooze) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, sn := range snoozes {
+		// Напоминание могли удалить, пока таймер ждал — такие пропускаем
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO snoozes (reminder_id, chat_id, language, slot, fire_at)
+			SELECT id, chat_id, $3, $4, $5 FROM reminders WHERE id = $1 AND chat_id = $2
+			ON CONFLICT (reminder_id) DO UPDATE
+				SET language = EXCLUDED.language, slot = EXCLUDED.slot, fire_at = EXCLUDED.fire_at
+		`, sn.ReminderID, sn.ChatID, sn.Language, sn.Slot, sn.FireAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// TakeSnoozes возвращает сохранённые отложенные напоминания и удаляет их из базы
+func (s *Storage) TakeSnoozes(ctx context.Context) ([]SavedSnooze, error) {
+	rows, err := s.pool.Query(ctx, `
+		DELETE FROM snoozes RETURNING reminder_id, chat_id, language, slot, fire_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snoozes []SavedSnooze
+	for rows.Next() {
+		var sn SavedSnooze
+		if err := rows.Scan(&sn.ReminderID, &sn.ChatID, &sn.Language, &sn.Slot, &sn.FireAt); err != nil {
+			return nil, err
+		}
+		snoozes = append(snoozes, sn)
+	}
+
+	return snoozes, rows.Err()
+}
+
+// upsertPendingDialogSQL записывает диалог пользователя; пустое время начала — сейчас
+const upsertPendingDialogSQL = `
+	INSERT INTO pending_dialogs (chat_id, state, medicine, hour, minute, anchor, anchor_offset, msg_id, reminder_id,
+	                             started_at, course_days, course_type, copy_course, interval_minutes, weekday_mask, dose_amount)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, COALESCE($10, NOW()), $11, $12, $13, $14, $15, $16)
+	ON CONFLICT (chat_id) DO UPDATE
+		SET state = EXCLUDED.state, medicine = EXCLUDED.medicine,
+		    hour = EXCLUDED.hour, minute = EXCLUDED.minute,
+		    anchor = EXCLUDED.anchor, anchor_offset = EXCLUDED.anchor_offset,
+		    msg_id = EXCLUDED.msg_id, reminder_id = EXCLUDED.reminder_id,
+		    started_at = EXCLUDED.started_at, course_days = EXCLUDED.course_days,
+		    course_type = EXCLUDED.course_type, copy_course = EXCLUDED.copy_course,
+		    interval_minutes = EXCLUDED.interval_minutes, weekday_mask = EXCLUDED.weekday_mask,
+		    dose_amount = EXCLUDED.dose_amount
+`
+
+// pendingDialogArgs возвращает параметры upsertPendingDialogSQL
+func pendingDialogArgs(chatID int64, p PendingReminder) []any {
+	var startedAt *time.Time
+	if !p.StartedAt.IsZero() {
+		startedAt = &p.StartedAt
+	}
+	return []any{chatID, int(p.State), p.Medicine, p.Hour, p.Minute, p.Anchor, p.AnchorOffset, p.MsgID, p.ReminderID,
+		startedAt, p.CourseDays, p.CourseType, p.CopyCourse, p.IntervalMinutes, p.WeekdayMask, p.DoseAmount}
+}
+
+// SavePendingDialog сохраняет текущий шаг диалога пользователя
+func (s *Storage) SavePendingDialog(chatID int64, p PendingReminder) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, upsertPendingDialogSQL, pendingDialogArgs(chatID, p)...)
+	return err
+}
+
+// DeletePendingDialog удаляет сохранённый диалог завершённого или отменённого /add
+func (s *Storage) DeletePendingDialog(chatID int64) error {
+	ctx := context.Background()
+	_, err := s.pool.Exec(ctx, `DELETE FROM pending_dialogs WHERE chat_id = $1`, chatID)
+	return err
+}
+
+// SavePendingDialogs сохраняет незавершённые диалоги создания напоминаний
+func (s *Storage) SavePendingDialogs(ctx context.Context, pending map[int64]PendingReminder) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for chatID, p := range pending {
+		if _, err := tx.Exec(ctx, upsertPendingDialogSQL, pendingDialogArgs(chatID, p)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// LoadPendingDialogs возвращает сохранённые диалоги. Диалоги, начатые раньше
+// staleBefore, удаляются и не возвращаются; остальные остаются в базе,
+// пока пользователь не завершит или не отменит их.
+func (s *Storage) LoadPendingDialogs(ctx context.Context, staleBefore time.Time) (map[int64]*PendingReminder, error) {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM pending_dialogs WHERE started_at < $1`, staleBefore); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT chat_id, state, medicine, hour, minute, anchor, anchor_offset, msg_id, reminder_id,
+		       started_at, course_days, course_type, copy_course, interval_minutes, weekday_mask, dose_amount
+		FROM pending_dialogs
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pending := make(map[int64]*PendingReminder)
+	for rows.Next() {
+		var chatID int64
+		var state int
+		p := &PendingReminder{}
+		if err := rows.Scan(&chatID, &state, &p.Medicine, &p.Hour, &p.Minute, &p.Anchor, &p.AnchorOffset, &p.MsgID, &p.ReminderID,
+			&p.StartedAt, &p.CourseDays, &p.CourseType, &p.CopyCourse, &p.IntervalMinutes, &p.WeekdayMask, &p.DoseAmount); err != nil {
+			return nil, err
+		}
+		p.State = UserState(state)
+		pending[chatID] = p
+	}
+
+	return pending, rows.Err()
+}
+
+// Статусы доставки рассылки
+const (
+	DeliverySent   = "sent"
+	DeliveryFailed = "failed"
+)
+
+// CreateBroadcast сохраняет текст новой рассылки и возвращает её ID
+func (s *Storage) CreateBroadcast(text string) (int, error) {
+	ctx := context.Background()
+	var id int
+	err := s.pool.QueryRow(ctx, `
+		INSERT INTO broadcasts (text) VALUES ($1) RETURNING id
+	`, text).Scan(&id)
+	return id, err
+}
+
+// RecordDelivery записывает результат доставки рассылки пользователю.
+// Повторная попытка обновляет запись и увеличивает счётчик попыток.
+func (s *Storage) RecordDelivery(broadcastID int, chatID int64, sendErr error) error {
+	ctx := context.Background()
+
+	status, errText := DeliverySent, ""
+	if sendErr != nil {
+		status, errText = DeliveryFailed, sendErr.Error()
+	}
+
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO broadcast_deliveries (broadcast_id, chat_id, status, error)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (broadcast_id, chat_id) DO UPDATE
+			SET status = EXCLUDED.status, error = EXCLUDED.error,
+			    attempts = broadcast_deliveries.attempts + 1, attempted_at = NOW()
+	`, broadcastID, chatID, status, errText)
+	return err
+}
+
+// GetLastBroadcastFailures возвращает последнюю рассылку и пользователей,
+// которым её не удалось доставить. id = 0 — рассылок ещё не было.
+func (s *Storage) GetLastBroadcastFailures() (id int, text string, chatIDs []int64, err error) {
+	ctx := context.Background()
+
+	err = s.pool.QueryRow(ctx, `
+		SELECT id, text FROM broadcasts ORDER BY id DESC LIMIT 1
+	`).Scan(&id, &text)
+	if err == pgx.ErrNoRows {
+		return 0, "", nil, nil
+	}
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `
+		SELECT chat_id FROM broadcast_deliveries
+		WHERE broadcast_id = $1 AND status = $2
+		ORDER BY chat_id
+	`, id, DeliveryFailed)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			return 0, "", nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
 	}
 
-	return chatIDs, rows.Err()
+	return id, text, chatIDs, rows.Err()
 }
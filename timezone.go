@@ -0,0 +1,394 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// tzPoint — опорная точка для определения часового пояса по координатам
+type tzPoint struct {
+	lat, lon float64
+	zone     string
+}
+
+// tzPoints — встроенная таблица городов: часовой пояс берётся у ближайшего из них.
+// Российские пояса представлены подробно, остальной мир — крупными городами.
+// У границ поясов возможна ошибка, поэтому пользователь всегда может выбрать пояс вручную.
+var tzPoints = []tzPoint{
+	// Россия
+	{54.71, 20.51, "Europe/Kaliningrad"},
+	{55.76, 37.62, "Europe/Moscow"},
+	{59.94, 30.31, "Europe/Moscow"},
+	{68.97, 33.08, "Europe/Moscow"},
+	{64.54, 40.54, "Europe/Moscow"},
+	{55.79, 49.12, "Europe/Moscow"},
+	{56.33, 44.00, "Europe/Moscow"},
+	{51.67, 39.18, "Europe/Moscow"},
+	{47.23, 39.72, "Europe/Moscow"},
+	{45.04, 38.98, "Europe/Moscow"},
+	{43.60, 39.73, "Europe/Moscow"},
+	{42.98, 47.50, "Europe/Moscow"},
+	{58.60, 49.66, "Europe/Moscow"},
+	{61.67, 50.84, "Europe/Moscow"},
+	{44.95, 34.10, "Europe/Moscow"},
+	{53.20, 50.15, "Europe/Samara"},
+	{56.85, 53.20, "Europe/Samara"},
+	{48.70, 44.50, "Europe/Volgograd"},
+	{51.53, 46.03, "Europe/Saratov"},
+	{54.31, 48.40, "Europe/Ulyanovsk"},
+	{46.35, 48.04, "Europe/Astrakhan"},
+	{56.84, 60.60, "Asia/Yekaterinburg"},
+	{55.16, 61.40, "Asia/Yekaterinburg"},
+	{58.01, 56.25, "Asia/Yekaterinburg"},
+	{54.74, 55.97, "Asia/Yekaterinburg"},
+	{57.15, 65.53, "Asia/Yekaterinburg"},
+	{51.77, 55.10, "Asia/Yekaterinburg"},
+	{61.25, 73.40, "Asia/Yekaterinburg"},
+	{66.53, 66.60, "Asia/Yekaterinburg"},
+	{54.99, 73.37, "Asia/Omsk"},
+	{55.03, 82.92, "Asia/Novosibirsk"},
+	{53.35, 83.78, "Asia/Barnaul"},
+	{56.49, 84.95, "Asia/Tomsk"},
+	{53.76, 87.12, "Asia/Novokuznetsk"},
+	{55.35, 86.09, "Asia/Novokuznetsk"},
+	{56.01, 92.89, "Asia/Krasnoyarsk"},
+	{69.35, 88.20, "Asia/Krasnoyarsk"},
+	{52.29, 104.28, "Asia/Irkutsk"},
+	{51.83, 107.58, "Asia/Irkutsk"},
+	{52.03, 113.50, "Asia/Chita"},
+	{62.03, 129.73, "Asia/Yakutsk"},
+	{50.27, 127.53, "Asia/Yakutsk"},
+	{43.12, 131.89, "Asia/Vladivostok"},
+	{48.48, 135.08, "Asia/Vladivostok"},
+	{46.96, 142.73, "Asia/Sakhalin"},
+	{59.57, 150.80, "Asia/Magadan"},
+	{67.45, 153.70, "Asia/Srednekolymsk"},
+	{53.02, 158.65, "Asia/Kamchatka"},
+	{64.73, 177.50, "Asia/Anadyr"},
+
+	// Соседние страны
+	{53.90, 27.57, "Europe/Minsk"},
+	{50.45, 30.52, "Europe/Kiev"},
+	{47.01, 28.86, "Europe/Chisinau"},
+	{56.95, 24.10, "Europe/Riga"},
+	{54.69, 25.28, "Europe/Vilnius"},
+	{59.44, 24.75, "Europe/Tallinn"},
+	{41.72, 44.79, "Asia/Tbilisi"},
+	{40.18, 44.51, "Asia/Yerevan"},
+	{40.41, 49.87, "Asia/Baku"},
+	{43.24, 76.95, "Asia/Almaty"},
+	{51.17, 71.43, "Asia/Almaty"},
+	{50.28, 57.17, "Asia/Aqtobe"},
+	{41.30, 69.24, "Asia/Tashkent"},
+	{42.87, 74.59, "Asia/Bishkek"},
+	{38.56, 68.79, "Asia/Dushanbe"},
+	{37.95, 58.38, "Asia/Ashgabat"},
+	{47.89, 106.90, "Asia/Ulaanbaatar"},
+
+	// Остальной мир
+	{51.50, -0.13, "Europe/London"},
+	{38.72, -9.14, "Europe/Lisbon"},
+	{48.86, 2.35, "Europe/Paris"},
+	{40.42, -3.70, "Europe/Madrid"},
+	{52.52, 13.40, "Europe/Berlin"},
+	{41.90, 12.50, "Europe/Rome"},
+	{52.23, 21.01, "Europe/Warsaw"},
+	{60.17, 24.94, "Europe/Helsinki"},
+	{37.98, 23.73, "Europe/Athens"},
+	{41.01, 28.98, "Europe/Istanbul"},
+	{31.77, 35.21, "Asia/Jerusalem"},
+	{30.04, 31.24, "Africa/Cairo"},
+	{25.20, 55.27, "Asia/Dubai"},
+	{35.69, 51.39, "Asia/Tehran"},
+	{28.61, 77.21, "Asia/Kolkata"},
+	{13.76, 100.50, "Asia/Bangkok"},
+	{1.35, 103.82, "Asia/Singapore"},
+	{31.23, 121.47, "Asia/Shanghai"},
+	{37.57, 126.98, "Asia/Seoul"},
+	{35.68, 139.69, "Asia/Tokyo"},
+	{-33.87, 151.21, "Australia/Sydney"},
+	{40.71, -74.00, "America/New_York"},
+	{41.88, -87.63, "America/Chicago"},
+	{39.74, -104.99, "America/Denver"},
+	{34.05, -118.24, "America/Los_Angeles"},
+	{-23.55, -46.63, "America/Sao_Paulo"},
+}
+
+// tzMaxDistanceKm — дальше этого от ближайшего города пояс считается по долготе
+const tzMaxDistanceKm = 1000
+
+// timezoneForLocation определяет часовой пояс IANA по координатам.
+// Берётся пояс ближайшего города из tzPoints; если рядом городов нет
+// (океан, малонаселённые районы), пояс вычисляется по долготе.
+func timezoneForLocation(lat, lon float64) string {
+	best := ""
+	bestDist := math.Inf(1)
+	for _, p := range tzPoints {
+		if d := distanceKm(lat, lon, p.lat, p.lon); d < bestDist {
+			best, bestDist = p.zone, d
+		}
+	}
+	if bestDist <= tzMaxDistanceKm {
+		return best
+	}
+
+	// Etc/GMT-N соответствует UTC+N (знак в названиях Etc инвертирован)
+	offset := int(math.Round(lon / 15))
+	switch {
+	case offset == 0:
+		return "UTC"
+	case offset > 0:
+		return fmt.Sprintf("Etc/GMT-%d", offset)
+	default:
+		return fmt.Sprintf("Etc/GMT+%d", -offset)
+	}
+}
+
+// distanceKm — расстояние между точками по поверхности Земли (формула гаверсинусов)
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+// loadTimezone проверяет название часового пояса IANA, введённое пользователем
+func loadTimezone(name string) (*time.Location, error) {
+	// "Local" — пояс сервера, а не пользователя
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return time.LoadLocation(name)
+}
+
+// zoneLabel подписывает пояс его смещением от UTC на момент at, например
+// "Europe/Berlin, UTC+2, летнее время": по смещению видно, тот ли пояс выбран,
+// а пометка напоминает, что зимой часы сдвинутся
+func zoneLabel(loc *time.Location, at time.Time) string {
+	t := at.In(loc)
+	_, offset := t.Zone()
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	label := fmt.Sprintf("%s, UTC%s%d", loc, sign, offset/3600)
+	if minutes := offset % 3600 / 60; minutes != 0 {
+		label += fmt.Sprintf(":%02d", minutes)
+	}
+	if t.IsDST() {
+		label += ", летнее время"
+	}
+	return label
+}
+
+// userLoc возвращает часовой пояс пользователя (пояс по умолчанию, если не выбран)
+func (b *Bot) userLoc(chatID int64) *time.Location {
+	timezone, err := b.storage.GetUserTimezone(chatID)
+	if err != nil {
+		log.Printf("Failed to get timezone for %d: %v", chatID, err)
+		return b.loc
+	}
+	if timezone == "" {
+		return b.loc
+	}
+
+	loc, err := loadTimezone(timezone)
+	if err != nil {
+		log.Printf("Failed to load timezone %q for %d: %v", timezone, chatID, err)
+		return b.loc
+	}
+	return loc
+}
+
+// skipLocationButton — отказ поделиться геопозицией при знакомстве
+const skipLocationButton = "Пропустить"
+
+// askLocation предлагает поделиться геопозицией, чтобы определить часовой пояс
+func (b *Bot) askLocation(chatID int64, text string) {
+	keyboard := tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonLocation("📍 Отправить геопозицию")),
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(skipLocationButton)),
+	)
+	keyboard.OneTimeKeyboard = true
+
+	reply := tgbotapi.NewMessage(chatID, text)
+	reply.ReplyMarkup = keyboard
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message to %d: %v", chatID, err)
+	}
+}
+
+// askLocationOnboarding предлагает определить часовой пояс новому пользователю
+func (b *Bot) askLocationOnboarding(chatID int64) {
+	timezone, err := b.storage.GetUserTimezone(chatID)
+	if err != nil {
+		log.Printf("Failed to get timezone for %d: %v", chatID, err)
+		return
+	}
+	if timezone != "" {
+		return
+	}
+
+	b.askLocation(chatID, "🌍 Чтобы напоминания приходили вовремя, поделись геопозицией — я определю твой часовой пояс.\n\n"+
+		"Координаты не сохраняются, только часовой пояс. Выбрать пояс вручную: /timezone")
+}
+
+// handleLocation определяет часовой пояс по присланной геопозиции
+func (b *Bot) handleLocation(msg *tgbotapi.Message) {
+	timezone := timezoneForLocation(msg.Location.Latitude, msg.Location.Longitude)
+	if _, err := loadTimezone(timezone); err != nil {
+		log.Printf("Failed to load detected timezone %q: %v", timezone, err)
+		b.replyWithMainKeyboard(msg.Chat.ID, "Не удалось определить часовой пояс. Выбери его вручную, например: /timezone Europe/Moscow")
+		return
+	}
+	b.setTimezone(msg.Chat.ID, timezone)
+}
+
+// handleSkipLocation оставляет пояс по умолчанию, если пользователь не поделился геопозицией
+func (b *Bot) handleSkipLocation(msg *tgbotapi.Message) {
+	b.setTimezone(msg.Chat.ID, defaultTimezone)
+}
+
+// handleTimezone показывает или меняет часовой пояс: /timezone Europe/Moscow
+func (b *Bot) handleTimezone(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	arg := strings.TrimSpace(msg.CommandArguments())
+
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка загрузки настроек")
+		return
+	}
+
+	switch arg {
+	case "":
+		loc := b.userLoc(chatID)
+		text := fmt.Sprintf("🌍 Часовой пояс: %s (сейчас %s)\n\n", loc, formatClock(newLocale(defaultLocale, loc), b.now())) +
+			"Поделись геопозицией, чтобы определить пояс автоматически, или укажи его вручную:\n/timezone Europe/Moscow\n\n" +
+			"Вернуть пояс по умолчанию: /timezone off"
+		b.askLocation(chatID, text)
+		return
+	case "off", "сброс":
+		b.setTimezone(chatID, defaultTimezone)
+		return
+	}
+
+	if _, err := loadTimezone(arg); err != nil {
+		b.sendMessage(chatID, "⚠️ Неизвестный часовой пояс. Укажи его в формате IANA, например: /timezone Europe/Moscow")
+		return
+	}
+	b.setTimezone(chatID, arg)
+}
+
+// setTimezone сохраняет часовой пояс и возвращает основную клавиатуру
+func (b *Bot) setTimezone(chatID int64, timezone string) {
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+	}
+	if err := b.storage.SetUserTimezone(chatID, timezone); err != nil {
+		log.Printf("Failed to set timezone: %v", err)
+		b.replyWithMainKeyboard(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+
+	loc := b.userLoc(chatID)
+	b.replyWithMainKeyboard(chatID, fmt.Sprintf("✅ Часовой пояс: %s (сейчас %s)\n\nНапоминания приходят по этому времени. Изменить: /timezone",
+		loc, formatClock(newLocale(defaultLocale, loc), b.now())))
+}
+
+// replyWithMainKeyboard отправляет сообщение и возвращает основную клавиатуру
+// вместо клавиатуры с запросом геопозиции
+func (b *Bot) replyWithMainKeyboard(chatID int64, text string) {
+	active := true
+	if user, err := b.storage.GetUser(chatID); err == nil && user != nil {
+		active = user.Active
+	}
+
+	reply := tgbotapi.NewMessage(chatID, text)
+	reply.ReplyMarkup = b.getMainKeyboard(chatID, active)
+	if _, err := b.api.Send(reply); err != nil {
+		log.Printf("Failed to send message to %d: %v", chatID, err)
+	}
+}
+
+// askReminderTimezone просит ввести свой часовой пояс для напоминания —
+// например, для лекарства, которое в поездке принимается по местному времени
+func (b *Bot) askReminderTimezone(chatID int64, messageID int, reminderID int) {
+	reminder, err := b.storage.GetReminder(chatID, reminderID)
+	if err != nil {
+		log.Printf("Failed to get reminder: %v", err)
+		return
+	}
+	if reminder == nil {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	b.mu.Lock()
+	b.pending[chatID] = &PendingReminder{State: StateWaitingReminderTimezone, ReminderID: reminderID, MsgID: messageID, StartedAt: b.now()}
+	b.mu.Unlock()
+
+	text := fmt.Sprintf("🌍 Часовой пояс для 💊 %s\n\nОбычно напоминания приходят по твоему поясу (%s). "+
+		"Если это лекарство нужно принимать по другому времени, введи пояс в формате IANA, например Europe/Berlin.\n\n"+
+		"Отправь «-», чтобы вернуть твой пояс.", displayName(reminder.Medicine), b.userLoc(chatID))
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleReminderTimezoneInput сохраняет введённый часовой пояс напоминания
+func (b *Bot) handleReminderTimezoneInput(msg *tgbotapi.Message, reminderID int) {
+	chatID := msg.Chat.ID
+	timezone := strings.TrimSpace(msg.Text)
+
+	if timezone == "-" {
+		timezone = ""
+	} else if _, err := loadTimezone(timezone); err != nil {
+		b.sendMessage(chatID, "⚠️ Неизвестный часовой пояс. Введи его в формате IANA, например Europe/Berlin, или «-», чтобы вернуть твой пояс:")
+		return
+	}
+
+	b.mu.Lock()
+	p := b.pending[chatID]
+	messageID := 0
+	if p != nil {
+		messageID = p.MsgID
+	}
+	delete(b.pending, chatID)
+	b.mu.Unlock()
+
+	err := b.storage.SetReminderTimezone(chatID, reminderID, timezone)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	case err != nil:
+		log.Printf("Failed to set reminder timezone: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения настройки")
+		return
+	}
+
+	if messageID != 0 {
+		b.deleteMessage(chatID, messageID)
+	}
+	b.handleReminderSettings(chatID, reminderID)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// trendWeeks — за сколько недель /mystats показывает динамику
+const trendWeeks = 4
+
+// trendFlat — изменение меньше стольких процентных пунктов считается "без изменений"
+const trendFlat = 5
+
+// sparkLevels — столбики мини-графика от 0% до 100%
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline рисует соблюдение режима по неделям: столбик на неделю,
+// · — неделя без запланированных доз (перерыв в курсе)
+func sparkline(trend []WeekAdherence) string {
+	var line strings.Builder
+	for _, w := range trend {
+		if w.Taken+w.Missed == 0 {
+			line.WriteRune('·')
+			continue
+		}
+		_, p := adherencePercent(w.Taken, w.Missed)
+		line.WriteRune(sparkLevels[p*(len(sparkLevels)-1)/100])
+	}
+	return line.String()
+}
+
+// trendDirection сравнивает первую и последнюю недели с дозами.
+// "" — сравнивать не с чем: недель с дозами меньше двух.
+func trendDirection(trend []WeekAdherence) string {
+	first, last := -1, -1
+	for i, w := range trend {
+		if w.Taken+w.Missed == 0 {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		last = i
+	}
+	if first < 0 || first == last {
+		return ""
+	}
+
+	_, from := adherencePercent(trend[first].Taken, trend[first].Missed)
+	_, to := adherencePercent(trend[last].Taken, trend[last].Missed)
+	switch diff := to - from; {
+	case diff >= trendFlat:
+		return fmt.Sprintf("↗️ лучше на %d п.п.", diff)
+	case diff <= -trendFlat:
+		return fmt.Sprintf("↘️ хуже на %d п.п.", -diff)
+	default:
+		return "→ без изменений"
+	}
+}
+
+// handleMyStats показывает соблюдение режима по неделям за trendWeeks недель:
+// /mystats — по всем лекарствам, /mystats Аспирин — по одному
+func (b *Bot) handleMyStats(msg *tgbotapi.Message) {
+	chatID := msg.Chat.ID
+	medicine := strings.TrimSpace(msg.CommandArguments())
+
+	l := b.userLocale(chatID, defaultLocale)
+	now := b.now().In(l.Loc)
+	until := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, l.Loc).AddDate(0, 0, 1)
+	since := until.Add(-trendWeeks * 7 * 24 * time.Hour)
+	missedBefore := now.Add(-takenConfirmWindow)
+
+	medicines := []string{medicine}
+	if medicine == "" {
+		adherence, err := b.storage.GetMedicineAdherence(chatID, since, missedBefore)
+		if err != nil {
+			log.Printf("Failed to get medicine adherence: %v", err)
+			b.sendMessage(chatID, "Ошибка загрузки истории")
+			return
+		}
+		medicines = medicines[:0]
+		for _, m := range adherence {
+			if m.Taken+m.Missed > 0 {
+				medicines = append(medicines, m.Medicine)
+			}
+		}
+	}
+
+	var text strings.Builder
+	for _, m := range medicines {
+		trend, err := b.storage.GetMedicineTrend(chatID, m, trendWeeks, until, missedBefore)
+		if err != nil {
+			log.Printf("Failed to get medicine trend: %v", err)
+			b.sendMessage(chatID, "Ошибка загрузки истории")
+			return
+		}
+		if !slices.ContainsFunc(trend, func(w WeekAdherence) bool { return w.Taken+w.Missed > 0 }) {
+			continue
+		}
+
+		text.WriteString(fmt.Sprintf("💊 %s  %s", displayName(m), sparkline(trend)))
+		if direction := trendDirection(trend); direction != "" {
+			text.WriteString("  " + direction)
+		}
+		text.WriteString("\n   ")
+		for i, w := range trend {
+			if i > 0 {
+				text.WriteString(" · ")
+			}
+			percent, _ := adherencePercent(w.Taken, w.Missed)
+			text.WriteString(percent)
+		}
+		text.WriteString("\n\n")
+	}
+
+	if text.Len() == 0 {
+		if medicine != "" {
+			b.sendMessage(chatID, fmt.Sprintf("За последние %d недели приёмов «%s» не записано. Название — как в /list", trendWeeks, medicine))
+		} else {
+			b.sendMessage(chatID, fmt.Sprintf("За последние %d недели приёмов не записано", trendWeeks))
+		}
+		return
+	}
+
+	b.sendLongMessage(chatID, fmt.Sprintf("📈 Соблюдение режима по неделям с %s, от старой недели к последней.\n"+
+		"Недели без запланированных доз отмечены «·» и «—», пропущенные намеренно дозы не учитываются\n\n", formatShortDate(l, since))+
+		strings.TrimRight(text.String(), "\n"))
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// updatesRetryDelay — пауза перед повтором getUpdates после ошибки
+const updatesRetryDelay = 3 * time.Second
+
+// WebAppData — данные, отправленные Web App через Telegram.WebApp.sendData
+type WebAppData struct {
+	Data       string `json:"data"`
+	ButtonText string `json:"button_text"`
+}
+
+// incomingUpdate — обновление Telegram вместе с полями, которых нет в tgbotapi v5.5.1
+type incomingUpdate struct {
+	tgbotapi.Update
+	WebAppData *WebAppData // message.web_app_data (nil — обычное сообщение)
+}
+
+// updateExtras — поля обновления, которые tgbotapi не разбирает
+type updateExtras struct {
+	Message *struct {
+		WebAppData *WebAppData `json:"web_app_data"`
+	} `json:"message"`
+}
+
+// decodeUpdates разбирает ответ getUpdates: основные поля — типами tgbotapi,
+// недостающие — из того же JSON
+func decodeUpdates(raw json.RawMessage) ([]incomingUpdate, error) {
+	var updates []tgbotapi.Update
+	if err := json.Unmarshal(raw, &updates); err != nil {
+		return nil, err
+	}
+	var extras []updateExtras
+	if err := json.Unmarshal(raw, &extras); err != nil {
+		return nil, err
+	}
+
+	result := make([]incomingUpdate, len(updates))
+	for i, u := range updates {
+		result[i].Update = u
+		if i < len(extras) && extras[i].Message != nil {
+			result[i].WebAppData = extras[i].Message.WebAppData
+		}
+	}
+	return result, nil
+}
+
+// pollUpdates получает обновления через long polling, пока не будет отменён ctx.
+// После отмены канал закрывается сразу, не дожидаясь завершения текущего getUpdates;
+// полученные, но не переданные обработчику обновления Telegram пришлёт снова.
+func (b *Bot) pollUpdates(ctx context.Context, timeout int) <-chan incomingUpdate {
+	in := make(chan incomingUpdate)
+	out := make(chan incomingUpdate)
+
+	go func() {
+		offset := 0
+		for ctx.Err() == nil {
+			params := tgbotapi.Params{}
+			params.AddNonZero("offset", offset)
+			params.AddNonZero("timeout", timeout)
+
+			resp, err := b.api.MakeRequest("getUpdates", params)
+			if err == nil {
+				var updates []incomingUpdate
+				if updates, err = decodeUpdates(resp.Result); err == nil {
+					for _, u := range updates {
+						if u.UpdateID < offset {
+							continue
+						}
+						offset = u.UpdateID + 1
+						select {
+						case in <- u:
+						case <-ctx.Done():
+							return
+						}
+					}
+					continue
+				}
+			}
+
+			log.Printf("Failed to get updates, retrying in %s: %v", updatesRetryDelay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(updatesRetryDelay):
+			}
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-in:
+				select {
+				case out <- u:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out
+}
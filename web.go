@@ -0,0 +1,202 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//go:embed web
+var embeddedWeb embed.FS
+
+// Окно истории приёмов для /api/history
+const (
+	defaultHistoryDays = 30
+	maxHistoryDays     = 365
+)
+
+// startWebServer регистрирует обработчики, занимает порт и запускает сервер в фоне.
+// Ошибка (например, порт занят) возвращается сразу — main решает, останавливать ли бота.
+func startWebServer(bot *Bot) error {
+	port := os.Getenv("WEB_PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Статические файлы встроены в бинарник; WEB_DIR позволяет
+	// раздавать их с диска (удобно при разработке фронтенда)
+	var static fs.FS
+	if webDir := os.Getenv("WEB_DIR"); webDir != "" {
+		static = os.DirFS(webDir)
+		log.Printf("Serving web assets from %s", webDir)
+	} else {
+		sub, err := fs.Sub(embeddedWeb, "web")
+		if err != nil {
+			return fmt.Errorf("failed to load embedded web assets: %w", err)
+		}
+		static = sub
+	}
+
+	// Статические файлы с SPA-фолбэком на index.html
+	http.Handle("/", spaHandler(static))
+
+	// Неизвестные пути API не должны попадать в SPA-фолбэк
+	http.Handle("/api/", apiHandler(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+	}))
+
+	// API для получения напоминаний
+	http.Handle("/api/reminders", apiHandler(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := bot.requireUser(w, r)
+		if !ok {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"user": map[string]string{
+				"first_name": user.FirstName,
+				"username":   user.UserName,
+			},
+			"reminders": bot.GetUserReminders(user.ID),
+		})
+	}))
+
+	// API истории приёмов для календаря: /api/history?days=30
+	http.Handle("/api/history", apiHandler(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := bot.requireUser(w, r)
+		if !ok {
+			return
+		}
+		chatID := user.ID
+
+		days := defaultHistoryDays
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeJSONError(w, http.StatusBadRequest, "days must be a positive integer")
+				return
+			}
+			days = min(n, maxHistoryDays)
+		}
+
+		// Начало окна — полночь (days-1) дней назад по времени пользователя
+		loc := bot.userLoc(chatID)
+		now := bot.now().In(loc)
+		since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, -(days - 1))
+
+		history, err := bot.storage.GetDoseHistory(chatID, since, now.Add(-takenConfirmWindow), loc)
+		if err != nil {
+			log.Printf("Failed to get dose history: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		notes, err := bot.storage.GetDoseNotes(chatID, since)
+		if err != nil {
+			log.Printf("Failed to get dose notes: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"days":    days,
+			"history": history,
+			"notes":   notes,
+		})
+	}))
+
+	// Метрики планировщика в формате Prometheus
+	http.HandleFunc("/metrics", metricsHandler)
+
+	ln, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return fmt.Errorf("failed to listen on :%s: %w", port, err)
+	}
+
+	log.Printf("Starting web server on :%s", port)
+	go func() {
+		if err := http.Serve(ln, nil); err != nil {
+			log.Printf("Web server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// spaHandler раздаёт статические файлы из static, а для клиентских маршрутов
+// (путей без расширения, которых нет в static) отдаёт index.html.
+// Отсутствующие файлы с расширением (ассеты) по-прежнему дают 404.
+func spaHandler(static fs.FS) http.Handler {
+	fileServer := http.FileServer(http.FS(static))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "."
+		}
+		if _, err := fs.Stat(static, name); err == nil || path.Ext(name) != "" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		http.ServeFileFS(w, r, static, "index.html")
+	})
+}
+
+// apiHandler добавляет CORS-заголовки и отвечает на preflight-запросы
+func apiHandler(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Telegram-Init-Data")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// writeJSON отправляет JSON-ответ с указанным статусом
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+// writeJSONError отправляет ошибку в едином формате {"error": "..."}
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// requireUser извлекает пользователя из подписанного Telegram Web App initData
+// и обновляет сохранённые username, имя и язык. Неверная или устаревшая
+// подпись — 401: иначе любой мог бы прочитать чужие напоминания, подставив ID.
+// При ошибке сам пишет ответ и возвращает ok=false.
+func (b *Bot) requireUser(w http.ResponseWriter, r *http.Request) (user *tgbotapi.User, ok bool) {
+	initData := r.Header.Get("X-Telegram-Init-Data")
+	if initData == "" {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	user, err := validateInitData(initData, b.api.Token, b.now())
+	if err != nil {
+		log.Printf("Rejected Web App initData from %s: %v", r.RemoteAddr, err)
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return nil, false
+	}
+
+	b.rememberUser(user)
+	return user, true
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Действия, которые Web App может отправить через sendData
+const (
+	webAppActionAdd     = "add"
+	webAppActionDelete  = "delete"
+	webAppActionConfirm = "confirm"
+)
+
+// webAppRequest — данные, которые Web App отправляет через sendData:
+//
+//	{"action": "add", "medicine": "Витамин D", "hour": 8, "minute": 0, "course_days": 30, "require_confirm": false}
+//	{"action": "delete", "id": 12}
+//	{"action": "confirm", "id": 12}
+//
+// Без action запрос считается добавлением напоминания.
+type webAppRequest struct {
+	Action string `json:"action"`
+	ID     int    `json:"id"` // напоминание для delete и confirm
+
+	webAppReminder
+}
+
+// webAppReminder — поля нового напоминания для action=add
+type webAppReminder struct {
+	Medicine   string `json:"medicine"`
+	Hour       int    `json:"hour"`
+	Minute     int    `json:"minute"`
+	CourseDays int    `json:"course_days"` // 0 — бесконечно
+	CourseType string `json:"course_type"` // "doses" (по умолчанию) или "days"
+
+	RequireConfirm *bool `json:"require_confirm"` // false — без кнопки "Принял"; по умолчанию true
+}
+
+// parseWebAppRequest разбирает и проверяет данные Web App.
+// Неизвестные поля считаются ошибкой, чтобы опечатка не превратилась в значение по умолчанию.
+// maxCourseDays — наибольшая длина курса (Storage.MaxCourseDays), minuteStep —
+// шаг минут пользователя (/settings), как в диалоге /add.
+func parseWebAppRequest(data string, maxCourseDays, minuteStep int) (webAppRequest, error) {
+	var req webAppRequest
+
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("неверный формат данных")
+	}
+	if dec.More() {
+		return req, fmt.Errorf("неверный формат данных")
+	}
+
+	if req.Action == "" {
+		req.Action = webAppActionAdd
+	}
+
+	switch req.Action {
+	case webAppActionAdd:
+		if req.ID != 0 {
+			return req, fmt.Errorf("для добавления не нужен id")
+		}
+		return req, req.webAppReminder.validate(maxCourseDays, minuteStep)
+	case webAppActionDelete, webAppActionConfirm:
+		if req.ID <= 0 {
+			return req, fmt.Errorf("не указано напоминание")
+		}
+		if req.webAppReminder != (webAppReminder{}) {
+			return req, fmt.Errorf("лишние поля для действия %q", req.Action)
+		}
+		return req, nil
+	default:
+		return req, fmt.Errorf("неизвестное действие %q", req.Action)
+	}
+}
+
+// validate проверяет напоминание так же, как диалог /add
+func (r *webAppReminder) validate(maxCourseDays, minuteStep int) error {
+	r.Medicine = strings.TrimSpace(r.Medicine)
+	switch {
+	case r.Medicine == "":
+		return fmt.Errorf("не указано название лекарства")
+	case utf8.RuneCountInString(r.Medicine) > maxMedicineLength:
+		return fmt.Errorf("слишком длинное название (макс %d символов)", maxMedicineLength)
+	case r.Hour < 0 || r.Hour > 23:
+		return fmt.Errorf("час должен быть от 0 до 23")
+	case r.Minute < 0 || r.Minute > 59 || r.Minute%minuteStep != 0:
+		return fmt.Errorf("минуты должны быть %s", minuteStepHint(minuteStep))
+	case r.CourseDays < 0 || r.CourseDays > maxCourseDays:
+		return fmt.Errorf("курс должен быть от 0 до %d дней", maxCourseDays)
+	case r.CourseType != "" && r.CourseType != CourseByDoses && r.CourseType != CourseByDays:
+		return fmt.Errorf("тип курса должен быть %q или %q", CourseByDoses, CourseByDays)
+	}
+	return nil
+}
+
+// handleWebAppData выполняет действие, отправленное Web App.
+// Такие сообщения никогда не попадают в обработку команд и текста.
+func (b *Bot) handleWebAppData(msg *tgbotapi.Message, data *WebAppData) {
+	chatID := msg.Chat.ID
+
+	req, err := parseWebAppRequest(data.Data, b.storage.MaxCourseDays(), b.minuteStep(chatID))
+	if err != nil {
+		log.Printf("Invalid web app data from %d: %v", chatID, err)
+		b.sendMessage(chatID, "⚠️ Не удалось выполнить действие из приложения: "+err.Error())
+		return
+	}
+
+	switch req.Action {
+	case webAppActionAdd:
+		b.webAppAddReminder(chatID, req.webAppReminder)
+	case webAppActionDelete:
+		b.webAppDeleteReminder(chatID, req.ID)
+	case webAppActionConfirm:
+		b.webAppConfirmDose(chatID, req.ID)
+	}
+}
+
+// webAppAddReminder создаёт напоминание из Web App
+func (b *Bot) webAppAddReminder(chatID int64, payload webAppReminder) {
+	if _, _, err := b.storage.GetOrCreateUser(chatID); err != nil {
+		log.Printf("Failed to create user %d: %v", chatID, err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+
+	reminder := Reminder{
+		Medicine:   payload.Medicine,
+		Hour:       payload.Hour,
+		Minute:     payload.Minute,
+		CourseDays: payload.CourseDays,
+		CourseType: payload.CourseType,
+	}
+	l := b.userLocale(chatID, defaultLocale)
+	reminder.StartsAt = firstOccurrence(b.now().In(l.Loc), reminder.Hour, reminder.Minute)
+
+	id, err := b.storage.AddReminder(chatID, reminder, ReminderSourceWebApp)
+	if err != nil {
+		log.Printf("Failed to add reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка сохранения. Попробуй снова")
+		return
+	}
+	reminder.ID = id
+
+	if payload.RequireConfirm != nil && !*payload.RequireConfirm {
+		if err := b.storage.SetReminderRequireConfirm(chatID, id, false); err != nil {
+			log.Printf("Failed to set reminder require confirm: %v", err)
+		}
+	}
+
+	courseStr := courseLengthString(reminder.CourseDays, reminder.CourseType)
+	b.sendMessage(chatID, fmt.Sprintf("✅ Напоминание добавлено из приложения!\n\n💊 %s\n⏰ %s\n📅 Курс: %s\n🚀 Первый приём: %s\n\nИспользуй /list чтобы увидеть все напоминания",
+		reminder.Medicine, reminder.TimeLabel(), courseStr, b.relativeDateTime(l, reminder.StartsAt)))
+	b.warnReminderConflicts(chatID, reminder)
+	b.reactivateAfterAdd(chatID)
+}
+
+// webAppDeleteReminder удаляет напоминание по запросу Web App
+func (b *Bot) webAppDeleteReminder(chatID int64, reminderID int) {
+	err := b.storage.DeleteReminder(chatID, reminderID)
+	switch {
+	case errors.Is(err, ErrReminderNotFound):
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+	case err != nil:
+		log.Printf("Failed to delete reminder: %v", err)
+		b.sendMessage(chatID, "Ошибка удаления. Попробуй снова: /list")
+	default:
+		b.sendMessage(chatID, "🗑 Напоминание удалено")
+	}
+}
+
+// webAppConfirmDose подтверждает последний неподтверждённый приём напоминания
+func (b *Bot) webAppConfirmDose(chatID int64, reminderID int) {
+	doses, err := b.storage.GetPendingDoses(chatID, b.now().Add(-takenConfirmWindow))
+	if err != nil {
+		log.Printf("Failed to get pending doses: %v", err)
+		b.sendMessage(chatID, "Ошибка загрузки напоминаний")
+		return
+	}
+
+	// Дозы отсортированы от новых к старым — берём последнюю
+	var slot time.Time
+	for _, d := range doses {
+		if d.ReminderID == reminderID {
+			slot = d.ScheduledAt
+			break
+		}
+	}
+	if slot.IsZero() {
+		b.sendMessage(chatID, "Нет неподтверждённого приёма этого лекарства 👌")
+		return
+	}
+
+	text, completionText, err := b.confirmDose(chatID, reminderID, slot)
+	switch {
+	case errors.Is(err, ErrDoseAlreadyTaken):
+		b.sendMessage(chatID, "Этот приём уже отмечен 👌")
+		return
+	case errors.Is(err, ErrCourseCompleted):
+		b.sendMessage(chatID, "🏁 Курс уже завершён — этот приём отмечать не нужно")
+		return
+	case err != nil:
+		b.sendMessage(chatID, "Напоминание не найдено — возможно, оно уже удалено")
+		return
+	}
+
+	b.sendMessage(chatID, text)
+	if completionText != "" {
+		b.sendMessage(chatID, completionText)
+	}
+	b.offerLinkedTaken(chatID, reminderID, slot)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseWebAppRequestMinuteStep(t *testing.T) {
+	tests := []struct {
+		step   int
+		minute int
+		ok     bool
+	}{
+		{15, 0, true},
+		{15, 45, true},
+		{15, 5, false},
+		{15, 50, false},
+		{5, 5, true},
+		{5, 55, true},
+		{5, 7, false},
+		{5, 60, false},
+		{5, -5, false},
+		{30, 30, true},
+		{30, 15, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("шаг %d, %02d", tt.step, tt.minute), func(t *testing.T) {
+			data := fmt.Sprintf(`{"action": "add", "medicine": "Аспирин", "hour": 8, "minute": %d}`, tt.minute)
+			_, err := parseWebAppRequest(data, defaultMaxCourseDays, tt.step)
+			if tt.ok && err != nil {
+				t.Errorf("parseWebAppRequest(minute=%d, step=%d): %v", tt.minute, tt.step, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("parseWebAppRequest(minute=%d, step=%d) = nil error, want rejection", tt.minute, tt.step)
+			}
+		})
+	}
+}
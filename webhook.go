@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Ограничения доставки webhook: сбои внешней системы не должны влиять на работу бота
+const (
+	webhookTimeout     = 5 * time.Second
+	webhookAttempts    = 3
+	webhookRetryDelay  = 2 * time.Second
+	webhookConcurrency = 4
+)
+
+// webhookClient не следует редиректам, чтобы запрос не ушёл на http-адрес
+var webhookClient = &http.Client{
+	Timeout: webhookTimeout,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookSlots ограничивает число одновременных доставок
+var webhookSlots = make(chan struct{}, webhookConcurrency)
+
+// missedDosePayload — тело запроса о пропущенной дозе
+type missedDosePayload struct {
+	ChatID        int64     `json:"chat_id"`
+	Medicine      string    `json:"medicine"`
+	ScheduledTime time.Time `json:"scheduled_time"`
+	Status        string    `json:"status"`
+}
+
+// validateWebhookURL проверяет, что адрес абсолютный и использует https
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("некорректный адрес")
+	}
+	if u.Scheme != "https" {
+		return errors.New("адрес должен начинаться с https://")
+	}
+	return nil
+}
+
+// notifyMissedDoses отправляет пропущенные дозы на webhook пользователей в фоне.
+// Если все слоты доставки заняты, событие пропускается с записью в лог.
+func notifyMissedDoses(doses []MissedDose) {
+	for _, d := range doses {
+		if d.WebhookURL == "" {
+			continue
+		}
+
+		select {
+		case webhookSlots <- struct{}{}:
+			go func(d MissedDose) {
+				defer func() { <-webhookSlots }()
+				if err := postMissedDose(d); err != nil {
+					log.Printf("Failed to deliver missed dose webhook for %d: %v", d.ChatID, err)
+				}
+			}(d)
+		default:
+			log.Printf("Dropping missed dose webhook for %d: too many deliveries in flight", d.ChatID)
+		}
+	}
+}
+
+// postMissedDose отправляет событие, повторяя попытку при сетевых ошибках и ответах 5xx/429
+func postMissedDose(d MissedDose) error {
+	body, err := json.Marshal(missedDosePayload{
+		ChatID:        d.ChatID,
+		Medicine:      d.Medicine,
+		ScheduledTime: d.ScheduledAt,
+		Status:        DoseMissed,
+	})
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		retry, err := postWebhook(d.WebhookURL, body)
+		if err == nil || !retry || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(webhookRetryDelay)
+	}
+}
+
+// postWebhook выполняет один запрос и сообщает, имеет ли смысл повтор
+func postWebhook(webhookURL string, body []byte) (retry bool, err error) {
+	resp, err := webhookClient.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("webhook returned %s", resp.Status)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// allWeekdays — маска дней недели "каждый день": бит 0 — понедельник … бит 6 — воскресенье
+const allWeekdays = 0x7f
+
+// weekdayNames — короткие названия дней недели в порядке битов маски, с понедельника
+var weekdayNames = [...]string{"пн", "вт", "ср", "чт", "пт", "сб", "вс"}
+
+// weekdayBit возвращает номер бита маски для дня недели (понедельник — 0)
+func weekdayBit(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// weekdays возвращает дни недели напоминания. Пустая маска — напоминание ещё
+// не сохранено (назначение /import, Web App): в базу оно попадёт как каждый день.
+func (r Reminder) weekdays() int {
+	if r.WeekdayMask&allWeekdays == 0 {
+		return allWeekdays
+	}
+	return r.WeekdayMask & allWeekdays
+}
+
+// OnWeekday проверяет, приходит ли напоминание в день недели d
+func (r Reminder) OnWeekday(d time.Weekday) bool {
+	return r.weekdays()&(1<<weekdayBit(d)) != 0
+}
+
+// nextOnWeekday возвращает t или тот же час в ближайший следующий день,
+// в который приходит напоминание, — первый приём нового напоминания
+func nextOnWeekday(t time.Time, r Reminder) time.Time {
+	for range 7 {
+		if r.OnWeekday(t.Weekday()) {
+			break
+		}
+		t = t.AddDate(0, 0, 1)
+	}
+	return t
+}
+
+// weekdays возвращает выбранные в диалоге дни недели (0 — ещё не выбирали, каждый день)
+func (p PendingReminder) weekdays() int {
+	if p.WeekdayMask == 0 {
+		return allWeekdays
+	}
+	return p.WeekdayMask
+}
+
+// formatWeekdays описывает дни недели: "каждый день", "по будням", "пн, ср, пт"
+func formatWeekdays(mask int) string {
+	switch mask & allWeekdays {
+	case allWeekdays:
+		return "каждый день"
+	case 0x1f:
+		return "по будням"
+	case 0x60:
+		return "по выходным"
+	}
+
+	var days []string
+	for bit, name := range weekdayNames {
+		if mask&(1<<bit) != 0 {
+			days = append(days, name)
+		}
+	}
+	return strings.Join(days, ", ")
+}
+
+// weekdayKeyboard — переключатели дней недели: выбранные отмечены ✓
+func weekdayKeyboard(mask int) tgbotapi.InlineKeyboardMarkup {
+	var days []tgbotapi.InlineKeyboardButton
+	for bit, name := range weekdayNames {
+		label := name
+		if mask&(1<<bit) != 0 {
+			label = "✓ " + name
+		}
+		days = append(days, tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("weekday_%d", bit)))
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(
+		days[:4],
+		days[4:],
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("✅ Готово", "weekdays_done")),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "cancel")),
+	)
+}
+
+// handleWeekdays показывает выбор дней недели в диалоге /add. bit >= 0 —
+// переключить этот день; последний выбранный день не снимается, иначе
+// напоминание не пришло бы никогда.
+func (b *Bot) handleWeekdays(chatID int64, messageID int, bit int) {
+	b.mu.Lock()
+	p := b.pending[chatID]
+	if p == nil || p.Medicine == "" || p.State != StateWaitingCourse {
+		b.mu.Unlock()
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	mask := p.weekdays()
+	if bit >= 0 && bit < len(weekdayNames) && mask != 1<<bit {
+		mask ^= 1 << bit
+	}
+	p.WeekdayMask = mask
+	medicine := p.Medicine
+	b.mu.Unlock()
+
+	text := fmt.Sprintf("💊 %s\n📆 %s\n\nВ какие дни недели напоминать? Нажми на день, чтобы включить или выключить его.",
+		medicine, formatWeekdays(mask))
+	keyboard := weekdayKeyboard(mask)
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Failed to edit message: %v", err)
+	}
+}
+
+// handleWeekdaysDone возвращает к выбору курса после выбора дней недели
+func (b *Bot) handleWeekdaysDone(chatID int64, messageID int) {
+	p, ok := b.pendingSnapshot(chatID)
+	if !ok || p.Medicine == "" || p.State != StateWaitingCourse {
+		b.deleteMessage(chatID, messageID)
+		b.sendMessage(chatID, "Ошибка. Попробуй снова: /add")
+		return
+	}
+	b.showCourseSelection(chatID, messageID, p)
+}